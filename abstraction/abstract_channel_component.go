@@ -1,6 +1,7 @@
 package abstraction
 
 import (
+	"context"
 	"log/slog"
 
 	"github.com/Kotaro7750/notifier/notification"
@@ -26,3 +27,16 @@ type AbstractChannelComponent interface {
 	// normally. Implementations should then return and must not restart themselves.
 	Start(ch chan notification.Notification, done <-chan struct{}) <-chan error
 }
+
+// PendingReporter is implemented by AbstractChannelComponents that hold notifications which
+// they have not processed yet, such as senders that group notifications.
+type PendingReporter interface {
+	Pending() []notification.Notification
+}
+
+// Flusher is implemented by AbstractChannelComponents that hold notifications back on purpose,
+// such as senders that group or rate limit them. Flush asks the running component to process
+// them now, and returns once it holds none it can process or ctx is done.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
@@ -1,6 +1,7 @@
 package abstraction
 
 import (
+	"context"
 	"log/slog"
 	"sync"
 	"time"
@@ -9,13 +10,17 @@ import (
 )
 
 // AutonomousChannelComponent supervises an AbstractChannelComponent and owns its runtime
-// lifecycle, including start, restart after failure, and coordinated shutdown.
+// lifecycle, including start, restart after failure according to its RestartPolicy, and
+// coordinated shutdown.
 type AutonomousChannelComponent struct {
 	chanComponent  AbstractChannelComponent
 	ch             chan notification.Notification
 	shutdownCh     chan struct{}
 	isStarted      bool
 	isShuttingDown bool
+	hasGivenUp     bool
+	restartPolicy  RestartPolicy
+	eventHandler   EventHandler
 	lock           sync.Mutex
 }
 
@@ -26,10 +31,29 @@ func NewAutonomousChannelComponent(chanComponent AbstractChannelComponent) *Auto
 		shutdownCh:     make(chan struct{}),
 		isStarted:      false,
 		isShuttingDown: false,
+		hasGivenUp:     false,
+		restartPolicy:  DefaultRestartPolicy(),
+		eventHandler:   nil,
 		lock:           sync.Mutex{},
 	}
 }
 
+// SetRestartPolicy replaces the restart policy. It takes effect on the next Start.
+func (acc *AutonomousChannelComponent) SetRestartPolicy(policy RestartPolicy) {
+	acc.lock.Lock()
+	defer acc.lock.Unlock()
+
+	acc.restartPolicy = policy
+}
+
+// SetEventHandler registers the handler that receives lifecycle events of the component.
+func (acc *AutonomousChannelComponent) SetEventHandler(handler EventHandler) {
+	acc.lock.Lock()
+	defer acc.lock.Unlock()
+
+	acc.eventHandler = handler
+}
+
 func (acc *AutonomousChannelComponent) Start() <-chan struct{} {
 	acc.chanComponent.GetLogger().Info("Start invoked")
 	acc.lock.Lock()
@@ -40,50 +64,186 @@ func (acc *AutonomousChannelComponent) Start() <-chan struct{} {
 		return nil
 	}
 	acc.isStarted = true
+	acc.hasGivenUp = false
 	acc.shutdownCh = make(chan struct{})
 
 	completedCh := make(chan struct{})
+	tracker := newRestartTracker(acc.restartPolicy)
 
 	go func(stopCh <-chan struct{}) {
 		defer close(completedCh)
+		defer acc.finishShutdown()
+
 		for {
 			acc.chanComponent.GetLogger().Info("Starting")
-			select {
-			case err := <-acc.chanComponent.Start(acc.ch, stopCh):
-				if err != nil {
-					acc.chanComponent.GetLogger().Error("Error in channel component", "error", err)
-				}
+			startedAt := time.Now()
+			err := <-acc.chanComponent.Start(acc.ch, stopCh)
+			if err != nil {
+				acc.chanComponent.GetLogger().Error("Error in channel component", "error", err)
+				acc.emit(Event{Type: EventFailed, Err: err})
+			}
 
-				acc.lock.Lock()
-				if acc.isShuttingDown {
-					defer acc.lock.Unlock()
-					defer close(acc.ch)
-					acc.chanComponent.GetLogger().Info("Shutting down")
-
-					acc.isStarted = false
-					acc.isShuttingDown = false
-					return
+			if acc.shuttingDown() {
+				return
+			}
+
+			backoff, ok := tracker.next(err, startedAt, time.Now())
+			if !ok {
+				// A policy other than always declines to restart a successful execution, which
+				// is a normal end rather than giving up.
+				ended := err == nil && tracker.policy.Mode != RestartAlways
+				if ended {
+					acc.chanComponent.GetLogger().Info("Channel component ended, not restarting", "policy", tracker.policy.Mode)
+				} else {
+					acc.chanComponent.GetLogger().Error("Give up restarting channel component", "policy", tracker.policy.Mode)
 				}
+				acc.lock.Lock()
+				acc.hasGivenUp = true
 				acc.lock.Unlock()
+				if !ended {
+					acc.emit(Event{Type: EventGaveUp, Err: err})
+				}
+				acc.discardUntil(stopCh)
+				return
 			}
 
-			acc.chanComponent.GetLogger().Info("Restart after 1s")
+			acc.chanComponent.GetLogger().Info("Restart after backoff", "backoff", backoff)
+
+			select {
+			case <-time.After(backoff):
+			case <-stopCh:
+				return
+			}
 
-			time.Sleep(1 * time.Second)
+			acc.emit(Event{Type: EventRestarted, Err: err})
 		}
 	}(acc.shutdownCh)
 
 	return completedCh
 }
 
+func (acc *AutonomousChannelComponent) shuttingDown() bool {
+	acc.lock.Lock()
+	defer acc.lock.Unlock()
+
+	return acc.isShuttingDown
+}
+
+func (acc *AutonomousChannelComponent) finishShutdown() {
+	acc.lock.Lock()
+	defer acc.lock.Unlock()
+
+	acc.chanComponent.GetLogger().Info("Shutting down")
+	close(acc.ch)
+
+	acc.isStarted = false
+	acc.isShuttingDown = false
+}
+
+// discardUntil drops notifications written to a component that will not be restarted, so
+// that writers such as the router are not blocked forever, until shutdown is requested.
+func (acc *AutonomousChannelComponent) discardUntil(stopCh <-chan struct{}) {
+	for {
+		select {
+		case n := <-acc.ch:
+			acc.chanComponent.GetLogger().Warn("Notification discarded because channel component is stopped", "title", n.Title)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (acc *AutonomousChannelComponent) emit(event Event) {
+	acc.lock.Lock()
+	handler := acc.eventHandler
+	acc.lock.Unlock()
+
+	if handler == nil {
+		return
+	}
+
+	event.ComponentId = acc.chanComponent.GetId()
+	event.Time = time.Now()
+	handler(event)
+}
+
+// GetComponent returns the supervised component.
+func (acc *AutonomousChannelComponent) GetComponent() AbstractChannelComponent {
+	return acc.chanComponent
+}
+
 func (acc *AutonomousChannelComponent) GetChannel() chan notification.Notification {
 	return acc.ch
 }
 
+func (acc *AutonomousChannelComponent) GetId() string {
+	return acc.chanComponent.GetId()
+}
+
 func (acc *AutonomousChannelComponent) GetLogger() *slog.Logger {
 	return acc.chanComponent.GetLogger()
 }
 
+// Status reports whether the component is running, with the details of components that
+// implement StatusReporter.
+func (acc *AutonomousChannelComponent) Status() ComponentStatus {
+	acc.lock.Lock()
+	running := acc.isStarted && !acc.hasGivenUp
+	acc.lock.Unlock()
+
+	status := ComponentStatus{
+		Id:      acc.chanComponent.GetId(),
+		Running: running,
+	}
+	if reporter, ok := acc.chanComponent.(StatusReporter); ok {
+		status.Details = reporter.Status()
+	}
+	return status
+}
+
+// Metrics reports the running state of the component together with the metrics of components
+// that implement StatusReporter.
+func (acc *AutonomousChannelComponent) Metrics() []Metric {
+	status := acc.Status()
+
+	running := 0.0
+	if status.Running {
+		running = 1
+	}
+
+	metrics := []Metric{
+		{
+			Name:   "notifier_component_running",
+			Help:   "Whether the component is running.",
+			Type:   MetricTypeGauge,
+			Labels: map[string]string{"id": status.Id},
+			Value:  running,
+		},
+	}
+	if reporter, ok := acc.chanComponent.(StatusReporter); ok {
+		metrics = append(metrics, reporter.Metrics()...)
+	}
+	return metrics
+}
+
+// Pending returns the notifications still held by a component that implements PendingReporter.
+// It must be called after the component has completed shutdown.
+func (acc *AutonomousChannelComponent) Pending() []notification.Notification {
+	if reporter, ok := acc.chanComponent.(PendingReporter); ok {
+		return reporter.Pending()
+	}
+	return nil
+}
+
+// Flush asks a component that implements Flusher to process the notifications it holds back.
+// It must be called before Shutdown.
+func (acc *AutonomousChannelComponent) Flush(ctx context.Context) error {
+	if flusher, ok := acc.chanComponent.(Flusher); ok {
+		return flusher.Flush(ctx)
+	}
+	return nil
+}
+
 func (acc *AutonomousChannelComponent) Shutdown() {
 	acc.chanComponent.GetLogger().Info("Shutdown invoked")
 	acc.lock.Lock()
@@ -0,0 +1,134 @@
+package abstraction
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+// failingComponent fails every execution immediately.
+type failingComponent struct {
+	starts chan struct{}
+}
+
+func (fc *failingComponent) GetId() string {
+	return "failing"
+}
+
+func (fc *failingComponent) GetLogger() *slog.Logger {
+	return slog.Default()
+}
+
+func (fc *failingComponent) SetLogger(logger *slog.Logger) {}
+
+func (fc *failingComponent) Start(ch chan notification.Notification, done <-chan struct{}) <-chan error {
+	retCh := make(chan error, 1)
+	retCh <- errors.New("failed")
+	close(retCh)
+	fc.starts <- struct{}{}
+	return retCh
+}
+
+func TestAutonomousChannelComponentShutdownInterruptsBackoff(t *testing.T) {
+	component := &failingComponent{starts: make(chan struct{}, 1)}
+	acc := NewAutonomousChannelComponent(component)
+	acc.SetRestartPolicy(RestartPolicy{Mode: RestartAlways, InitialBackoff: time.Hour, Multiplier: 1})
+
+	completedCh := acc.Start()
+	<-component.starts
+
+	acc.Shutdown()
+	select {
+	case <-completedCh:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown did not interrupt backoff")
+	}
+}
+
+func TestAutonomousChannelComponentEmitsGaveUpEvent(t *testing.T) {
+	component := &failingComponent{starts: make(chan struct{}, 2)}
+	acc := NewAutonomousChannelComponent(component)
+	acc.SetRestartPolicy(RestartPolicy{Mode: RestartAlways, Multiplier: 1, MaxRestarts: 1})
+
+	events := make(chan Event, 4)
+	acc.SetEventHandler(func(event Event) {
+		events <- event
+	})
+
+	completedCh := acc.Start()
+
+	want := []EventType{EventFailed, EventRestarted, EventFailed, EventGaveUp}
+	for _, w := range want {
+		select {
+		case event := <-events:
+			if event.Type != w {
+				t.Fatalf("event = %s, want %s", event.Type, w)
+			}
+			if event.ComponentId != "failing" {
+				t.Fatalf("ComponentId = %q, want %q", event.ComponentId, "failing")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %s was not emitted", w)
+		}
+	}
+
+	// Writers must not block on a component that gave up.
+	select {
+	case acc.GetChannel() <- notification.Notification{Title: "discarded"}:
+	case <-time.After(time.Second):
+		t.Fatal("write to stopped component blocked")
+	}
+
+	acc.Shutdown()
+	<-completedCh
+}
+
+// endingComponent ends every execution without error.
+type endingComponent struct {
+	starts chan struct{}
+}
+
+func (ec *endingComponent) GetId() string {
+	return "ending"
+}
+
+func (ec *endingComponent) GetLogger() *slog.Logger {
+	return slog.Default()
+}
+
+func (ec *endingComponent) SetLogger(logger *slog.Logger) {}
+
+func (ec *endingComponent) Start(ch chan notification.Notification, done <-chan struct{}) <-chan error {
+	retCh := make(chan error)
+	close(retCh)
+	ec.starts <- struct{}{}
+	return retCh
+}
+
+func TestAutonomousChannelComponentDoesNotGiveUpOnNormalEnd(t *testing.T) {
+	for _, mode := range []RestartMode{RestartOnFailure, RestartNever} {
+		component := &endingComponent{starts: make(chan struct{}, 1)}
+		acc := NewAutonomousChannelComponent(component)
+		acc.SetRestartPolicy(RestartPolicy{Mode: mode, Multiplier: 1})
+
+		events := make(chan Event, 1)
+		acc.SetEventHandler(func(event Event) {
+			events <- event
+		})
+
+		completedCh := acc.Start()
+		<-component.starts
+
+		select {
+		case event := <-events:
+			t.Fatalf("%s: event %s emitted for a normal end", mode, event.Type)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		acc.Shutdown()
+		<-completedCh
+	}
+}
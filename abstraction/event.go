@@ -0,0 +1,29 @@
+package abstraction
+
+import (
+	"time"
+)
+
+type EventType string
+
+const (
+	// EventFailed is emitted when an execution of a component ends with an error.
+	EventFailed EventType = "failed"
+	// EventGaveUp is emitted when the restart policy decides not to restart a component again.
+	EventGaveUp EventType = "gave_up"
+	// EventRestarted is emitted when a component is started again after an execution ended.
+	// Err is the error of the previous execution, if any.
+	EventRestarted EventType = "restarted"
+)
+
+// Event describes a change in the lifecycle of a supervised component.
+type Event struct {
+	Type        EventType
+	ComponentId string
+	Err         error
+	Time        time.Time
+}
+
+// EventHandler receives lifecycle events from AutonomousChannelComponent. It is called from the
+// supervising goroutine, so it must not block.
+type EventHandler func(event Event)
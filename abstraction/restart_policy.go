@@ -0,0 +1,114 @@
+package abstraction
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+type RestartMode string
+
+const (
+	// RestartAlways restarts the component whenever an execution ends.
+	RestartAlways RestartMode = "always"
+	// RestartOnFailure restarts the component only when an execution ends with an error.
+	RestartOnFailure RestartMode = "on-failure"
+	// RestartNever leaves the component stopped after its first execution ends.
+	RestartNever RestartMode = "never"
+)
+
+// RestartPolicy decides whether and when AutonomousChannelComponent restarts a component.
+// The wait before a restart starts at InitialBackoff and is multiplied by Multiplier after
+// every restart up to MaxBackoff, randomized by ±Jitter as a fraction of the wait and never
+// longer than MaxBackoff.
+// When MaxRestarts is positive, the component gives up after MaxRestarts restarts within
+// Window, or in total when Window is 0. When ResetAfter is positive, an execution that ran
+// at least that long resets the backoff and the restart count.
+type RestartPolicy struct {
+	Mode           RestartMode
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+	MaxRestarts    int
+	Window         time.Duration
+	ResetAfter     time.Duration
+}
+
+// DefaultRestartPolicy restarts the component forever, one second after each execution ends.
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		Mode:           RestartAlways,
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     1 * time.Second,
+		Multiplier:     1,
+	}
+}
+
+// restartTracker holds the restart history of one supervised component.
+type restartTracker struct {
+	policy   RestartPolicy
+	backoff  time.Duration
+	restarts []time.Time
+	random   func() float64
+}
+
+func newRestartTracker(policy RestartPolicy) *restartTracker {
+	return &restartTracker{
+		policy: policy,
+		random: rand.Float64,
+	}
+}
+
+// next returns how long to wait before restarting after an execution that started at
+// startedAt ended with err at now. It returns false when the component should not restart.
+func (rt *restartTracker) next(err error, startedAt time.Time, now time.Time) (time.Duration, bool) {
+	switch rt.policy.Mode {
+	case RestartNever:
+		return 0, false
+	case RestartOnFailure:
+		if err == nil {
+			return 0, false
+		}
+	}
+
+	if rt.policy.ResetAfter > 0 && now.Sub(startedAt) >= rt.policy.ResetAfter {
+		rt.backoff = 0
+		rt.restarts = nil
+	}
+
+	if rt.policy.MaxRestarts > 0 {
+		if rt.policy.Window > 0 {
+			recent := rt.restarts[:0]
+			for _, restartedAt := range rt.restarts {
+				if now.Sub(restartedAt) < rt.policy.Window {
+					recent = append(recent, restartedAt)
+				}
+			}
+			rt.restarts = recent
+		}
+		if len(rt.restarts) >= rt.policy.MaxRestarts {
+			return 0, false
+		}
+		rt.restarts = append(rt.restarts, now)
+	}
+
+	if rt.backoff == 0 {
+		rt.backoff = rt.policy.InitialBackoff
+	} else {
+		rt.backoff = time.Duration(float64(rt.backoff) * rt.policy.Multiplier)
+	}
+	if rt.policy.MaxBackoff > 0 && rt.backoff > rt.policy.MaxBackoff {
+		rt.backoff = rt.policy.MaxBackoff
+	}
+
+	wait := rt.backoff
+	if rt.policy.Jitter > 0 {
+		wait = time.Duration(float64(wait) * (1 + rt.policy.Jitter*(2*rt.random()-1)))
+	}
+	// MaxBackoff bounds the wait, jitter included.
+	if rt.policy.MaxBackoff > 0 && wait > rt.policy.MaxBackoff {
+		wait = rt.policy.MaxBackoff
+	}
+
+	return wait, true
+}
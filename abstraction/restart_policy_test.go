@@ -0,0 +1,119 @@
+package abstraction
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRestartTrackerBacksOffExponentiallyUpToMax(t *testing.T) {
+	tracker := newRestartTracker(RestartPolicy{
+		Mode:           RestartAlways,
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+	})
+
+	now := time.Now()
+	want := []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		got, ok := tracker.next(errors.New("failed"), now, now)
+		if !ok {
+			t.Fatalf("restart %d was refused", i)
+		}
+		if got != w {
+			t.Fatalf("backoff %d = %v, want %v", i, got, w)
+		}
+	}
+}
+
+func TestRestartTrackerAppliesJitter(t *testing.T) {
+	tracker := newRestartTracker(RestartPolicy{
+		Mode:           RestartAlways,
+		InitialBackoff: 10 * time.Second,
+		Multiplier:     1,
+		Jitter:         0.5,
+	})
+	tracker.random = func() float64 { return 0 }
+
+	now := time.Now()
+	if got, _ := tracker.next(errors.New("failed"), now, now); got != 5*time.Second {
+		t.Fatalf("backoff = %v, want %v", got, 5*time.Second)
+	}
+}
+
+func TestRestartTrackerKeepsJitteredBackoffWithinMax(t *testing.T) {
+	tracker := newRestartTracker(RestartPolicy{
+		Mode:           RestartAlways,
+		InitialBackoff: time.Minute,
+		MaxBackoff:     time.Minute,
+		Multiplier:     2,
+		Jitter:         0.5,
+	})
+	tracker.random = func() float64 { return 1 }
+
+	now := time.Now()
+	for i := range 3 {
+		if got, _ := tracker.next(errors.New("failed"), now, now); got != time.Minute {
+			t.Fatalf("backoff %d = %v, want %v", i, got, time.Minute)
+		}
+	}
+}
+
+func TestRestartTrackerHonorsMode(t *testing.T) {
+	now := time.Now()
+
+	if _, ok := newRestartTracker(RestartPolicy{Mode: RestartNever}).next(errors.New("failed"), now, now); ok {
+		t.Fatal("never policy restarted after failure")
+	}
+
+	onFailure := newRestartTracker(RestartPolicy{Mode: RestartOnFailure})
+	if _, ok := onFailure.next(nil, now, now); ok {
+		t.Fatal("on-failure policy restarted after normal end")
+	}
+	if _, ok := onFailure.next(errors.New("failed"), now, now); !ok {
+		t.Fatal("on-failure policy did not restart after failure")
+	}
+}
+
+func TestRestartTrackerGivesUpAfterMaxRestartsWithinWindow(t *testing.T) {
+	tracker := newRestartTracker(RestartPolicy{
+		Mode:           RestartAlways,
+		InitialBackoff: time.Second,
+		Multiplier:     1,
+		MaxRestarts:    2,
+		Window:         time.Minute,
+	})
+
+	now := time.Now()
+	for i := range 2 {
+		if _, ok := tracker.next(errors.New("failed"), now, now.Add(time.Duration(i)*time.Second)); !ok {
+			t.Fatalf("restart %d was refused", i)
+		}
+	}
+	if _, ok := tracker.next(errors.New("failed"), now, now.Add(2*time.Second)); ok {
+		t.Fatal("restart beyond maxRestarts was allowed")
+	}
+	if _, ok := tracker.next(errors.New("failed"), now, now.Add(2*time.Minute)); !ok {
+		t.Fatal("restart after window passed was refused")
+	}
+}
+
+func TestRestartTrackerResetsAfterStableUptime(t *testing.T) {
+	tracker := newRestartTracker(RestartPolicy{
+		Mode:           RestartAlways,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		Multiplier:     2,
+		ResetAfter:     10 * time.Minute,
+	})
+
+	now := time.Now()
+	tracker.next(errors.New("failed"), now, now)
+	tracker.next(errors.New("failed"), now, now)
+
+	got, _ := tracker.next(errors.New("failed"), now, now.Add(10*time.Minute))
+	if got != time.Second {
+		t.Fatalf("backoff after stable uptime = %v, want %v", got, time.Second)
+	}
+}
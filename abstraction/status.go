@@ -0,0 +1,29 @@
+package abstraction
+
+// ComponentStatus is a snapshot of a supervised component reported by the admin API.
+type ComponentStatus struct {
+	Id      string         `json:"id"`
+	Running bool           `json:"running"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Metric is one sample exposed by the admin API in the Prometheus text format.
+type Metric struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels map[string]string
+	Value  float64
+}
+
+const (
+	MetricTypeGauge   = "gauge"
+	MetricTypeCounter = "counter"
+)
+
+// StatusReporter is implemented by AbstractChannelComponents that expose details of their
+// runtime state, such as the state of a circuit breaker.
+type StatusReporter interface {
+	Status() map[string]any
+	Metrics() []Metric
+}
@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Kotaro7750/notifier/abstraction"
+)
+
+// Server serves the administrative API: the status of every supervised component in JSON at
+// /status and their metrics in the Prometheus text format at /metrics.
+type Server struct {
+	listenAddress string
+	logger        *slog.Logger
+	components    []typedComponent
+	serveMux      *http.ServeMux
+}
+
+type typedComponent struct {
+	componentType string
+	component     *abstraction.AutonomousChannelComponent
+}
+
+func NewServer(listenAddress string, logger *slog.Logger) *Server {
+	s := &Server{
+		listenAddress: listenAddress,
+		logger:        logger,
+		components:    make([]typedComponent, 0),
+		serveMux:      http.NewServeMux(),
+	}
+
+	s.serveMux.HandleFunc("GET /status", s.handleStatus)
+	s.serveMux.HandleFunc("GET /metrics", s.handleMetrics)
+
+	return s
+}
+
+// AddComponents registers components reported under componentType, such as "sender".
+func (s *Server) AddComponents(componentType string, components ...*abstraction.AutonomousChannelComponent) {
+	for _, component := range components {
+		s.components = append(s.components, typedComponent{componentType: componentType, component: component})
+	}
+}
+
+// Handle registers an additional admin endpoint.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.serveMux.Handle(pattern, handler)
+}
+
+// Start serves the API until done is closed. The returned channel reports an error when the
+// server stops by failure, and is closed when the server has stopped.
+func (s *Server) Start(done <-chan struct{}) <-chan error {
+	retCh := make(chan error, 1)
+
+	server := &http.Server{
+		Addr:    s.listenAddress,
+		Handler: s.serveMux,
+	}
+
+	errCh := make(chan error)
+	go func() {
+		defer close(errCh)
+		err := server.ListenAndServe()
+		errCh <- err
+	}()
+
+	go func() {
+		defer close(retCh)
+		select {
+		case err := <-errCh:
+			retCh <- err
+		case <-done:
+			server.Shutdown(context.Background())
+		}
+	}()
+
+	return retCh
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	statuses := make(map[string][]abstraction.ComponentStatus)
+	for _, c := range s.components {
+		statuses[c.componentType] = append(statuses[c.componentType], c.component.Status())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		s.logger.Error("Encoding status failed", "err", err)
+	}
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsByName := make(map[string][]abstraction.Metric)
+	for _, c := range s.components {
+		for _, metric := range c.component.Metrics() {
+			labels := map[string]string{"type": c.componentType}
+			for key, value := range metric.Labels {
+				labels[key] = value
+			}
+			metric.Labels = labels
+			metricsByName[metric.Name] = append(metricsByName[metric.Name], metric)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(formatMetrics(metricsByName)))
+}
+
+func formatMetrics(metricsByName map[string][]abstraction.Metric) string {
+	names := make([]string, 0, len(metricsByName))
+	for name := range metricsByName {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		metrics := metricsByName[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, metrics[0].Help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, metrics[0].Type)
+		for _, metric := range metrics {
+			fmt.Fprintf(&b, "%s%s %g\n", name, formatLabels(metric.Labels), metric.Value)
+		}
+	}
+	return b.String()
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, key, escaper.Replace(labels[key])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"testing"
+
+	"github.com/Kotaro7750/notifier/abstraction"
+)
+
+func TestFormatMetrics(t *testing.T) {
+	got := formatMetrics(map[string][]abstraction.Metric{
+		"notifier_component_running": {
+			{Name: "notifier_component_running", Help: "Whether the component is running.", Type: abstraction.MetricTypeGauge, Labels: map[string]string{"type": "sender", "id": "push"}, Value: 1},
+			{Name: "notifier_component_running", Help: "Whether the component is running.", Type: abstraction.MetricTypeGauge, Labels: map[string]string{"type": "sender", "id": `a"b`}, Value: 0},
+		},
+		"notifier_circuit_breaker_state": {
+			{Name: "notifier_circuit_breaker_state", Help: "Circuit breaker state.", Type: abstraction.MetricTypeGauge, Labels: map[string]string{"id": "push"}, Value: 2},
+		},
+	})
+
+	want := `# HELP notifier_circuit_breaker_state Circuit breaker state.
+# TYPE notifier_circuit_breaker_state gauge
+notifier_circuit_breaker_state{id="push"} 2
+# HELP notifier_component_running Whether the component is running.
+# TYPE notifier_component_running gauge
+notifier_component_running{id="push",type="sender"} 1
+notifier_component_running{id="a\"b",type="sender"} 0
+`
+	if got != want {
+		t.Fatalf("formatMetrics() =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -3,9 +3,12 @@ package builder
 import (
 	"fmt"
 	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/Kotaro7750/notifier/abstraction"
 	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
 	"github.com/Kotaro7750/notifier/receiver"
 	"github.com/Kotaro7750/notifier/sender"
 )
@@ -13,22 +16,74 @@ import (
 var senderBuilderMap map[string]abstraction.AbstractChannelComponentBuilder = make(map[string]abstraction.AbstractChannelComponentBuilder)
 var receiverBuilderMap map[string]abstraction.AbstractChannelComponentBuilder = make(map[string]abstraction.AbstractChannelComponentBuilder)
 
+// builderMapLock guards the builder maps against registration from concurrent init functions.
+var builderMapLock sync.RWMutex
+
 func init() {
-	receiverBuilderMap["dummy"] = receiver.DummyReceiverBuilder
+	RegisterReceiver("dummy", receiver.DummyReceiverBuilder)
+
+	RegisterReceiver("HTTP", receiver.HTTPReceiverBuilder)
+
+	RegisterReceiver("internal", receiver.InternalReceiverBuilder)
+
+	RegisterReceiver("exec", receiver.ExecReceiverBuilder)
+
+	RegisterSender("dummy", sender.DummySenderBuilder)
+
+	RegisterSender("datadog_event", sender.DatadogEventSenderBuilder)
+
+	RegisterSender("webPush", sender.WebPushSenderBuilder)
+
+	RegisterSender("exec", sender.ExecSenderBuilder)
+
+	RegisterSender(config.FailoverSenderKind, sender.FailoverSenderBuilder)
+}
+
+// RegisterSender makes a sender kind available to the configuration. It is meant to be called
+// from init functions, and panics if builder is nil or kind is already registered.
+func RegisterSender(kind string, builder abstraction.AbstractChannelComponentBuilder) {
+	register(senderBuilderMap, "sender", kind, builder)
+}
 
-	receiverBuilderMap["HTTP"] = receiver.HTTPReceiverBuilder
+// RegisterReceiver makes a receiver kind available to the configuration. It is meant to be
+// called from init functions, and panics if builder is nil or kind is already registered.
+func RegisterReceiver(kind string, builder abstraction.AbstractChannelComponentBuilder) {
+	register(receiverBuilderMap, "receiver", kind, builder)
+}
+
+func register(builderMap map[string]abstraction.AbstractChannelComponentBuilder, componentType string, kind string, builder abstraction.AbstractChannelComponentBuilder) {
+	builderMapLock.Lock()
+	defer builderMapLock.Unlock()
+
+	if builder == nil {
+		panic(fmt.Sprintf("builder: %s builder for kind %s is nil", componentType, kind))
+	}
+	if _, ok := builderMap[kind]; ok {
+		panic(fmt.Sprintf("builder: %s kind %s is registered twice", componentType, kind))
+	}
+	builderMap[kind] = builder
+}
 
-	senderBuilderMap["dummy"] = sender.DummySenderBuilder
+func lookup(builderMap map[string]abstraction.AbstractChannelComponentBuilder, kind string) (abstraction.AbstractChannelComponentBuilder, bool) {
+	builderMapLock.RLock()
+	defer builderMapLock.RUnlock()
 
-	senderBuilderMap["datadog_event"] = sender.DatadogEventSenderBuilder
+	builder, ok := builderMap[kind]
+	return builder, ok
+}
 
-	senderBuilderMap["webPush"] = sender.WebPushSenderBuilder
+// SenderObserver is notified of the decisions of the stages of every sender built on
+// sender.Sender, and of the delivery outcomes those senders report.
+type SenderObserver interface {
+	Decided(senderId string, n notification.Notification, decision sender.Decision)
+	Delivered(senderId string, n notification.Notification, err error)
 }
 
 func Build(
 	baseLogger *slog.Logger,
 	receiverConfigs []config.ChannelComponentConfig,
 	senderConfigs []config.ChannelComponentConfig,
+	observers ...SenderObserver,
 ) (
 	receivers []*abstraction.AutonomousChannelComponent,
 	senders []*abstraction.AutonomousChannelComponent,
@@ -37,7 +92,7 @@ func Build(
 	receivers = make([]*abstraction.AutonomousChannelComponent, 0)
 
 	for _, config := range receiverConfigs {
-		builder, ok := receiverBuilderMap[config.Kind]
+		builder, ok := lookup(receiverBuilderMap, config.Kind)
 		if !ok {
 			err = fmt.Errorf("receiver kind: %s for %s is not found", config.Kind, config.Id)
 			return nil, nil, err
@@ -49,13 +104,14 @@ func Build(
 		}
 		component.SetLogger(baseLogger.With("type", "receiver", "kind", config.Kind, "id", component.GetId()))
 
-		receivers = append(receivers, abstraction.NewAutonomousChannelComponent(component))
+		receivers = append(receivers, newAutonomousChannelComponent(component, config))
 	}
 
 	senders = make([]*abstraction.AutonomousChannelComponent, 0)
+	members := make(map[string]sender.Member)
 
 	for _, config := range senderConfigs {
-		builder, ok := senderBuilderMap[config.Kind]
+		builder, ok := lookup(senderBuilderMap, config.Kind)
 		if !ok {
 			err = fmt.Errorf("sender kind: %s for %s is not found", config.Kind, config.Id)
 			return nil, nil, err
@@ -66,15 +122,199 @@ func Build(
 			return nil, nil, fmt.Errorf("failed to build sender id: %s, kind: %s, error: %s", config.Id, config.Kind, err.Error())
 		}
 		component.SetLogger(baseLogger.With("type", "sender", "kind", config.Kind, "id", component.GetId()))
-		if config.Match != nil && config.Match.HasConditions() {
-			senderComponent, ok := component.(*sender.Sender)
-			if !ok {
-				return nil, nil, fmt.Errorf("sender id: %s, kind: %s does not support sender match", config.Id, config.Kind)
-			}
-			senderComponent.SetMatch(*config.Match)
+		if err := configureSender(component, config); err != nil {
+			return nil, nil, err
 		}
+		if senderComponent, ok := component.(*sender.Sender); ok {
+			observeSender(senderComponent, observers)
+		}
+
+		acc := newAutonomousChannelComponent(component, config)
+		members[config.Id] = sender.Member{Component: component, Supervised: acc}
+		senders = append(senders, acc)
+	}
 
-		senders = append(senders, abstraction.NewAutonomousChannelComponent(component))
+	if err := resolveMembers(members); err != nil {
+		return nil, nil, err
 	}
+
+	observeComponents(receivers, senders)
 	return
 }
+
+// resolveMembers hands every sender that delivers through other senders, such as a failover
+// sender, the senders built for its member ids.
+func resolveMembers(members map[string]sender.Member) error {
+	for id, member := range members {
+		senderComponent, ok := member.Component.(*sender.Sender)
+		if !ok || len(senderComponent.MemberIds()) == 0 {
+			continue
+		}
+
+		resolved := make([]sender.Member, 0, len(senderComponent.MemberIds()))
+		for _, memberId := range senderComponent.MemberIds() {
+			m, ok := members[memberId]
+			if !ok || memberId == id {
+				return fmt.Errorf("member %s of sender %s is not found", memberId, id)
+			}
+			resolved = append(resolved, m)
+		}
+		senderComponent.SetMembers(resolved)
+	}
+	return nil
+}
+
+// Observe reports the lifecycle events of acc, a component supervised outside of Build such as
+// the escalation manager, to the receivers built by Build that handle them.
+func Observe(componentType string, acc *abstraction.AutonomousChannelComponent, receivers []*abstraction.AutonomousChannelComponent) {
+	observe(componentType, acc, componentEventHandlers(receivers))
+}
+
+// observeComponents reports the lifecycle events of every component to the receivers that
+// handle them, except the events of those receivers themselves so that a failing internal
+// receiver does not report itself.
+func observeComponents(receivers []*abstraction.AutonomousChannelComponent, senders []*abstraction.AutonomousChannelComponent) {
+	eventHandlers := componentEventHandlers(receivers)
+
+	for _, acc := range receivers {
+		if _, ok := eventHandlers[acc]; !ok {
+			observe("receiver", acc, eventHandlers)
+		}
+	}
+	for _, acc := range senders {
+		observe("sender", acc, eventHandlers)
+	}
+}
+
+// componentEventHandlers returns the receivers that handle component events.
+func componentEventHandlers(receivers []*abstraction.AutonomousChannelComponent) map[*abstraction.AutonomousChannelComponent]receiver.ComponentEventHandler {
+	eventHandlers := make(map[*abstraction.AutonomousChannelComponent]receiver.ComponentEventHandler)
+	for _, acc := range receivers {
+		if r, ok := acc.GetComponent().(*receiver.Receiver); ok {
+			if handler, ok := r.ComponentEventHandler(); ok {
+				eventHandlers[acc] = handler
+			}
+		}
+	}
+	return eventHandlers
+}
+
+func observe(componentType string, acc *abstraction.AutonomousChannelComponent, eventHandlers map[*abstraction.AutonomousChannelComponent]receiver.ComponentEventHandler) {
+	if len(eventHandlers) == 0 {
+		return
+	}
+
+	acc.SetEventHandler(func(event abstraction.Event) {
+		for _, handler := range eventHandlers {
+			handler.HandleEvent(componentType, event)
+		}
+	})
+}
+
+func observeSender(s *sender.Sender, observers []SenderObserver) {
+	id := s.GetId()
+	for _, observer := range observers {
+		s.AddDecisionHandler(func(n notification.Notification, decision sender.Decision) {
+			observer.Decided(id, n, decision)
+		})
+		if s.ReportsDeliveries() {
+			s.AddDeliveryHandler(func(n notification.Notification, err error) {
+				observer.Delivered(id, n, err)
+			})
+		}
+	}
+}
+
+func newAutonomousChannelComponent(component abstraction.AbstractChannelComponent, config config.ChannelComponentConfig) *abstraction.AutonomousChannelComponent {
+	acc := abstraction.NewAutonomousChannelComponent(component)
+	if config.RestartPolicy != nil {
+		acc.SetRestartPolicy(restartPolicyOf(*config.RestartPolicy))
+	}
+	return acc
+}
+
+// restartPolicyOf applies the defaults of unset fields in the configured restart policy.
+func restartPolicyOf(config config.RestartPolicyConfig) abstraction.RestartPolicy {
+	policy := abstraction.RestartPolicy{
+		Mode:           abstraction.RestartMode(config.Mode),
+		InitialBackoff: config.InitialBackoff,
+		MaxBackoff:     config.MaxBackoff,
+		Multiplier:     config.Multiplier,
+		Jitter:         config.Jitter,
+		MaxRestarts:    config.MaxRestarts,
+		Window:         config.Window,
+		ResetAfter:     config.ResetAfter,
+	}
+
+	if policy.Mode == "" {
+		policy.Mode = abstraction.RestartAlways
+	}
+	if policy.InitialBackoff == 0 {
+		policy.InitialBackoff = 1 * time.Second
+	}
+	if policy.MaxBackoff == 0 {
+		policy.MaxBackoff = max(1*time.Minute, policy.InitialBackoff)
+	}
+	if policy.Multiplier == 0 {
+		policy.Multiplier = 2
+	}
+
+	return policy
+}
+
+// configureSender applies the options shared by every sender kind. They are only supported
+// by components built on sender.Sender.
+func configureSender(component abstraction.AbstractChannelComponent, config config.ChannelComponentConfig) error {
+	senderComponent, ok := component.(*sender.Sender)
+	unsupported := func(option string) error {
+		return fmt.Errorf("sender id: %s, kind: %s does not support sender %s", config.Id, config.Kind, option)
+	}
+
+	if config.Match != nil && config.Match.HasConditions() {
+		if !ok {
+			return unsupported("match")
+		}
+		senderComponent.SetMatch(*config.Match)
+	}
+
+	if config.MinSeverity != "" {
+		if !ok {
+			return unsupported("minSeverity")
+		}
+		minSeverity, err := notification.ParseSeverity(config.MinSeverity)
+		if err != nil {
+			return fmt.Errorf("sender id: %s, kind: %s has invalid minSeverity: %w", config.Id, config.Kind, err)
+		}
+		senderComponent.SetMinSeverity(minSeverity)
+	}
+
+	if config.Group != nil {
+		if !ok {
+			return unsupported("group")
+		}
+		senderComponent.SetGroup(*config.Group)
+	}
+
+	if config.RateLimit != nil {
+		if !ok {
+			return unsupported("rateLimit")
+		}
+		senderComponent.SetRateLimit(*config.RateLimit)
+	}
+
+	if config.Dedup != nil {
+		if !ok {
+			return unsupported("dedup")
+		}
+		senderComponent.SetDedup(*config.Dedup)
+	}
+
+	if config.CircuitBreaker != nil {
+		if !ok {
+			return unsupported("circuitBreaker")
+		}
+		senderComponent.SetCircuitBreaker(*config.CircuitBreaker)
+	}
+
+	return nil
+}
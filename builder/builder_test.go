@@ -0,0 +1,97 @@
+package builder
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/abstraction"
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/sender"
+	"github.com/Kotaro7750/notifier/test_util"
+)
+
+func TestRegisterSenderMakesKindAvailable(t *testing.T) {
+	RegisterSender("test_registered", sender.DummySenderBuilder)
+
+	_, senders, err := Build(slog.Default(), nil, []config.ChannelComponentConfig{
+		{Id: "sender-1", Kind: "test_registered"},
+	})
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	if len(senders) != 1 || senders[0].GetId() != "sender-1" {
+		t.Fatalf("senders = %v, want sender-1", senders)
+	}
+}
+
+func TestRegisterSenderPanicsOnDuplicateKind(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("RegisterSender did not panic for a registered kind")
+		}
+	}()
+
+	RegisterSender("dummy", sender.DummySenderBuilder)
+}
+
+func TestBuildRejectsUnknownFailoverMember(t *testing.T) {
+	_, _, err := Build(slog.Default(), nil, []config.ChannelComponentConfig{
+		{Id: "sender-1", Kind: "dummy"},
+		{Id: "failover-1", Kind: config.FailoverSenderKind, Properties: test_util.MustPropertiesNode(t, `
+members: [sender-1, sender-2]
+`)},
+	})
+	if err == nil {
+		t.Fatal("Build unexpectedly succeeded")
+	}
+}
+
+// failingComponent fails its only execution.
+type failingComponent struct{}
+
+func (fc failingComponent) GetId() string                 { return "policy-manager" }
+func (fc failingComponent) GetLogger() *slog.Logger       { return slog.Default() }
+func (fc failingComponent) SetLogger(logger *slog.Logger) {}
+
+func (fc failingComponent) Start(ch chan notification.Notification, done <-chan struct{}) <-chan error {
+	retCh := make(chan error, 1)
+	retCh <- errors.New("failed")
+	close(retCh)
+	return retCh
+}
+
+func TestObserveReportsComponentBuiltOutsideBuild(t *testing.T) {
+	receivers, _, err := Build(slog.Default(), []config.ChannelComponentConfig{
+		{Id: "internal-1", Kind: "internal"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	receiverCh := receivers[0].Start()
+	defer func() {
+		receivers[0].Shutdown()
+		<-receiverCh
+	}()
+
+	acc := abstraction.NewAutonomousChannelComponent(failingComponent{})
+	acc.SetRestartPolicy(abstraction.RestartPolicy{Mode: abstraction.RestartNever})
+	Observe("escalation", acc, receivers)
+	accCh := acc.Start()
+	defer func() {
+		acc.Shutdown()
+		<-accCh
+	}()
+
+	select {
+	case n := <-receivers[0].GetChannel():
+		if !strings.HasPrefix(n.Title, "escalation policy-manager") {
+			t.Fatalf("Title = %q, want a notification about the escalation component", n.Title)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failure of the observed component was not reported")
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/Kotaro7750/notifier"
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/sender"
+
+	"gopkg.in/yaml.v3"
+)
+
+var Logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+func main() {
+	if len(os.Args) < 2 {
+		Logger.Error("Configuration file is required")
+		return
+	}
+
+	if len(os.Args) >= 3 && os.Args[1] == "webpush" && os.Args[2] == "keygen" {
+		if err := webpushKeygen(os.Args[3:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) >= 3 && os.Args[1] == "webpush" && os.Args[2] == "migrate" {
+		if err := webpushMigrate(os.Args[3:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	configFileNAme := os.Args[1]
+	fileContent, err := os.ReadFile(configFileNAme)
+	if err != nil {
+		Logger.Error("Error reading file", "err", err)
+		return
+	}
+
+	cfg := config.Configuration{}
+	decoder := yaml.NewDecoder(bytes.NewReader(fileContent))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&cfg)
+	if err != nil {
+		Logger.Error("Error parsing YAML file", "err", err)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	if err := notifier.Run(ctx, cfg); err != nil {
+		Logger.Error("Notifier failed", "err", err)
+		os.Exit(1)
+	}
+}
+
+// webpushKeygen generates a VAPID key pair for the WebPush sender. The keys are printed as JSON,
+// or written to the key file given by -o for use with the file source.
+func webpushKeygen(args []string) error {
+	flags := flag.NewFlagSet("webpush keygen", flag.ContinueOnError)
+	output := flags.String("o", "", "write the keys to this key file instead of stdout. It must not exist.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	keys, err := sender.GenerateVAPIDKeys()
+	if err != nil {
+		return err
+	}
+
+	if *output != "" {
+		if err := sender.WriteVAPIDKeysFile(*output, keys); err != nil {
+			return err
+		}
+		fmt.Println(keys.PublicKey)
+		return nil
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(keys)
+}
+
+// webpushMigrate copies the subscriptions of one WebPush subscription repository into another.
+// Repositories are given as TYPE or TYPE:PATH, such as DynamoDB or SQLite:/data/subscriptions.db.
+// The -from-dynamodb and -to-dynamodb flags configure each DynamoDB repository like the
+// dynamoDB properties of the WebPush sender, so that subscriptions can be copied between two
+// tables or key prefixes.
+func webpushMigrate(args []string) error {
+	flags := flag.NewFlagSet("webpush migrate", flag.ContinueOnError)
+	from := flags.String("from", "", "repository to copy subscriptions from, as TYPE or TYPE:PATH")
+	to := flags.String("to", "", "repository to copy subscriptions to, as TYPE or TYPE:PATH")
+	fromDynamoDB := dynamoDBFlags(flags, "from", "the repository to copy from")
+	toDynamoDB := dynamoDBFlags(flags, "to", "the repository to copy to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return fmt.Errorf("-from and -to are required")
+	}
+
+	ctx := context.Background()
+
+	source, err := openSubscriptionRepository(ctx, *from, *fromDynamoDB)
+	if err != nil {
+		return fmt.Errorf("-from: %w", err)
+	}
+	defer closeSubscriptionRepository(source)
+
+	target, err := openSubscriptionRepository(ctx, *to, *toDynamoDB)
+	if err != nil {
+		return fmt.Errorf("-to: %w", err)
+	}
+	defer closeSubscriptionRepository(target)
+
+	copied, err := sender.MigrateSubscriptions(ctx, source, target)
+	if err != nil {
+		return fmt.Errorf("migrated %d subscriptions before failing: %w", copied, err)
+	}
+	fmt.Printf("Migrated %d subscriptions from %s to %s\n", copied, *from, *to)
+	return nil
+}
+
+// dynamoDBFlags defines the -SIDE-dynamodb flags configuring the DynamoDB repository of one
+// side of a migration.
+func dynamoDBFlags(flags *flag.FlagSet, side string, repository string) *sender.DynamoDBProperties {
+	dynamoDB := sender.NewDynamoDBProperties()
+	flags.StringVar(&dynamoDB.Region, side+"-dynamodb-region", dynamoDB.Region, "region of DynamoDB for "+repository)
+	flags.StringVar(&dynamoDB.Endpoint, side+"-dynamodb-endpoint", "", "endpoint overriding the one of DynamoDB for "+repository)
+	flags.StringVar(&dynamoDB.SubscriptionsTable, side+"-dynamodb-table", dynamoDB.SubscriptionsTable, "table of the subscriptions of "+repository)
+	flags.StringVar(&dynamoDB.KeyPrefix, side+"-dynamodb-key-prefix", "", "prefix of the keys of the subscriptions of "+repository)
+	flags.BoolVar(&dynamoDB.CreateTables, side+"-dynamodb-create-table", false, "create the table of "+repository+" if it does not exist")
+	return &dynamoDB
+}
+
+func openSubscriptionRepository(ctx context.Context, value string, dynamoDB sender.DynamoDBProperties) (sender.SubscriptionRepository, error) {
+	repositoryType, path, _ := strings.Cut(value, ":")
+	if repositoryType == sender.RepositoryTypeInMemory {
+		return nil, fmt.Errorf("InMemory repositories do not outlive the process")
+	}
+	return sender.OpenSubscriptionRepository(ctx, sender.SubscriptionRepositoryProperties{
+		RepositoryType: repositoryType,
+		RepositoryPath: path,
+		DynamoDB:       dynamoDB,
+	})
+}
+
+func closeSubscriptionRepository(repository sender.SubscriptionRepository) {
+	if closer, ok := repository.(io.Closer); ok {
+		closer.Close()
+	}
+}
@@ -3,7 +3,12 @@ package config
 import (
 	"bytes"
 	"fmt"
+	"log/slog"
+	"net/url"
 	"strings"
+	"time"
+
+	"github.com/Kotaro7750/notifier/notification"
 
 	"gopkg.in/yaml.v3"
 )
@@ -11,6 +16,12 @@ import (
 type Configuration struct {
 	ReceiverConfigurations []ChannelComponentConfig `yaml:"receivers,flow"`
 	SenderConfigurations   []ChannelComponentConfig `yaml:"senders,flow"`
+	Escalation             *EscalationConfig        `yaml:"escalation,omitempty"`
+	Admin                  *AdminConfig             `yaml:"admin,omitempty"`
+	Shutdown               *ShutdownConfig          `yaml:"shutdown,omitempty"`
+	Logging                *LoggingConfig           `yaml:"logging,omitempty"`
+	Tracing                *TracingConfig           `yaml:"tracing,omitempty"`
+	History                *HistoryConfig           `yaml:"history,omitempty"`
 }
 
 func (c Configuration) Validate() error {
@@ -29,6 +40,21 @@ func (c Configuration) Validate() error {
 		if receiverConfig.Match != nil {
 			return fmt.Errorf("receiver %s does not support match", receiverConfig.Id)
 		}
+		if receiverConfig.Group != nil {
+			return fmt.Errorf("receiver %s does not support group", receiverConfig.Id)
+		}
+		if receiverConfig.RateLimit != nil {
+			return fmt.Errorf("receiver %s does not support rateLimit", receiverConfig.Id)
+		}
+		if receiverConfig.Dedup != nil {
+			return fmt.Errorf("receiver %s does not support dedup", receiverConfig.Id)
+		}
+		if receiverConfig.MinSeverity != "" {
+			return fmt.Errorf("receiver %s does not support minSeverity", receiverConfig.Id)
+		}
+		if receiverConfig.CircuitBreaker != nil {
+			return fmt.Errorf("receiver %s does not support circuitBreaker", receiverConfig.Id)
+		}
 	}
 
 	if c.SenderConfigurations == nil {
@@ -39,10 +65,55 @@ func (c Configuration) Validate() error {
 		return fmt.Errorf("At least one sender is required")
 	}
 
+	senderIds := make(map[string]bool, len(c.SenderConfigurations))
 	for _, senderConfig := range c.SenderConfigurations {
 		if err := senderConfig.Validate(); err != nil {
 			return err
 		}
+		senderIds[senderConfig.Id] = true
+	}
+
+	if err := validateFailoverMembers(c.SenderConfigurations, senderIds); err != nil {
+		return err
+	}
+
+	if c.Escalation != nil {
+		if err := c.Escalation.Validate(senderIds); err != nil {
+			return fmt.Errorf("escalation is invalid: %w", err)
+		}
+	}
+
+	if c.Admin != nil {
+		if err := c.Admin.Validate(); err != nil {
+			return fmt.Errorf("admin is invalid: %w", err)
+		}
+	}
+
+	if c.Shutdown != nil {
+		if err := c.Shutdown.Validate(); err != nil {
+			return fmt.Errorf("shutdown is invalid: %w", err)
+		}
+	}
+
+	if c.Logging != nil {
+		if err := c.Logging.Validate(); err != nil {
+			return fmt.Errorf("logging is invalid: %w", err)
+		}
+	}
+
+	if c.Tracing != nil {
+		if err := c.Tracing.Validate(); err != nil {
+			return fmt.Errorf("tracing is invalid: %w", err)
+		}
+	}
+
+	if c.History != nil {
+		if c.Admin == nil {
+			return fmt.Errorf("history requires admin, which serves its API")
+		}
+		if err := c.History.Validate(); err != nil {
+			return fmt.Errorf("history is invalid: %w", err)
+		}
 	}
 
 	return nil
@@ -52,10 +123,16 @@ func (c Configuration) Validate() error {
 // The top-level config is decoded into this shared shape first, and Properties is then
 // decoded a second time into a component-specific typed properties struct inside each builder.
 type ChannelComponentConfig struct {
-	Id         string             `yaml:"id"`
-	Kind       string             `yaml:"kind"`
-	Match      *MetadataCondition `yaml:"match,omitempty"`
-	Properties yaml.Node          `yaml:"properties"`
+	Id             string                `yaml:"id"`
+	Kind           string                `yaml:"kind"`
+	Match          *MetadataCondition    `yaml:"match,omitempty"`
+	MinSeverity    string                `yaml:"minSeverity,omitempty"`
+	Group          *GroupConfig          `yaml:"group,omitempty"`
+	RateLimit      *RateLimitConfig      `yaml:"rateLimit,omitempty"`
+	Dedup          *DedupConfig          `yaml:"dedup,omitempty"`
+	RestartPolicy  *RestartPolicyConfig  `yaml:"restartPolicy,omitempty"`
+	CircuitBreaker *CircuitBreakerConfig `yaml:"circuitBreaker,omitempty"`
+	Properties     yaml.Node             `yaml:"properties"`
 }
 
 func (c ChannelComponentConfig) Validate() error {
@@ -73,9 +150,628 @@ func (c ChannelComponentConfig) Validate() error {
 		}
 	}
 
+	if c.MinSeverity != "" {
+		if _, err := notification.ParseSeverity(c.MinSeverity); err != nil {
+			return fmt.Errorf("minSeverity is invalid: %w", err)
+		}
+	}
+
+	if c.RestartPolicy != nil {
+		if err := c.RestartPolicy.Validate(); err != nil {
+			return fmt.Errorf("restartPolicy is invalid: %w", err)
+		}
+	}
+
+	if c.CircuitBreaker != nil {
+		if err := c.CircuitBreaker.Validate(); err != nil {
+			return fmt.Errorf("circuitBreaker is invalid: %w", err)
+		}
+	}
+
+	if c.Group != nil {
+		if err := c.Group.Validate(); err != nil {
+			return fmt.Errorf("group is invalid: %w", err)
+		}
+	}
+
+	if c.RateLimit != nil {
+		if err := c.RateLimit.Validate(); err != nil {
+			return fmt.Errorf("rateLimit is invalid: %w", err)
+		}
+	}
+
+	if c.Dedup != nil {
+		if err := c.Dedup.Validate(); err != nil {
+			return fmt.Errorf("dedup is invalid: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// FailoverSenderKind is the kind of senders that deliver through other configured senders,
+// which are listed by id in the members property.
+const FailoverSenderKind = "failover"
+
+// FailoverMemberIds returns the ids of the members of c, or nil when c is not a failover sender.
+func (c ChannelComponentConfig) FailoverMemberIds() ([]string, error) {
+	if c.Kind != FailoverSenderKind || c.Properties.Kind == 0 {
+		return nil, nil
+	}
+
+	// The other properties are validated by the builder of the failover sender.
+	var properties struct {
+		Members []string `yaml:"members"`
+	}
+	if err := c.Properties.Decode(&properties); err != nil {
+		return nil, fmt.Errorf("members is invalid: %w", err)
+	}
+	return properties.Members, nil
+}
+
+// FailoverMemberIds returns the ids of the senders that are members of a failover sender. They
+// receive notifications only through failover senders and escalation steps.
+func (c Configuration) FailoverMemberIds() map[string]bool {
+	memberIds := make(map[string]bool)
+	for _, senderConfig := range c.SenderConfigurations {
+		ids, _ := senderConfig.FailoverMemberIds()
+		for _, id := range ids {
+			memberIds[id] = true
+		}
+	}
+	return memberIds
+}
+
+// validateFailoverMembers checks that the members of every failover sender are configured
+// senders, and that no failover sender is its own member, even through another one.
+func validateFailoverMembers(senderConfigs []ChannelComponentConfig, senderIds map[string]bool) error {
+	members := make(map[string][]string)
+	for _, senderConfig := range senderConfigs {
+		ids, err := senderConfig.FailoverMemberIds()
+		if err != nil {
+			return fmt.Errorf("sender %s is invalid: %w", senderConfig.Id, err)
+		}
+		for _, id := range ids {
+			if !senderIds[id] {
+				return fmt.Errorf("sender %s has unknown member %s", senderConfig.Id, id)
+			}
+		}
+		members[senderConfig.Id] = ids
+	}
+
+	visited := make(map[string]bool)
+	visiting := make(map[string]bool)
+	var visit func(id string) error
+	visit = func(id string) error {
+		if visiting[id] {
+			return fmt.Errorf("sender %s is a member of itself", id)
+		}
+		if visited[id] {
+			return nil
+		}
+		visiting[id] = true
+		for _, member := range members[id] {
+			if err := visit(member); err != nil {
+				return err
+			}
+		}
+		visiting[id] = false
+		visited[id] = true
+		return nil
+	}
+	for _, senderConfig := range senderConfigs {
+		if err := visit(senderConfig.Id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const (
+	RestartModeAlways    = "always"
+	RestartModeOnFailure = "on-failure"
+	RestartModeNever     = "never"
+)
+
+// RestartPolicyConfig configures how a failed component is restarted. Unset fields take the
+// defaults: mode always, initialBackoff 1s, maxBackoff 1m and multiplier 2. maxRestarts,
+// window and resetAfter are disabled when unset. jitter is a fraction of the backoff.
+type RestartPolicyConfig struct {
+	Mode           string        `yaml:"mode"`
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+	Multiplier     float64       `yaml:"multiplier"`
+	Jitter         float64       `yaml:"jitter"`
+	MaxRestarts    int           `yaml:"maxRestarts"`
+	Window         time.Duration `yaml:"window"`
+	ResetAfter     time.Duration `yaml:"resetAfter"`
+}
+
+func (r RestartPolicyConfig) Validate() error {
+	switch r.Mode {
+	case "", RestartModeAlways, RestartModeOnFailure, RestartModeNever:
+	default:
+		return fmt.Errorf("mode is invalid. mode: %s", r.Mode)
+	}
+
+	if r.InitialBackoff < 0 {
+		return fmt.Errorf("initialBackoff should be greater than or equal to 0")
+	}
+
+	if r.MaxBackoff < 0 {
+		return fmt.Errorf("maxBackoff should be greater than or equal to 0")
+	}
+
+	if r.MaxBackoff > 0 && r.InitialBackoff > r.MaxBackoff {
+		return fmt.Errorf("initialBackoff should be less than or equal to maxBackoff")
+	}
+
+	if r.Multiplier != 0 && r.Multiplier < 1 {
+		return fmt.Errorf("multiplier should be greater than or equal to 1")
+	}
+
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return fmt.Errorf("jitter should be between 0 and 1")
+	}
+
+	if r.MaxRestarts < 0 {
+		return fmt.Errorf("maxRestarts should be greater than or equal to 0")
+	}
+
+	if r.Window < 0 {
+		return fmt.Errorf("window should be greater than or equal to 0")
+	}
+
+	if r.ResetAfter < 0 {
+		return fmt.Errorf("resetAfter should be greater than or equal to 0")
+	}
+
 	return nil
 }
 
+// CircuitBreakerConfig configures a circuit breaker in front of a sender. The breaker opens
+// after failureThreshold consecutive failed deliveries and short-circuits notifications for
+// openDuration. It then lets halfOpenProbes notifications through, and closes again when all
+// of them are delivered. Short-circuited notifications are buffered up to bufferSize and
+// delivered after the breaker closes; the rest are appended to deadLetterFile when it is set
+// and dropped otherwise. A notification whose delivery fails, including a probe, is buffered
+// again in front of the others the same way.
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `yaml:"failureThreshold"`
+	OpenDuration     time.Duration `yaml:"openDuration"`
+	HalfOpenProbes   int           `yaml:"halfOpenProbes"`
+	BufferSize       int           `yaml:"bufferSize"`
+	DeadLetterFile   string        `yaml:"deadLetterFile"`
+}
+
+func (c CircuitBreakerConfig) Validate() error {
+	if c.FailureThreshold <= 0 {
+		return fmt.Errorf("failureThreshold should be greater than 0")
+	}
+
+	if c.OpenDuration <= 0 {
+		return fmt.Errorf("openDuration should be greater than 0")
+	}
+
+	if c.HalfOpenProbes < 0 {
+		return fmt.Errorf("halfOpenProbes should be greater than or equal to 0")
+	}
+
+	if c.BufferSize < 0 {
+		return fmt.Errorf("bufferSize should be greater than or equal to 0")
+	}
+
+	return nil
+}
+
+// GroupConfig configures how a sender batches notifications into digests.
+// Notifications with the same values for the By label keys form one group. The first digest
+// of a group is sent GroupWait after its first notification, and further notifications are
+// batched and sent every GroupInterval.
+type GroupConfig struct {
+	By            []string      `yaml:"by"`
+	GroupWait     time.Duration `yaml:"groupWait"`
+	GroupInterval time.Duration `yaml:"groupInterval"`
+}
+
+func (g GroupConfig) Validate() error {
+	for _, key := range g.By {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("by must not contain an empty key")
+		}
+	}
+
+	if g.GroupWait < 0 {
+		return fmt.Errorf("groupWait should be greater than or equal to 0")
+	}
+
+	if g.GroupInterval <= 0 {
+		return fmt.Errorf("groupInterval should be greater than 0")
+	}
+
+	return nil
+}
+
+const (
+	RateLimitPolicyDrop     = "drop"
+	RateLimitPolicyDelay    = "delay"
+	RateLimitPolicyCollapse = "collapse"
+)
+
+const RateLimitByNotificationSource = "notification_source"
+
+// RateLimitConfig configures a token bucket in front of a sender. The bucket is refilled with
+// Limit tokens per Interval up to Burst tokens, and each delivered notification takes one.
+// By splits the bucket per label key value; the special key notification_source refers to
+// the notification source instead of a label. Policy decides what happens to the excess:
+// drop discards it, delay queues up to MaxQueued notifications until tokens are available,
+// and collapse counts it and sends one summary once the flood subsides.
+type RateLimitConfig struct {
+	Limit     int           `yaml:"limit"`
+	Interval  time.Duration `yaml:"interval"`
+	Burst     int           `yaml:"burst"`
+	By        []string      `yaml:"by"`
+	Policy    string        `yaml:"policy"`
+	MaxQueued int           `yaml:"maxQueued"`
+}
+
+func (r RateLimitConfig) Validate() error {
+	if r.Limit <= 0 {
+		return fmt.Errorf("limit should be greater than 0")
+	}
+
+	if r.Interval <= 0 {
+		return fmt.Errorf("interval should be greater than 0")
+	}
+
+	if r.Burst < 0 {
+		return fmt.Errorf("burst should be greater than or equal to 0")
+	}
+
+	for _, key := range r.By {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("by must not contain an empty key")
+		}
+	}
+
+	switch r.Policy {
+	case "", RateLimitPolicyDrop, RateLimitPolicyDelay, RateLimitPolicyCollapse:
+	default:
+		return fmt.Errorf("policy is invalid. policy: %s", r.Policy)
+	}
+
+	if r.MaxQueued < 0 {
+		return fmt.Errorf("maxQueued should be greater than or equal to 0")
+	}
+
+	return nil
+}
+
+const (
+	DedupFieldTitle              = "title"
+	DedupFieldMessage            = "message"
+	DedupFieldNotificationSource = "notification_source"
+)
+
+// DedupConfig configures content-based deduplication in front of a sender. Notifications
+// whose Fields and Labels values are identical to one delivered on the same route less than
+// Window ago are counted instead of sent. Notifications are routed to the sender directly, or
+// by a step of an escalation policy, and each route keeps its own state. When both Fields and
+// Labels are empty, title, message and notification_source are compared.
+type DedupConfig struct {
+	Fields []string      `yaml:"fields"`
+	Labels []string      `yaml:"labels"`
+	Window time.Duration `yaml:"window"`
+}
+
+func (d DedupConfig) Validate() error {
+	for _, field := range d.Fields {
+		switch field {
+		case DedupFieldTitle, DedupFieldMessage, DedupFieldNotificationSource:
+		default:
+			return fmt.Errorf("fields contains invalid field. field: %s", field)
+		}
+	}
+
+	for _, key := range d.Labels {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("labels must not contain an empty key")
+		}
+	}
+
+	if d.Window <= 0 {
+		return fmt.Errorf("window should be greater than 0")
+	}
+
+	return nil
+}
+
+// EscalationConfig configures escalation policies. Notifications matching a policy are routed
+// to the policy instead of every sender, and are delivered step by step until acknowledged
+// through the API served on ListenAddress. ExternalURL is the base URL of that API used in
+// the acknowledgement link, and escalations are persisted to StateFile when it is set.
+// AckSecret signs the acknowledgement links, ESCALATION_ACK_SECRET by default. Without either,
+// a secret is generated on start and links sent before a restart stop working. Pending
+// escalations are listed on the admin server only, since they hold whole notifications.
+//
+// Steps are delivered to their senders regardless of the match and minSeverity of the
+// senders, since the policy already selected the notification.
+type EscalationConfig struct {
+	ListenAddress string             `yaml:"listenAddress"`
+	ExternalURL   string             `yaml:"externalURL"`
+	StateFile     string             `yaml:"stateFile"`
+	AckSecret     string             `yaml:"ackSecret"`
+	Policies      []EscalationPolicy `yaml:"policies"`
+}
+
+func (e EscalationConfig) Validate(senderIds map[string]bool) error {
+	if e.ListenAddress == "" {
+		return fmt.Errorf("listenAddress is required")
+	}
+
+	if len(e.Policies) == 0 {
+		return fmt.Errorf("at least one policy is required")
+	}
+
+	policyIds := make(map[string]bool, len(e.Policies))
+	for _, policy := range e.Policies {
+		if err := policy.Validate(senderIds); err != nil {
+			return fmt.Errorf("policy %s is invalid: %w", policy.Id, err)
+		}
+		if policyIds[policy.Id] {
+			return fmt.Errorf("policy id %s is duplicated", policy.Id)
+		}
+		policyIds[policy.Id] = true
+	}
+
+	return nil
+}
+
+// EscalationPolicy is an ordered list of steps. Each step is delivered Delay after the
+// previous one unless the escalation has been acknowledged.
+type EscalationPolicy struct {
+	Id    string            `yaml:"id"`
+	Match MetadataCondition `yaml:"match"`
+	Steps []EscalationStep  `yaml:"steps"`
+}
+
+func (p EscalationPolicy) Validate(senderIds map[string]bool) error {
+	if p.Id == "" {
+		return fmt.Errorf("id is required")
+	}
+
+	if err := p.Match.Validate(); err != nil {
+		return fmt.Errorf("match is invalid: %w", err)
+	}
+
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("at least one step is required")
+	}
+
+	for i, step := range p.Steps {
+		if step.Delay < 0 {
+			return fmt.Errorf("steps[%d].delay should be greater than or equal to 0", i)
+		}
+		if len(step.Senders) == 0 {
+			return fmt.Errorf("steps[%d].senders must contain at least one sender", i)
+		}
+		for _, senderId := range step.Senders {
+			if !senderIds[senderId] {
+				return fmt.Errorf("steps[%d].senders contains unknown sender %s", i, senderId)
+			}
+		}
+	}
+
+	return nil
+}
+
+type EscalationStep struct {
+	Senders []string      `yaml:"senders"`
+	Delay   time.Duration `yaml:"delay"`
+}
+
+// AdminConfig configures the administrative API that reports component status and metrics.
+type AdminConfig struct {
+	ListenAddress string `yaml:"listenAddress"`
+}
+
+func (a AdminConfig) Validate() error {
+	if a.ListenAddress == "" {
+		return fmt.Errorf("listenAddress is required")
+	}
+
+	return nil
+}
+
+// ShutdownConfig configures how in-flight notifications are drained on shutdown. Receivers
+// are stopped first, then the notifications already received are handed to the senders, whose
+// groups, delayed notifications and circuit breaker buffers are flushed. Every step ends when
+// gracePeriod, 30s by default, elapses from the start of shutdown. Notifications that are not
+// handed over by then, or are still held by sender stages, are abandoned. When queueFile is
+// set, abandoned notifications are appended to it and routed again on the next start.
+type ShutdownConfig struct {
+	GracePeriod time.Duration `yaml:"gracePeriod"`
+	QueueFile   string        `yaml:"queueFile"`
+}
+
+func (s ShutdownConfig) Validate() error {
+	if s.GracePeriod < 0 {
+		return fmt.Errorf("gracePeriod should be greater than or equal to 0")
+	}
+
+	return nil
+}
+
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+	// LogFormatLogfmt writes logfmt lines, with attributes of groups flattened into dotted keys
+	// and structured values, such as notifications, encoded as JSON.
+	LogFormatLogfmt = "logfmt"
+
+	LogOutputStdout = "stdout"
+	LogOutputStderr = "stderr"
+	LogOutputFile   = "file"
+)
+
+// LoggingConfig configures the logger of the notifier and of every component. Level and format
+// default to info and json, and logs are written to stdout unless output is set. Components
+// whose id or kind match an entry of components log at the level of that entry instead; an
+// entry with both id and kind requires both to match. Values of attributes and notification
+// labels whose key is listed in redactLabels are replaced in every log.
+type LoggingConfig struct {
+	Level        string               `yaml:"level"`
+	Format       string               `yaml:"format"`
+	Output       string               `yaml:"output"`
+	File         *LogFileConfig       `yaml:"file,omitempty"`
+	Components   []LogComponentConfig `yaml:"components"`
+	RedactLabels []string             `yaml:"redactLabels"`
+}
+
+// LogFileConfig configures the log file used by output: file. The file is rotated when it
+// would exceed maxSizeMB, keeping maxBackups rotated files named path.1, path.2 and so on.
+type LogFileConfig struct {
+	Path       string `yaml:"path"`
+	MaxSizeMB  int    `yaml:"maxSizeMB"`
+	MaxBackups int    `yaml:"maxBackups"`
+}
+
+type LogComponentConfig struct {
+	Id    string `yaml:"id"`
+	Kind  string `yaml:"kind"`
+	Level string `yaml:"level"`
+}
+
+func (l LoggingConfig) Validate() error {
+	if l.Level != "" {
+		if _, err := ParseLogLevel(l.Level); err != nil {
+			return err
+		}
+	}
+
+	switch l.Format {
+	case "", LogFormatJSON, LogFormatText, LogFormatLogfmt:
+	default:
+		return fmt.Errorf("format %s is not supported", l.Format)
+	}
+
+	switch l.Output {
+	case "", LogOutputStdout, LogOutputStderr:
+		if l.File != nil {
+			return fmt.Errorf("file is only supported with output: %s", LogOutputFile)
+		}
+	case LogOutputFile:
+		if l.File == nil {
+			return fmt.Errorf("file is required with output: %s", LogOutputFile)
+		}
+		if err := l.File.Validate(); err != nil {
+			return fmt.Errorf("file is invalid: %w", err)
+		}
+	default:
+		return fmt.Errorf("output %s is not supported", l.Output)
+	}
+
+	for i, component := range l.Components {
+		if component.Id == "" && component.Kind == "" {
+			return fmt.Errorf("components[%d]: id or kind is required", i)
+		}
+		if _, err := ParseLogLevel(component.Level); err != nil {
+			return fmt.Errorf("components[%d]: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (f LogFileConfig) Validate() error {
+	if f.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if f.MaxSizeMB < 0 {
+		return fmt.Errorf("maxSizeMB should be greater than or equal to 0")
+	}
+	if f.MaxBackups < 0 {
+		return fmt.Errorf("maxBackups should be greater than or equal to 0")
+	}
+
+	return nil
+}
+
+// HistoryConfig configures the history of notifications, stored in the BoltDB file at path.
+// Each record holds the notification, the routing decision for every sender and the delivery
+// outcomes. Records older than retention, 7 days by default, are deleted.
+type HistoryConfig struct {
+	Path      string        `yaml:"path"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+func (h HistoryConfig) Validate() error {
+	if h.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if h.Retention < 0 {
+		return fmt.Errorf("retention should be greater than or equal to 0")
+	}
+
+	return nil
+}
+
+const (
+	TracingExporterOTLP   = "otlp"
+	TracingExporterStdout = "stdout"
+)
+
+// TracingConfig configures the export of the spans traced from receivers to senders. The otlp
+// exporter sends them over OTLP/HTTP to endpoint, a URL such as http://collector:4318/v1/traces,
+// or to the endpoint set by the standard OTEL_EXPORTER_OTLP_* variables when endpoint is empty.
+// The stdout exporter prints them, which is mainly useful for debugging. serviceName defaults to
+// notifier, and sampleRatio, the ratio of traces started by the notifier that are sampled,
+// defaults to 1.
+type TracingConfig struct {
+	Exporter    string            `yaml:"exporter"`
+	Endpoint    string            `yaml:"endpoint"`
+	Insecure    bool              `yaml:"insecure"`
+	Headers     map[string]string `yaml:"headers"`
+	ServiceName string            `yaml:"serviceName"`
+	SampleRatio *float64          `yaml:"sampleRatio,omitempty"`
+}
+
+func (t TracingConfig) Validate() error {
+	switch t.Exporter {
+	case TracingExporterOTLP:
+		if t.Endpoint != "" {
+			u, err := url.Parse(t.Endpoint)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("endpoint %q is not a valid URL", t.Endpoint)
+			}
+		}
+	case TracingExporterStdout:
+		if t.Endpoint != "" || t.Insecure || len(t.Headers) > 0 {
+			return fmt.Errorf("endpoint, insecure and headers are only supported with exporter: %s", TracingExporterOTLP)
+		}
+	case "":
+		return fmt.Errorf("exporter is required")
+	default:
+		return fmt.Errorf("exporter %s is not supported", t.Exporter)
+	}
+
+	if t.SampleRatio != nil && (*t.SampleRatio < 0 || *t.SampleRatio > 1) {
+		return fmt.Errorf("sampleRatio should be between 0 and 1")
+	}
+
+	return nil
+}
+
+// ParseLogLevel parses a slog level such as debug, info, warn, error or info+2.
+func ParseLogLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("level %q is invalid", level)
+	}
+	return l, nil
+}
+
 type MetadataCondition struct {
 	NotificationSource string            `yaml:"notification_source"`
 	Labels             map[string]string `yaml:"labels"`
@@ -120,3 +120,396 @@ func decodeConfiguration(raw string) (Configuration, error) {
 
 	return cfg, nil
 }
+
+func TestConfigurationValidateAcceptsSenderGroup(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    group:
+      by: [env, team]
+      groupWait: 30s
+      groupInterval: 5m
+    properties: {}
+`)
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Configuration.Validate() returned error: %v", err)
+	}
+}
+
+func TestConfigurationValidateRejectsGroupWithoutInterval(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    group:
+      by: [env]
+      groupWait: 30s
+    properties: {}
+`)
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Configuration.Validate() unexpectedly succeeded")
+	}
+}
+
+func TestConfigurationValidateRejectsUnknownRateLimitPolicy(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    rateLimit:
+      limit: 10
+      interval: 1m
+      policy: unknown
+    properties: {}
+`)
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Configuration.Validate() unexpectedly succeeded")
+	}
+}
+
+func TestConfigurationValidateRejectsUnknownDedupField(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    dedup:
+      fields: [title, severity]
+      window: 10m
+    properties: {}
+`)
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Configuration.Validate() unexpectedly succeeded")
+	}
+}
+
+func TestConfigurationValidateRejectsUnknownMinSeverity(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    minSeverity: fatal
+    properties: {}
+`)
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Configuration.Validate() unexpectedly succeeded")
+	}
+}
+
+func TestConfigurationValidateRejectsEscalationToUnknownSender(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    properties: {}
+escalation:
+  listenAddress: :8092
+  policies:
+    - id: incident
+      match:
+        notification_source: monitor
+      steps:
+        - senders: [sender-1]
+        - senders: [sender-2]
+          delay: 10m
+`)
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Configuration.Validate() unexpectedly succeeded")
+	}
+}
+
+func TestConfigurationValidateRejectsUnknownOrCyclicFailoverMembers(t *testing.T) {
+	tests := map[string]string{
+		"unknown member": `
+  - id: failover-1
+    kind: failover
+    properties:
+      members: [sender-1, sender-2]
+`,
+		"cycle": `
+  - id: failover-1
+    kind: failover
+    properties:
+      members: [sender-1, failover-2]
+  - id: failover-2
+    kind: failover
+    properties:
+      members: [failover-1]
+`,
+	}
+
+	for name, failoverSenders := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    properties: {}
+`+failoverSenders)
+
+			if err := cfg.Validate(); err == nil {
+				t.Fatal("Configuration.Validate() unexpectedly succeeded")
+			}
+		})
+	}
+}
+
+func TestConfigurationFailoverMemberIds(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    properties: {}
+  - id: sender-2
+    kind: dummy
+    properties: {}
+  - id: failover-1
+    kind: failover
+    properties:
+      members: [sender-2]
+`)
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Configuration.Validate() returned error: %v", err)
+	}
+	if memberIds := cfg.FailoverMemberIds(); len(memberIds) != 1 || !memberIds["sender-2"] {
+		t.Fatalf("FailoverMemberIds() = %v, want sender-2 alone", memberIds)
+	}
+}
+
+func TestConfigurationValidateRejectsInvalidRestartPolicy(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    restartPolicy:
+      mode: on-failure
+      initialBackoff: 1m
+      maxBackoff: 10s
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    properties: {}
+`)
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Configuration.Validate() unexpectedly succeeded")
+	}
+}
+
+func TestConfigurationValidateRejectsReceiverCircuitBreaker(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    circuitBreaker:
+      failureThreshold: 3
+      openDuration: 1m
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    properties: {}
+`)
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Configuration.Validate() unexpectedly succeeded")
+	}
+}
+
+func TestConfigurationValidateRejectsCircuitBreakerWithoutThreshold(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    circuitBreaker:
+      openDuration: 1m
+    properties: {}
+`)
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Configuration.Validate() unexpectedly succeeded")
+	}
+}
+
+func TestConfigurationValidateRejectsNegativeGracePeriod(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    properties: {}
+shutdown:
+  gracePeriod: -1s
+`)
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Configuration.Validate() unexpectedly succeeded")
+	}
+}
+
+func TestConfigurationValidateRejectsLogFileWithoutPath(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    properties: {}
+logging:
+  level: debug
+  output: file
+  file:
+    maxSizeMB: 10
+`)
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Configuration.Validate() unexpectedly succeeded")
+	}
+}
+
+func TestConfigurationValidateRejectsInvalidComponentLogLevel(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    properties: {}
+logging:
+  components:
+    - kind: webPush
+      level: verbose
+`)
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Configuration.Validate() unexpectedly succeeded")
+	}
+}
+
+func TestConfigurationValidateAcceptsOTLPTracing(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    properties: {}
+tracing:
+  exporter: otlp
+  endpoint: http://collector:4318/v1/traces
+  headers:
+    authorization: Bearer token
+  sampleRatio: 0.5
+`)
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Configuration.Validate() error = %v", err)
+	}
+}
+
+func TestConfigurationValidateRejectsInvalidTracing(t *testing.T) {
+	tests := map[string]string{
+		"missing exporter":      `{}`,
+		"unknown exporter":      `{exporter: jaeger}`,
+		"relative endpoint":     `{exporter: otlp, endpoint: collector:4318}`,
+		"stdout with endpoint":  `{exporter: stdout, endpoint: "http://collector:4318"}`,
+		"sample ratio too high": `{exporter: stdout, sampleRatio: 1.5}`,
+	}
+
+	for name, tracing := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    properties: {}
+tracing: `+tracing+`
+`)
+
+			if err := cfg.Validate(); err == nil {
+				t.Fatal("Configuration.Validate() unexpectedly succeeded")
+			}
+		})
+	}
+}
+
+func TestConfigurationValidateRejectsHistoryWithoutAdmin(t *testing.T) {
+	cfg := mustDecodeConfiguration(t, `
+receivers:
+  - id: receiver-1
+    kind: dummy
+    properties: {}
+senders:
+  - id: sender-1
+    kind: dummy
+    properties: {}
+history:
+  path: /var/lib/notifier/history.db
+`)
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Configuration.Validate() unexpectedly succeeded")
+	}
+
+	cfg.Admin = &AdminConfig{ListenAddress: ":9090"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Configuration.Validate() error = %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package notifier
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/sender"
+)
+
+const defaultShutdownGracePeriod = 30 * time.Second
+
+// queuedNotification is a notification abandoned on shutdown. SenderIds are the senders it was
+// not handed to. A notification without SenderIds is routed again from the start.
+type queuedNotification struct {
+	SenderIds    []string                  `json:"sender_ids,omitempty"`
+	Notification notification.Notification `json:"notification"`
+}
+
+// drainStats counts the notifications senders report as delivered and collects the abandoned
+// ones. It observes every sender built on sender.Sender.
+type drainStats struct {
+	lock      sync.Mutex
+	delivered int
+	abandoned []queuedNotification
+}
+
+// Decided ignores decisions, since only deliveries are counted.
+func (d *drainStats) Decided(senderId string, n notification.Notification, decision sender.Decision) {
+}
+
+func (d *drainStats) Delivered(senderId string, n notification.Notification, err error) {
+	if err != nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.delivered++
+}
+
+func (d *drainStats) abandon(n notification.Notification, senderIds ...string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.abandoned = append(d.abandoned, queuedNotification{SenderIds: senderIds, Notification: n})
+}
+
+func (d *drainStats) deliveredCount() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.delivered
+}
+
+func (d *drainStats) abandonedNotifications() []queuedNotification {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append([]queuedNotification(nil), d.abandoned...)
+}
+
+// loadQueue reads the notifications abandoned by the previous run. A missing file is empty.
+func loadQueue(path string) ([]queuedNotification, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	queued := make([]queuedNotification, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var q queuedNotification
+		if err := json.Unmarshal(scanner.Bytes(), &q); err != nil {
+			return nil, err
+		}
+		queued = append(queued, q)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return queued, nil
+}
+
+// appendQueue appends abandoned notifications to the queue file, one JSON object per line.
+func appendQueue(path string, queued []queuedNotification) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, q := range queued {
+		body, err := json.Marshal(q)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(body, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,88 @@
+package notifier
+
+import (
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/Kotaro7750/notifier/abstraction"
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+func TestQueueFileRoundTrip(t *testing.T) {
+	queueFile := filepath.Join(t.TempDir(), "queue.jsonl")
+
+	if queued, err := loadQueue(queueFile); err != nil || len(queued) != 0 {
+		t.Fatalf("loadQueue() of missing file = %v, %v, want empty", queued, err)
+	}
+
+	if err := appendQueue(queueFile, []queuedNotification{
+		{Notification: notification.Notification{Title: "routed"}},
+	}); err != nil {
+		t.Fatalf("appendQueue returned error: %v", err)
+	}
+	if err := appendQueue(queueFile, []queuedNotification{
+		{SenderIds: []string{"push"}, Notification: notification.Notification{Title: "pending", Severity: notification.SeverityError}},
+	}); err != nil {
+		t.Fatalf("appendQueue returned error: %v", err)
+	}
+
+	queued, err := loadQueue(queueFile)
+	if err != nil {
+		t.Fatalf("loadQueue returned error: %v", err)
+	}
+	if len(queued) != 2 {
+		t.Fatalf("loadQueue() = %d notifications, want 2", len(queued))
+	}
+	if queued[0].Notification.Title != "routed" || len(queued[0].SenderIds) != 0 {
+		t.Fatalf("queued[0] = %+v, want routed without sender ids", queued[0])
+	}
+	if queued[1].SenderIds[0] != "push" || queued[1].Notification.Severity != notification.SeverityError {
+		t.Fatalf("queued[1] = %+v, want pending for push", queued[1])
+	}
+}
+
+func TestRouterAbandonsNotificationsAfterGracePeriod(t *testing.T) {
+	abandonCh := make(chan struct{})
+	stats := &drainStats{}
+	// The sender is never started, so nothing reads its channel.
+	sender := abstraction.NewAutonomousChannelComponent(&idleComponent{id: "push"})
+	router := Router{senders: []*abstraction.AutonomousChannelComponent{sender}, abandonCh: abandonCh, stats: stats}
+
+	close(abandonCh)
+	router.Route(notification.Notification{Title: "late"})
+
+	abandoned := stats.abandonedNotifications()
+	if len(abandoned) != 1 || abandoned[0].SenderIds[0] != "push" {
+		t.Fatalf("abandoned = %+v, want late for push", abandoned)
+	}
+	if stats.deliveredCount() != 0 {
+		t.Fatalf("delivered = %d, want 0", stats.deliveredCount())
+	}
+}
+
+type idleComponent struct {
+	id string
+}
+
+func (ic *idleComponent) GetId() string                 { return ic.id }
+func (ic *idleComponent) GetLogger() *slog.Logger       { return slog.Default() }
+func (ic *idleComponent) SetLogger(logger *slog.Logger) {}
+
+func (ic *idleComponent) Start(ch chan notification.Notification, done <-chan struct{}) <-chan error {
+	retCh := make(chan error)
+	close(retCh)
+	return retCh
+}
+
+func TestDrainStatsCountsReportedDeliveries(t *testing.T) {
+	stats := &drainStats{}
+
+	stats.Delivered("push", notification.Notification{Title: "delivered"}, nil)
+	stats.Delivered("push", notification.Notification{Title: "failed"}, errors.New("failed"))
+
+	if stats.deliveredCount() != 1 {
+		t.Fatalf("delivered = %d, want the successful delivery alone", stats.deliveredCount())
+	}
+}
@@ -0,0 +1,510 @@
+package escalation
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/sender"
+)
+
+// IdLabel, StepLabel and AckURLLabel annotate the notifications delivered by escalation steps
+// with the escalation, its step and the URL acknowledging it. They are reserved, so that they
+// do not overwrite labels of the notification, and the router removes them from received
+// notifications.
+const (
+	IdLabel     = "notifier_escalation_id"
+	StepLabel   = "notifier_escalation_step"
+	AckURLLabel = "notifier_ack_url"
+)
+
+const ackSecretEnvVar = "ESCALATION_ACK_SECRET"
+
+var ErrEscalationNotFound = errors.New("escalation is not found")
+
+// DeliverFunc delivers a notification to the senders with the given ids. It returns an error
+// when the notification could not be handed to some of them.
+type DeliverFunc func(n notification.Notification, senderIds []string) error
+
+// Escalation is the persisted state of one notification being escalated.
+type Escalation struct {
+	Id           string                    `json:"id"`
+	PolicyId     string                    `json:"policy_id"`
+	Notification notification.Notification `json:"notification"`
+	NextStep     int                       `json:"next_step"`
+	NextStepAt   time.Time                 `json:"next_step_at"`
+	CreatedAt    time.Time                 `json:"created_at"`
+}
+
+type policy struct {
+	id    string
+	match sender.MatchCondition
+	steps []config.EscalationStep
+}
+
+// Manager is the routing target for notifications matching an escalation policy. It reads
+// them from its channel, delivers each step of the policy through DeliverFunc until the
+// escalation is acknowledged, and serves the acknowledgement API.
+type Manager struct {
+	logger        *slog.Logger
+	listenAddress string
+	externalURL   string
+	policies      []policy
+	store         *fileStore
+	deliver       DeliverFunc
+	// ackKey signs the acknowledgement tokens. ackKeyGenerated reports that it was generated
+	// because no secret is configured.
+	ackKey          []byte
+	ackKeyGenerated bool
+	// deliveries tracks the steps being delivered, which are waited for on shutdown.
+	deliveries  sync.WaitGroup
+	lock        sync.Mutex
+	escalations map[string]*Escalation
+}
+
+func NewManager(escalationConfig config.EscalationConfig, deliver DeliverFunc) (*Manager, error) {
+	policies := make([]policy, 0, len(escalationConfig.Policies))
+	for _, p := range escalationConfig.Policies {
+		policies = append(policies, policy{
+			id:    p.Id,
+			match: sender.NewMatchCondition(p.Match),
+			steps: p.Steps,
+		})
+	}
+
+	m := &Manager{
+		logger:        nil,
+		listenAddress: escalationConfig.ListenAddress,
+		externalURL:   strings.TrimSuffix(escalationConfig.ExternalURL, "/"),
+		policies:      policies,
+		deliver:       deliver,
+		escalations:   make(map[string]*Escalation),
+	}
+
+	ackSecret := escalationConfig.AckSecret
+	if ackSecret == "" {
+		ackSecret = os.Getenv(ackSecretEnvVar)
+	}
+	if ackSecret != "" {
+		m.ackKey = []byte(ackSecret)
+	} else {
+		m.ackKey = make([]byte, 32)
+		if _, err := rand.Read(m.ackKey); err != nil {
+			return nil, fmt.Errorf("generate acknowledgement secret: %w", err)
+		}
+		m.ackKeyGenerated = true
+	}
+
+	if escalationConfig.StateFile != "" {
+		m.store = newFileStore(escalationConfig.StateFile)
+
+		escalations, err := m.store.Load()
+		if err != nil {
+			return nil, fmt.Errorf("load escalation state: %w", err)
+		}
+		for _, e := range escalations {
+			m.escalations[e.Id] = &e
+		}
+	}
+
+	return m, nil
+}
+
+func (m *Manager) GetId() string {
+	return "escalation"
+}
+
+func (m *Manager) GetLogger() *slog.Logger {
+	return m.logger
+}
+
+func (m *Manager) SetLogger(logger *slog.Logger) {
+	m.logger = logger
+}
+
+// Matches reports whether n is routed to an escalation policy.
+func (m *Manager) Matches(n notification.Notification) bool {
+	_, ok := m.policyFor(n)
+	return ok
+}
+
+func (m *Manager) Start(ch chan notification.Notification, done <-chan struct{}) <-chan error {
+	retCh := make(chan error)
+
+	m.forgetUnknownPolicies()
+	if m.ackKeyGenerated {
+		m.GetLogger().Warn("No acknowledgement secret is configured, acknowledgement links will stop working on restart", "env", ackSecretEnvVar)
+	}
+
+	s := &http.Server{
+		Addr:    m.listenAddress,
+		Handler: m.handler(),
+	}
+
+	errCh := make(chan error)
+	go func() {
+		defer close(errCh)
+		err := s.ListenAndServe()
+		errCh <- err
+	}()
+
+	go func() {
+		defer close(retCh)
+		// Steps being delivered are handed to the senders, or abandoned at the end of the
+		// shutdown grace period, before the manager reports that it stopped.
+		defer m.deliveries.Wait()
+
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		for {
+			var timerCh <-chan time.Time
+			if next, ok := m.nextStepAt(); ok {
+				timer.Reset(time.Until(next))
+				timerCh = timer.C
+			} else {
+				timer.Stop()
+			}
+
+			select {
+			case n, ok := <-ch:
+				if !ok {
+					ch = nil
+					continue
+				}
+				m.escalate(n, time.Now())
+			case <-timerCh:
+				m.runDueSteps(time.Now())
+			case err := <-errCh:
+				s.Shutdown(context.Background())
+				m.deliveries.Wait()
+				retCh <- err
+				return
+			case <-done:
+				s.Shutdown(context.Background())
+				return
+			}
+		}
+	}()
+
+	return retCh
+}
+
+var ackPage = template.Must(template.New("ack").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Acknowledge escalation</title></head>
+<body>
+  <h1>{{.Title}}</h1>
+  <form method="post">
+    <input type="hidden" name="token" value="{{.Token}}">
+    <button type="submit">Acknowledge</button>
+  </form>
+</body>
+</html>
+`))
+
+// ListHandler serves GET /escalations, which returns the pending escalations with their
+// notifications. It is not served on ListenAddress, since the acknowledgement API is reachable
+// by the recipients of the links, and is meant for the admin server instead.
+func (m *Manager) ListHandler() http.Handler {
+	serveMux := http.NewServeMux()
+
+	serveMux.HandleFunc("GET /escalations", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.list())
+	})
+
+	return serveMux
+}
+
+// handler serves the escalation API. The acknowledgement link of a notification opens a page
+// asking for confirmation, so that link previews do not acknowledge escalations. POST
+// acknowledges, and both require the token of the link.
+func (m *Manager) handler() http.Handler {
+	serveMux := http.NewServeMux()
+
+	serveMux.HandleFunc("GET /escalations/{id}/ack", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		token := r.URL.Query().Get("token")
+		if !m.validAckToken(id, token) {
+			http.Error(w, "token is invalid", http.StatusForbidden)
+			return
+		}
+
+		e, ok := m.get(id)
+		if !ok {
+			http.Error(w, ErrEscalationNotFound.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		ackPage.Execute(w, struct{ Title, Token string }{e.Notification.Title, token})
+	})
+
+	serveMux.HandleFunc("POST /escalations/{id}/ack", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if !m.validAckToken(id, r.FormValue("token")) {
+			http.Error(w, "token is invalid", http.StatusForbidden)
+			return
+		}
+
+		if err := m.Acknowledge(id); err != nil {
+			if errors.Is(err, ErrEscalationNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			m.GetLogger().Error("Acknowledge escalation failed", "escalation_id", id, "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		m.GetLogger().Info("Escalation acknowledged", "escalation_id", id)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("Acknowledged"))
+	})
+
+	return serveMux
+}
+
+// ackToken signs id, so that only the recipients of the acknowledgement link can acknowledge
+// the escalation.
+func (m *Manager) ackToken(id string) string {
+	mac := hmac.New(sha256.New, m.ackKey)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (m *Manager) validAckToken(id string, token string) bool {
+	return token != "" && hmac.Equal([]byte(token), []byte(m.ackToken(id)))
+}
+
+// Acknowledge stops the escalation so that no further steps are delivered.
+func (m *Manager) Acknowledge(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.escalations[id]; !ok {
+		return ErrEscalationNotFound
+	}
+	delete(m.escalations, id)
+	m.persist()
+
+	return nil
+}
+
+func (m *Manager) escalate(n notification.Notification, now time.Time) {
+	p, ok := m.policyFor(n)
+	if !ok {
+		m.GetLogger().Warn("Notification does not match any escalation policy", "title", n.Title)
+		return
+	}
+
+	id, err := newEscalationId()
+	if err != nil {
+		m.GetLogger().Error("Generating escalation id failed", "err", err)
+		return
+	}
+
+	m.lock.Lock()
+	m.escalations[id] = &Escalation{
+		Id:           id,
+		PolicyId:     p.id,
+		Notification: n,
+		NextStep:     0,
+		NextStepAt:   now.Add(p.steps[0].Delay),
+		CreatedAt:    now,
+	}
+	m.persist()
+	m.lock.Unlock()
+
+	m.GetLogger().Info("Escalation started", "escalation_id", id, "policy_id", p.id)
+	m.runDueSteps(now)
+}
+
+// runDueSteps delivers every step that is due by now and schedules the following ones.
+func (m *Manager) runDueSteps(now time.Time) {
+	type delivery struct {
+		n         notification.Notification
+		senderIds []string
+	}
+	deliveries := make([]delivery, 0)
+
+	m.lock.Lock()
+	for id, e := range m.escalations {
+		if e.NextStepAt.After(now) {
+			continue
+		}
+
+		p, ok := m.policy(e.PolicyId)
+		if !ok {
+			delete(m.escalations, id)
+			continue
+		}
+
+		step := p.steps[e.NextStep]
+		deliveries = append(deliveries, delivery{
+			n:         m.annotate(e),
+			senderIds: step.Senders,
+		})
+
+		e.NextStep++
+		if e.NextStep >= len(p.steps) {
+			m.GetLogger().Info("Escalation reached its last step", "escalation_id", id, "policy_id", p.id)
+			delete(m.escalations, id)
+			continue
+		}
+		e.NextStepAt = now.Add(p.steps[e.NextStep].Delay)
+	}
+	if len(deliveries) > 0 {
+		m.persist()
+	}
+	m.lock.Unlock()
+
+	// Deliver without holding the lock, since senders may not be ready to receive.
+	for _, d := range deliveries {
+		m.deliveries.Add(1)
+		go func() {
+			defer m.deliveries.Done()
+			if err := m.deliver(d.n, d.senderIds); err != nil {
+				m.GetLogger().Error("Delivering escalation step failed",
+					"escalation_id", d.n.Labels[IdLabel], "step", d.n.Labels[StepLabel], "err", err)
+			}
+		}()
+	}
+}
+
+// annotate adds the escalation id, step and acknowledgement link to the notification. Each
+// step of each policy is a route of its own for the senders.
+func (m *Manager) annotate(e *Escalation) notification.Notification {
+	n := e.Notification
+
+	labels := make(map[string]string, len(n.Labels)+4)
+	for key, value := range n.Labels {
+		labels[key] = value
+	}
+	labels[IdLabel] = e.Id
+	labels[StepLabel] = strconv.Itoa(e.NextStep + 1)
+	labels[sender.RouteLabel] = fmt.Sprintf("escalation/%s/%d", e.PolicyId, e.NextStep+1)
+
+	if m.externalURL != "" {
+		ackURL := fmt.Sprintf("%s/escalations/%s/ack?token=%s", m.externalURL, e.Id, m.ackToken(e.Id))
+		labels[AckURLLabel] = ackURL
+		n.Message = fmt.Sprintf("%s\n\nAcknowledge: %s", n.Message, ackURL)
+	}
+
+	n.Labels = labels
+	return n
+}
+
+func (m *Manager) nextStepAt() (time.Time, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var next time.Time
+	found := false
+	for _, e := range m.escalations {
+		if !found || e.NextStepAt.Before(next) {
+			next = e.NextStepAt
+			found = true
+		}
+	}
+	return next, found
+}
+
+func (m *Manager) get(id string) (Escalation, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e, ok := m.escalations[id]
+	if !ok {
+		return Escalation{}, false
+	}
+	return *e, true
+}
+
+func (m *Manager) list() []Escalation {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	escalations := make([]Escalation, 0, len(m.escalations))
+	for _, e := range m.escalations {
+		escalations = append(escalations, *e)
+	}
+	slices.SortFunc(escalations, func(a, b Escalation) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return escalations
+}
+
+// forgetUnknownPolicies drops persisted escalations whose policy has been removed from the
+// configuration since they were started.
+func (m *Manager) forgetUnknownPolicies() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for id, e := range m.escalations {
+		p, ok := m.policy(e.PolicyId)
+		if !ok || e.NextStep >= len(p.steps) {
+			m.GetLogger().Warn("Dropping escalation for unknown policy step", "escalation_id", id, "policy_id", e.PolicyId)
+			delete(m.escalations, id)
+		}
+	}
+}
+
+func (m *Manager) policyFor(n notification.Notification) (policy, bool) {
+	for _, p := range m.policies {
+		if p.match.IsMatched(n) {
+			return p, true
+		}
+	}
+	return policy{}, false
+}
+
+func (m *Manager) policy(id string) (policy, bool) {
+	for _, p := range m.policies {
+		if p.id == id {
+			return p, true
+		}
+	}
+	return policy{}, false
+}
+
+// persist saves the escalations to the state file. The caller must hold m.lock.
+func (m *Manager) persist() {
+	if m.store == nil {
+		return
+	}
+
+	escalations := make([]Escalation, 0, len(m.escalations))
+	for _, e := range m.escalations {
+		escalations = append(escalations, *e)
+	}
+
+	if err := m.store.Save(escalations); err != nil {
+		m.GetLogger().Error("Saving escalation state failed", "err", err)
+	}
+}
+
+func newEscalationId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,278 @@
+package escalation
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/sender"
+)
+
+type recordedDelivery struct {
+	n         notification.Notification
+	senderIds []string
+}
+
+type deliveryRecorder struct {
+	lock       sync.Mutex
+	deliveries []recordedDelivery
+	deliveredC chan struct{}
+}
+
+func newDeliveryRecorder() *deliveryRecorder {
+	return &deliveryRecorder{deliveredC: make(chan struct{}, 16)}
+}
+
+func (r *deliveryRecorder) deliver(n notification.Notification, senderIds []string) error {
+	r.lock.Lock()
+	r.deliveries = append(r.deliveries, recordedDelivery{n: n, senderIds: senderIds})
+	r.lock.Unlock()
+	r.deliveredC <- struct{}{}
+	return nil
+}
+
+func (r *deliveryRecorder) wait(t *testing.T) recordedDelivery {
+	t.Helper()
+
+	select {
+	case <-r.deliveredC:
+	case <-time.After(time.Second):
+		t.Fatal("notification was not delivered")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.deliveries[len(r.deliveries)-1]
+}
+
+func testEscalationConfig(stateFile string) config.EscalationConfig {
+	return config.EscalationConfig{
+		ListenAddress: ":0",
+		ExternalURL:   "https://notifier.example.com/",
+		StateFile:     stateFile,
+		Policies: []config.EscalationPolicy{
+			{
+				Id:    "incident",
+				Match: config.MetadataCondition{NotificationSource: "monitor"},
+				Steps: []config.EscalationStep{
+					{Senders: []string{"webpush"}},
+					{Senders: []string{"email"}, Delay: 10 * time.Minute},
+					{Senders: []string{"datadog"}, Delay: 5 * time.Minute},
+				},
+			},
+		},
+	}
+}
+
+func newTestManager(t *testing.T, stateFile string, recorder *deliveryRecorder) *Manager {
+	t.Helper()
+
+	m, err := NewManager(testEscalationConfig(stateFile), recorder.deliver)
+	if err != nil {
+		t.Fatalf("NewManager returned error: %v", err)
+	}
+	m.SetLogger(slog.Default())
+	return m
+}
+
+func TestManagerDeliversStepsUntilAcknowledged(t *testing.T) {
+	recorder := newDeliveryRecorder()
+	m := newTestManager(t, "", recorder)
+
+	if m.Matches(notification.Notification{NotificationSource: "billing"}) {
+		t.Fatal("Matches() = true for notification outside every policy")
+	}
+
+	now := time.Now()
+	m.escalate(notification.Notification{Title: "down", Message: "api", NotificationSource: "monitor"}, now)
+
+	first := recorder.wait(t)
+	if first.senderIds[0] != "webpush" {
+		t.Fatalf("first step senders = %v, want [webpush]", first.senderIds)
+	}
+	id := first.n.Labels[IdLabel]
+	if got, want := first.n.Labels[AckURLLabel], "https://notifier.example.com/escalations/"+id+"/ack?token="+m.ackToken(id); got != want {
+		t.Fatalf("Labels[%s] = %q, want %q", AckURLLabel, got, want)
+	}
+	if first.n.Labels[StepLabel] != "1" {
+		t.Fatalf("Labels[%s] = %q, want %q", StepLabel, first.n.Labels[StepLabel], "1")
+	}
+	if got := first.n.Labels[sender.RouteLabel]; got != "escalation/incident/1" {
+		t.Fatalf("Labels[%s] = %q, want the route of the first step", sender.RouteLabel, got)
+	}
+
+	m.runDueSteps(now.Add(9 * time.Minute))
+	m.runDueSteps(now.Add(10 * time.Minute))
+	second := recorder.wait(t)
+	if second.senderIds[0] != "email" {
+		t.Fatalf("second step senders = %v, want [email]", second.senderIds)
+	}
+
+	if err := m.Acknowledge(id); err != nil {
+		t.Fatalf("Acknowledge returned error: %v", err)
+	}
+	m.runDueSteps(now.Add(time.Hour))
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	if len(recorder.deliveries) != 2 {
+		t.Fatalf("deliveries = %d, want 2", len(recorder.deliveries))
+	}
+}
+
+func TestManagerAcknowledgeUnknownEscalation(t *testing.T) {
+	m := newTestManager(t, "", newDeliveryRecorder())
+
+	if err := m.Acknowledge("unknown"); err != ErrEscalationNotFound {
+		t.Fatalf("Acknowledge returned %v, want %v", err, ErrEscalationNotFound)
+	}
+}
+
+func TestManagerRestoresEscalationsFromStateFile(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "escalations.json")
+
+	recorder := newDeliveryRecorder()
+	m := newTestManager(t, stateFile, recorder)
+
+	now := time.Now()
+	m.escalate(notification.Notification{Title: "down", NotificationSource: "monitor"}, now)
+	recorder.wait(t)
+
+	restoredRecorder := newDeliveryRecorder()
+	restored := newTestManager(t, stateFile, restoredRecorder)
+
+	escalations := restored.list()
+	if len(escalations) != 1 {
+		t.Fatalf("restored escalations = %d, want 1", len(escalations))
+	}
+	if escalations[0].NextStep != 1 {
+		t.Fatalf("NextStep = %d, want 1", escalations[0].NextStep)
+	}
+
+	restored.runDueSteps(now.Add(10 * time.Minute))
+	if got := restoredRecorder.wait(t); got.senderIds[0] != "email" {
+		t.Fatalf("restored step senders = %v, want [email]", got.senderIds)
+	}
+}
+
+func TestManagerAcknowledgesOnlyPostsWithToken(t *testing.T) {
+	m := newTestManager(t, "", newDeliveryRecorder())
+	m.escalate(notification.Notification{Title: "down", NotificationSource: "monitor"}, time.Now())
+	id := m.list()[0].Id
+	handler := m.handler()
+
+	request := func(method, token string) *httptest.ResponseRecorder {
+		target := "/escalations/" + id + "/ack"
+		var body *strings.Reader
+		if method == http.MethodGet {
+			target += "?token=" + url.QueryEscape(token)
+			body = strings.NewReader("")
+		} else {
+			body = strings.NewReader(url.Values{"token": {token}}.Encode())
+		}
+		r := httptest.NewRequest(method, target, body)
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := request(http.MethodGet, m.ackToken(id)); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `method="post"`) {
+		t.Fatalf("GET = %d %s, want the confirmation page", w.Code, w.Body)
+	}
+	if len(m.list()) != 1 {
+		t.Fatal("GET acknowledged the escalation")
+	}
+	if w := request(http.MethodGet, "wrong"); w.Code != http.StatusForbidden {
+		t.Fatalf("GET with wrong token = %d, want 403", w.Code)
+	}
+	if w := request(http.MethodPost, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("POST without token = %d, want 403", w.Code)
+	}
+	if w := request(http.MethodPost, m.ackToken("other")); w.Code != http.StatusForbidden {
+		t.Fatalf("POST with token of another escalation = %d, want 403", w.Code)
+	}
+	if w := request(http.MethodPost, m.ackToken(id)); w.Code != http.StatusOK {
+		t.Fatalf("POST with token = %d, want 200", w.Code)
+	}
+	if len(m.list()) != 0 {
+		t.Fatal("POST did not acknowledge the escalation")
+	}
+}
+
+func TestManagerListsEscalationsOnlyOnListHandler(t *testing.T) {
+	m := newTestManager(t, "", newDeliveryRecorder())
+	m.escalate(notification.Notification{Title: "down", NotificationSource: "monitor"}, time.Now())
+
+	w := httptest.NewRecorder()
+	m.handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/escalations", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("GET /escalations on the acknowledgement API = %d, want 404", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	m.ListHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/escalations", nil))
+	var listed []Escalation
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil || len(listed) != 1 || listed[0].Notification.Title != "down" {
+		t.Fatalf("GET /escalations = %d %s, want the pending escalation", w.Code, w.Body)
+	}
+}
+
+func TestManagerSignsWithConfiguredSecret(t *testing.T) {
+	t.Setenv(ackSecretEnvVar, "from-env")
+
+	first, err := NewManager(testEscalationConfig(""), newDeliveryRecorder().deliver)
+	if err != nil {
+		t.Fatalf("NewManager returned error: %v", err)
+	}
+	second, err := NewManager(testEscalationConfig(""), newDeliveryRecorder().deliver)
+	if err != nil {
+		t.Fatalf("NewManager returned error: %v", err)
+	}
+	if first.ackKeyGenerated || first.ackToken("id") != second.ackToken("id") {
+		t.Fatal("tokens differ between managers sharing the secret")
+	}
+}
+
+func TestManagerWaitsForDeliveriesOnShutdown(t *testing.T) {
+	release := make(chan struct{})
+	delivered := make(chan struct{})
+	m, err := NewManager(testEscalationConfig(""), func(n notification.Notification, senderIds []string) error {
+		<-release
+		close(delivered)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewManager returned error: %v", err)
+	}
+	m.SetLogger(slog.Default())
+
+	ch := make(chan notification.Notification)
+	done := make(chan struct{})
+	retCh := m.Start(ch, done)
+	ch <- notification.Notification{Title: "down", NotificationSource: "monitor"}
+
+	close(done)
+	select {
+	case <-retCh:
+		t.Fatal("manager stopped before the step was delivered")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-delivered
+	select {
+	case <-retCh:
+	case <-time.After(time.Second):
+		t.Fatal("manager did not stop after the step was delivered")
+	}
+}
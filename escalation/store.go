@@ -0,0 +1,60 @@
+package escalation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// fileStore persists escalations as a JSON file so that they survive restarts.
+type fileStore struct {
+	path string
+}
+
+func newFileStore(path string) *fileStore {
+	return &fileStore{path: path}
+}
+
+func (s *fileStore) Load() ([]Escalation, error) {
+	body, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var escalations []Escalation
+	if err := json.Unmarshal(body, &escalations); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", s.path, err)
+	}
+
+	return escalations, nil
+}
+
+// Save replaces the file atomically, so that a crash while saving leaves the previous state.
+func (s *fileStore) Save(escalations []Escalation) error {
+	body, err := json.Marshal(escalations)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
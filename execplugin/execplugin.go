@@ -0,0 +1,325 @@
+// Package execplugin runs components out of process. A plugin is an executable that exchanges
+// Messages with the notifier as one JSON object per line, reading them from stdin and writing
+// them to stdout. Anything the plugin writes to stderr is forwarded to the component logger.
+//
+// The notifier sends "ping" messages periodically and the plugin must answer each with a "pong"
+// carrying the same id. Senders receive "notification" messages and answer each with an "ack",
+// or an "error" describing why it could not be delivered. Receivers write "notification"
+// messages, and those with an id are answered with an "ack" once accepted.
+package execplugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+const (
+	MessageNotification = "notification"
+	MessageAck          = "ack"
+	MessageError        = "error"
+	MessagePing         = "ping"
+	MessagePong         = "pong"
+
+	maxMessageSize = 16 * 1024 * 1024
+)
+
+var ErrHealthCheckFailed = errors.New("plugin did not answer health check")
+
+// Message is one line of the plugin protocol.
+type Message struct {
+	Type         string                     `json:"type"`
+	Id           string                     `json:"id,omitempty"`
+	Notification *notification.Notification `json:"notification,omitempty"`
+	Error        string                     `json:"error,omitempty"`
+}
+
+type Properties struct {
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+	Dir     string            `yaml:"dir"`
+	// ResponseTimeout bounds how long the plugin may take to answer a message.
+	ResponseTimeout time.Duration `yaml:"responseTimeout"`
+	// HealthInterval is the interval of health pings. 0 disables them.
+	HealthInterval time.Duration `yaml:"healthInterval"`
+	// ShutdownTimeout is how long the plugin may take to exit after its stdin is closed before
+	// it is killed.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+func NewProperties() Properties {
+	return Properties{
+		ResponseTimeout: 30 * time.Second,
+		HealthInterval:  30 * time.Second,
+		ShutdownTimeout: 5 * time.Second,
+	}
+}
+
+func (p Properties) Validate() error {
+	if p.Command == "" {
+		return fmt.Errorf("command is required")
+	}
+	if p.ResponseTimeout <= 0 {
+		return fmt.Errorf("responseTimeout should be greater than 0")
+	}
+	if p.HealthInterval < 0 {
+		return fmt.Errorf("healthInterval should be greater than or equal to 0")
+	}
+	if p.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdownTimeout should be greater than or equal to 0")
+	}
+
+	return nil
+}
+
+// Process is one running plugin.
+type Process struct {
+	properties Properties
+	logger     *slog.Logger
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	writeLock  sync.Mutex
+	encoder    *json.Encoder
+	nextId     atomic.Uint64
+	messages   chan Message
+	pongs      chan string
+	stopCh     chan struct{}
+	stopOnce   sync.Once
+	exited     chan struct{}
+	lock       sync.Mutex
+	err        error
+}
+
+// Start spawns the plugin. Messages written by the plugin are available from Messages, except
+// pongs which are consumed by the health check.
+func Start(properties Properties, logger *slog.Logger) (*Process, error) {
+	cmd := exec.Command(properties.Command, properties.Args...)
+	cmd.Dir = properties.Dir
+	cmd.Env = os.Environ()
+	for key, value := range properties.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s: %w", properties.Command, err)
+	}
+	logger.Info("Plugin started", "command", properties.Command, "pid", cmd.Process.Pid)
+
+	p := &Process{
+		properties: properties,
+		logger:     logger,
+		cmd:        cmd,
+		stdin:      stdin,
+		encoder:    json.NewEncoder(stdin),
+		messages:   make(chan Message),
+		pongs:      make(chan string, 1),
+		stopCh:     make(chan struct{}),
+		exited:     make(chan struct{}),
+	}
+
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		p.readStdout(stdout)
+	}()
+	go func() {
+		defer readers.Done()
+		p.readStderr(stderr)
+	}()
+
+	go func() {
+		// Pipes must be read to the end before Wait closes them.
+		readers.Wait()
+		err := cmd.Wait()
+		p.setErr(err)
+		close(p.exited)
+	}()
+
+	if properties.HealthInterval > 0 {
+		go p.checkHealth()
+	}
+
+	return p, nil
+}
+
+// Messages is closed when the plugin closes its stdout, usually by exiting.
+func (p *Process) Messages() <-chan Message {
+	return p.messages
+}
+
+// Send writes m to the plugin. When m has no id, a new one is assigned and returned.
+func (p *Process) Send(m Message) (string, error) {
+	if m.Id == "" {
+		m.Id = strconv.FormatUint(p.nextId.Add(1), 10)
+	}
+
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+
+	if err := p.encoder.Encode(m); err != nil {
+		return "", err
+	}
+	return m.Id, nil
+}
+
+// Wait waits for the plugin to exit and returns why it exited. It is nil when the plugin exited
+// successfully on its own or after Stop.
+func (p *Process) Wait() error {
+	<-p.exited
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.err
+}
+
+// Fail kills the plugin. Wait returns err afterwards.
+func (p *Process) Fail(err error) {
+	p.setErr(err)
+	p.stop()
+	p.cmd.Process.Kill()
+}
+
+// Stop closes the stdin of the plugin so that it exits, and kills it after ShutdownTimeout.
+func (p *Process) Stop() {
+	p.stop()
+	p.stdin.Close()
+
+	select {
+	case <-p.exited:
+	case <-time.After(p.properties.ShutdownTimeout):
+		p.logger.Warn("Plugin did not exit within shutdownTimeout, killing it")
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+
+	// The exit status of a plugin asked to stop is not a failure.
+	p.lock.Lock()
+	var exitErr *exec.ExitError
+	if errors.As(p.err, &exitErr) {
+		p.err = nil
+	}
+	p.lock.Unlock()
+}
+
+func (p *Process) stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+	})
+}
+
+// setErr keeps the first error, which is the cause of the others.
+func (p *Process) setErr(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *Process) readStdout(stdout io.Reader) {
+	defer close(p.messages)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		var m Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			p.logger.Warn("Invalid message from plugin", "line", scanner.Text(), "err", err)
+			continue
+		}
+
+		if m.Type == MessagePong {
+			select {
+			case p.pongs <- m.Id:
+			default:
+			}
+			continue
+		}
+
+		select {
+		case p.messages <- m:
+		case <-p.stopCh:
+			// Keep reading so that the plugin is not blocked on a full pipe while exiting.
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		p.logger.Warn("Reading plugin stdout failed", "err", err)
+	}
+}
+
+func (p *Process) readStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		p.logger.Info("Plugin stderr", "line", scanner.Text())
+	}
+}
+
+func (p *Process) checkHealth() {
+	ticker := time.NewTicker(p.properties.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.stopCh:
+			return
+		case <-p.exited:
+			return
+		}
+
+		id, err := p.Send(Message{Type: MessagePing})
+		if err != nil {
+			p.Fail(fmt.Errorf("%w: %w", ErrHealthCheckFailed, err))
+			return
+		}
+
+		timeout := time.NewTimer(p.properties.ResponseTimeout)
+	waitPong:
+		for {
+			select {
+			case pongId := <-p.pongs:
+				if pongId == id {
+					break waitPong
+				}
+			case <-timeout.C:
+				p.Fail(ErrHealthCheckFailed)
+				return
+			case <-p.stopCh:
+				timeout.Stop()
+				return
+			case <-p.exited:
+				timeout.Stop()
+				return
+			}
+		}
+		timeout.Stop()
+	}
+}
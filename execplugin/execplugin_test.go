@@ -0,0 +1,116 @@
+package execplugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+// TestHelperProcess is the plugin run by the tests. It answers pings unless PLUGIN_NO_PONG is
+// set, acknowledges notifications, and rejects those titled "fail".
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "helper started")
+	encoder := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var m Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			os.Exit(2)
+		}
+		switch m.Type {
+		case MessagePing:
+			if os.Getenv("PLUGIN_NO_PONG") == "" {
+				encoder.Encode(Message{Type: MessagePong, Id: m.Id})
+			}
+		case MessageNotification:
+			if m.Notification.Title == "fail" {
+				encoder.Encode(Message{Type: MessageError, Id: m.Id, Error: "rejected"})
+			} else {
+				encoder.Encode(Message{Type: MessageAck, Id: m.Id})
+			}
+		}
+	}
+	os.Exit(0)
+}
+
+func helperProperties(env map[string]string) Properties {
+	properties := NewProperties()
+	properties.Command = os.Args[0]
+	properties.Args = []string{"-test.run=TestHelperProcess"}
+	properties.Env = map[string]string{"GO_WANT_HELPER_PROCESS": "1"}
+	for key, value := range env {
+		properties.Env[key] = value
+	}
+	properties.ResponseTimeout = time.Second
+	properties.HealthInterval = 0
+	return properties
+}
+
+func TestProcessExchangesMessages(t *testing.T) {
+	p, err := Start(helperProperties(nil), slog.Default())
+	if err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+
+	for _, title := range []string{"ok", "fail"} {
+		id, err := p.Send(Message{Type: MessageNotification, Notification: &notification.Notification{Title: title}})
+		if err != nil {
+			t.Fatalf("Send returned error: %v", err)
+		}
+
+		select {
+		case m := <-p.Messages():
+			if m.Id != id {
+				t.Fatalf("answer id = %q, want %q", m.Id, id)
+			}
+			want := MessageAck
+			if title == "fail" {
+				want = MessageError
+			}
+			if m.Type != want {
+				t.Fatalf("answer type = %q, want %q", m.Type, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("plugin did not answer")
+		}
+	}
+
+	p.Stop()
+	if err := p.Wait(); err != nil {
+		t.Fatalf("Wait returned error after Stop: %v", err)
+	}
+}
+
+func TestProcessFailsWhenHealthCheckIsNotAnswered(t *testing.T) {
+	properties := helperProperties(map[string]string{"PLUGIN_NO_PONG": "1"})
+	properties.HealthInterval = 10 * time.Millisecond
+	properties.ResponseTimeout = 50 * time.Millisecond
+
+	p, err := Start(properties, slog.Default())
+	if err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+
+	for range p.Messages() {
+	}
+	if err := p.Wait(); !errors.Is(err, ErrHealthCheckFailed) {
+		t.Fatalf("Wait returned %v, want %v", err, ErrHealthCheckFailed)
+	}
+}
+
+func TestPropertiesValidateRequiresCommand(t *testing.T) {
+	if err := NewProperties().Validate(); err == nil {
+		t.Fatal("Validate unexpectedly succeeded")
+	}
+}
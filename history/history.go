@@ -0,0 +1,388 @@
+// Package history records every routed notification with the decision of each sender about it
+// and the outcomes of its deliveries, so that past notifications can be searched.
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/sender"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultRetention = 7 * 24 * time.Hour
+	pruneInterval    = 1 * time.Hour
+	// eventQueueSize bounds the events waiting to be written. Events are dropped rather than
+	// slowing down senders when the store cannot keep up.
+	eventQueueSize = 4096
+)
+
+var (
+	// recordsBucket holds records keyed by the time they were received followed by their id,
+	// so that records are iterated in time order.
+	recordsBucket = []byte("records")
+	// idsBucket maps notification ids onto keys of recordsBucket.
+	idsBucket = []byte("ids")
+)
+
+// Status is the state of a notification for one sender. Besides the statuses below, a sender
+// stage may leave a notification in the status of its sender.Decision.
+type Status string
+
+const (
+	StatusRouted    Status = "routed"
+	StatusDelivered Status = "delivered"
+	StatusFailed    Status = "failed"
+	StatusAbandoned Status = "abandoned"
+)
+
+// Overall statuses of a record, summarizing the statuses of its senders.
+const (
+	// StatusPending is the status of records still waiting for a sender.
+	StatusPending Status = "pending"
+	// StatusSuppressed is the status of records that no sender delivered nor failed to deliver.
+	StatusSuppressed Status = "suppressed"
+)
+
+// Record is the history of one notification.
+type Record struct {
+	Id           string                    `json:"id"`
+	ReceivedAt   time.Time                 `json:"received_at"`
+	Notification notification.Notification `json:"notification"`
+	// Escalated reports that the notification was routed to the escalation manager, which
+	// delivers it to senders step by step.
+	Escalated bool                     `json:"escalated,omitempty"`
+	Senders   map[string]*SenderRecord `json:"senders"`
+	Status    Status                   `json:"status"`
+}
+
+// SenderRecord is the history of one notification for one sender.
+type SenderRecord struct {
+	Status Status  `json:"status"`
+	Events []Event `json:"events"`
+}
+
+type Event struct {
+	Status Status    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// event is a change of a record waiting to be written.
+type event struct {
+	n         notification.Notification
+	at        time.Time
+	received  bool
+	escalated bool
+	senderId  string
+	status    Status
+	err       error
+}
+
+// Store records the history in a BoltDB file. Changes are queued and written in batches by a
+// single goroutine. A nil *Store records nothing.
+type Store struct {
+	db        *bolt.DB
+	logger    *slog.Logger
+	retention time.Duration
+
+	lock    sync.RWMutex
+	closed  bool
+	eventCh chan event
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+// Open opens the store configured by historyConfig and starts writing to it.
+func Open(historyConfig config.HistoryConfig, logger *slog.Logger) (*Store, error) {
+	db, err := bolt.Open(historyConfig.Path, 0o600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{recordsBucket, idsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	retention := historyConfig.Retention
+	if retention == 0 {
+		retention = defaultRetention
+	}
+
+	s := &Store{
+		db:        db,
+		logger:    logger,
+		retention: retention,
+		eventCh:   make(chan event, eventQueueSize),
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+	go s.run()
+
+	return s, nil
+}
+
+// Close writes the queued changes and closes the store.
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stopCh)
+	s.lock.Unlock()
+
+	<-s.doneCh
+	return s.db.Close()
+}
+
+// Received records that n was received. escalated reports that it was routed to the
+// escalation manager rather than to every sender.
+func (s *Store) Received(n notification.Notification, escalated bool) {
+	s.enqueue(event{n: n, received: true, escalated: escalated})
+}
+
+// Routed records that n was handed to the sender.
+func (s *Store) Routed(senderId string, n notification.Notification) {
+	s.enqueue(event{n: n, senderId: senderId, status: StatusRouted})
+}
+
+// Abandoned records that n was abandoned on shutdown before the sender delivered it.
+func (s *Store) Abandoned(senderId string, n notification.Notification) {
+	s.enqueue(event{n: n, senderId: senderId, status: StatusAbandoned})
+}
+
+// Decided records the decision of a stage of the sender about n.
+func (s *Store) Decided(senderId string, n notification.Notification, decision sender.Decision) {
+	s.enqueue(event{n: n, senderId: senderId, status: Status(decision)})
+}
+
+// Delivered records the outcome of a delivery by the sender. The outcome of a digest
+// notification is recorded for each of its members.
+func (s *Store) Delivered(senderId string, n notification.Notification, err error) {
+	status := StatusDelivered
+	if err != nil {
+		status = StatusFailed
+	}
+
+	if n.Group != nil {
+		for _, member := range n.Group.Members {
+			s.enqueue(event{n: member, senderId: senderId, status: status, err: err})
+		}
+		return
+	}
+	s.enqueue(event{n: n, senderId: senderId, status: status, err: err})
+}
+
+func (s *Store) enqueue(e event) {
+	// Notifications created by stages, such as rate limit summaries, are not recorded.
+	if s == nil || e.n.Id == "" {
+		return
+	}
+	e.at = time.Now()
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.eventCh <- e:
+	default:
+		s.logger.Warn("History queue is full, event dropped", "notification_id", e.n.Id, "sender_id", e.senderId, "status", e.status)
+	}
+}
+
+func (s *Store) run() {
+	defer close(s.doneCh)
+
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+	s.prune(time.Now())
+
+	for {
+		select {
+		case e := <-s.eventCh:
+			s.write(s.drain(e))
+		case <-pruneTicker.C:
+			s.prune(time.Now())
+		case <-s.stopCh:
+			// enqueue no longer sends once stopCh is closed.
+			for {
+				select {
+				case e := <-s.eventCh:
+					s.write(s.drain(e))
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// drain returns first followed by the events already queued.
+func (s *Store) drain(first event) []event {
+	events := []event{first}
+	for {
+		select {
+		case e := <-s.eventCh:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func (s *Store) write(events []event) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, e := range events {
+			if err := apply(tx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("Writing history failed", "events", len(events), "err", err)
+	}
+}
+
+// apply updates the record of e.n, creating it if needed, since notifications replayed from
+// the shutdown queue or delivered by escalation may not have been received in this store.
+func apply(tx *bolt.Tx, e event) error {
+	records := tx.Bucket(recordsBucket)
+	ids := tx.Bucket(idsBucket)
+
+	var record Record
+	key := ids.Get([]byte(e.n.Id))
+	if key != nil {
+		if err := json.Unmarshal(records.Get(key), &record); err != nil {
+			return fmt.Errorf("decode record %s: %w", e.n.Id, err)
+		}
+	} else {
+		key = recordKey(e.at, e.n.Id)
+		record = Record{
+			Id:           e.n.Id,
+			ReceivedAt:   e.at,
+			Notification: e.n,
+			Senders:      make(map[string]*SenderRecord),
+		}
+		if err := ids.Put([]byte(e.n.Id), key); err != nil {
+			return err
+		}
+	}
+
+	if e.received {
+		record.Escalated = record.Escalated || e.escalated
+	}
+	if e.senderId != "" {
+		senderRecord, ok := record.Senders[e.senderId]
+		if !ok {
+			senderRecord = &SenderRecord{}
+			record.Senders[e.senderId] = senderRecord
+		}
+		recorded := Event{Status: e.status, At: e.at}
+		if e.err != nil {
+			recorded.Error = e.err.Error()
+		}
+		senderRecord.Events = append(senderRecord.Events, recorded)
+		senderRecord.Status = e.status
+	}
+	record.Status = record.overallStatus()
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return records.Put(key, value)
+}
+
+// overallStatus is failed when a sender failed, pending while a sender may still deliver,
+// delivered when a sender delivered, and suppressed otherwise.
+func (r Record) overallStatus() Status {
+	pending := len(r.Senders) == 0
+	delivered := false
+	for _, senderRecord := range r.Senders {
+		switch senderRecord.Status {
+		case StatusFailed:
+			return StatusFailed
+		case StatusDelivered:
+			delivered = true
+		case StatusRouted, Status(sender.DecisionGrouped), Status(sender.DecisionDelayed), Status(sender.DecisionShortCircuited),
+			Status(sender.DecisionRequeued):
+			pending = true
+		}
+	}
+
+	switch {
+	case pending:
+		return StatusPending
+	case delivered:
+		return StatusDelivered
+	default:
+		return StatusSuppressed
+	}
+}
+
+func (s *Store) prune(now time.Time) {
+	cutoff := recordKey(now.Add(-s.retention), "")
+	pruned := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(recordsBucket)
+		ids := tx.Bucket(idsBucket)
+
+		// Deleting while iterating with a cursor skips keys, so keys are collected first.
+		expired := make([][]byte, 0)
+		c := records.Cursor()
+		for key, _ := c.First(); key != nil && bytes.Compare(key, cutoff) < 0; key, _ = c.Next() {
+			expired = append(expired, bytes.Clone(key))
+		}
+
+		for _, key := range expired {
+			if err := ids.Delete(key[8:]); err != nil {
+				return err
+			}
+			if err := records.Delete(key); err != nil {
+				return err
+			}
+		}
+		pruned = len(expired)
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("Pruning history failed", "err", err)
+		return
+	}
+	if pruned > 0 {
+		s.logger.Info("History pruned", "records", pruned)
+	}
+}
+
+func recordKey(at time.Time, id string) []byte {
+	key := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(key, uint64(at.UnixNano()))
+	return append(key, id...)
+}
@@ -0,0 +1,223 @@
+package history
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/sender"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func openStore(t *testing.T, path string) *Store {
+	t.Helper()
+
+	s, err := Open(config.HistoryConfig{Path: path}, discardLogger)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	return s
+}
+
+// reopen writes the queued events of s and opens the store again.
+func reopen(t *testing.T, s *Store, path string) *Store {
+	t.Helper()
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	s = openStore(t, path)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStoreRecordsRoutingAndDeliveries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	s := openStore(t, path)
+
+	n := notification.Notification{Id: "n-1", Title: "disk full", NotificationSource: "monitoring"}
+	s.Received(n, false)
+	s.Routed("slack", n)
+	s.Routed("pager", n)
+	s.Routed("mail", n)
+	s.Decided("pager", n, sender.DecisionBelowMinSeverity)
+	s.Delivered("slack", n, nil)
+	s.Delivered("mail", n, errors.New("connection refused"))
+	s = reopen(t, s, path)
+
+	record, err := s.Get("n-1")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if record.Status != StatusFailed {
+		t.Fatalf("Status = %q, want %q", record.Status, StatusFailed)
+	}
+	want := map[string]Status{
+		"slack": StatusDelivered,
+		"pager": Status(sender.DecisionBelowMinSeverity),
+		"mail":  StatusFailed,
+	}
+	for senderId, status := range want {
+		if got := record.Senders[senderId].Status; got != status {
+			t.Fatalf("Senders[%s].Status = %q, want %q", senderId, got, status)
+		}
+	}
+	events := record.Senders["mail"].Events
+	if len(events) != 2 || events[1].Error != "connection refused" {
+		t.Fatalf("mail events = %+v, want routed then failed with error", events)
+	}
+}
+
+func TestStoreRecordsDigestOutcomeForMembers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	s := openStore(t, path)
+
+	members := []notification.Notification{{Id: "n-1", Title: "a"}, {Id: "n-2", Title: "b"}}
+	for _, member := range members {
+		s.Received(member, false)
+		s.Routed("slack", member)
+		s.Decided("slack", member, sender.DecisionGrouped)
+	}
+	s.Delivered("slack", notification.Notification{Title: "digest", Group: &notification.Group{Members: members}}, nil)
+	s = reopen(t, s, path)
+
+	for _, member := range members {
+		record, err := s.Get(member.Id)
+		if err != nil {
+			t.Fatalf("Get(%s) error = %v", member.Id, err)
+		}
+		if record.Status != StatusDelivered {
+			t.Fatalf("Status of %s = %q, want %q", member.Id, record.Status, StatusDelivered)
+		}
+	}
+}
+
+func TestStoreQueryFilters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	s := openStore(t, path)
+
+	notifications := []notification.Notification{
+		{Id: "n-1", NotificationSource: "billing", Labels: map[string]string{"env": "prod"}},
+		{Id: "n-2", NotificationSource: "billing", Labels: map[string]string{"env": "dev"}},
+		{Id: "n-3", NotificationSource: "payments", Labels: map[string]string{"env": "prod"}},
+	}
+	for _, n := range notifications {
+		s.Received(n, false)
+		s.Routed("slack", n)
+	}
+	s.Delivered("slack", notifications[0], nil)
+	s.Decided("slack", notifications[1], sender.DecisionUnmatched)
+	s = reopen(t, s, path)
+
+	tests := map[string]struct {
+		query Query
+		want  []string
+	}{
+		"all, newest first":  {Query{}, []string{"n-3", "n-2", "n-1"}},
+		"source":             {Query{Source: "billing"}, []string{"n-2", "n-1"}},
+		"labels":             {Query{Labels: map[string]string{"env": "prod"}}, []string{"n-3", "n-1"}},
+		"overall status":     {Query{Status: StatusPending}, []string{"n-3"}},
+		"sender status":      {Query{Status: Status(sender.DecisionUnmatched)}, []string{"n-2"}},
+		"limit":              {Query{Limit: 1}, []string{"n-3"}},
+		"future time range":  {Query{From: time.Now().Add(time.Hour)}, []string{}},
+		"past time range":    {Query{To: time.Now().Add(-time.Hour)}, []string{}},
+		"current time range": {Query{From: time.Now().Add(-time.Hour), To: time.Now()}, []string{"n-3", "n-2", "n-1"}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			records, err := s.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			ids := make([]string, 0, len(records))
+			for _, record := range records {
+				ids = append(ids, record.Id)
+			}
+			if len(ids) != len(tt.want) {
+				t.Fatalf("ids = %v, want %v", ids, tt.want)
+			}
+			for i := range ids {
+				if ids[i] != tt.want[i] {
+					t.Fatalf("ids = %v, want %v", ids, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStorePrunesExpiredRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	s := openStore(t, path)
+
+	s.Received(notification.Notification{Id: "n-1"}, false)
+	s = reopen(t, s, path)
+
+	s.prune(time.Now().Add(defaultRetention + time.Minute))
+
+	if _, err := s.Get("n-1"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("Get() error = %v, want %v", err, ErrRecordNotFound)
+	}
+	records, err := s.Query(Query{})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("len(records) = %d, want 0", len(records))
+	}
+}
+
+func TestStoreIgnoresNotificationsWithoutId(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	s := openStore(t, path)
+
+	s.Delivered("slack", notification.Notification{Title: "rate limit summary"}, nil)
+	s = reopen(t, s, path)
+
+	records, err := s.Query(Query{})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("len(records) = %d, want 0", len(records))
+	}
+}
+
+func TestHandlerServesHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	s := openStore(t, path)
+
+	s.Received(notification.Notification{Id: "n-1", Labels: map[string]string{"env": "prod"}}, false)
+	s = reopen(t, s, path)
+
+	server := httptest.NewServer(s.Handler(discardLogger))
+	defer server.Close()
+
+	tests := map[string]int{
+		"/history?label=env=prod&status=pending&from=2020-01-01T00:00:00Z": http.StatusOK,
+		"/history/n-1":            http.StatusOK,
+		"/history/unknown":        http.StatusNotFound,
+		"/history?from=yesterday": http.StatusBadRequest,
+		"/history?label=env":      http.StatusBadRequest,
+		"/history?limit=-1":       http.StatusBadRequest,
+	}
+
+	for target, want := range tests {
+		res, err := http.Get(server.URL + target)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", target, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("GET %s status = %d, want %d", target, res.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+var ErrRecordNotFound = errors.New("record is not found")
+
+// Query selects records. Zero fields select every record.
+type Query struct {
+	// From and To bound the time the notifications were received, From inclusive.
+	From time.Time
+	To   time.Time
+	// Source selects notifications from this notification_source.
+	Source string
+	// Labels selects notifications having every label with the given value.
+	Labels map[string]string
+	// Status selects records whose overall status, or the status for one of their senders,
+	// is Status.
+	Status Status
+	// Limit is the maximum number of records, 100 by default and at most 1000.
+	Limit int
+}
+
+func (q Query) matches(record Record) bool {
+	if q.Source != "" && record.Notification.NotificationSource != q.Source {
+		return false
+	}
+	for key, value := range q.Labels {
+		if actual, ok := record.Notification.Labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	if q.Status != "" && record.Status != q.Status {
+		found := false
+		for _, senderRecord := range record.Senders {
+			if senderRecord.Status == q.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Query returns the records selected by q, the most recently received first.
+func (s *Store) Query(q Query) ([]Record, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultQueryLimit
+	}
+	limit = min(limit, maxQueryLimit)
+
+	var from []byte
+	if !q.From.IsZero() {
+		from = recordKey(q.From, "")
+	}
+
+	records := make([]Record, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(recordsBucket).Cursor()
+
+		var key, value []byte
+		if q.To.IsZero() {
+			key, value = c.Last()
+		} else {
+			// Seek positions the cursor at the first key received at To or later, which is
+			// excluded.
+			key, value = c.Seek(recordKey(q.To, ""))
+			if key == nil {
+				key, value = c.Last()
+			} else {
+				key, value = c.Prev()
+			}
+		}
+
+		for ; key != nil && len(records) < limit; key, value = c.Prev() {
+			if from != nil && bytes.Compare(key, from) < 0 {
+				break
+			}
+
+			var record Record
+			if err := json.Unmarshal(value, &record); err != nil {
+				return fmt.Errorf("decode record: %w", err)
+			}
+			if q.matches(record) {
+				records = append(records, record)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Get returns the record of the notification with the given id.
+func (s *Store) Get(id string) (Record, error) {
+	var record Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key := tx.Bucket(idsBucket).Get([]byte(id))
+		if key == nil {
+			return ErrRecordNotFound
+		}
+		return json.Unmarshal(tx.Bucket(recordsBucket).Get(key), &record)
+	})
+	return record, err
+}
+
+// Handler serves the history API: GET /history searches records and GET /history/{id} returns
+// the record of one notification. Search parameters are from and to in RFC 3339, source,
+// label as key=value and repeatable, status and limit.
+func (s *Store) Handler(logger *slog.Logger) http.Handler {
+	serveMux := http.NewServeMux()
+
+	serveMux.HandleFunc("GET /history", func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		records, err := s.Query(q)
+		if err != nil {
+			logger.Error("Querying history failed", "err", err)
+			http.Error(w, "querying history failed", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+	})
+
+	serveMux.HandleFunc("GET /history/{id}", func(w http.ResponseWriter, r *http.Request) {
+		record, err := s.Get(r.PathValue("id"))
+		if errors.Is(err, ErrRecordNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Reading history failed", "err", err)
+			http.Error(w, "reading history failed", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(record)
+	})
+
+	return serveMux
+}
+
+func parseQuery(r *http.Request) (Query, error) {
+	values := r.URL.Query()
+	q := Query{
+		Source: values.Get("source"),
+		Status: Status(values.Get("status")),
+	}
+
+	for _, param := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		value := values.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return Query{}, fmt.Errorf("%s is not an RFC 3339 time: %q", param.name, value)
+		}
+		*param.dst = t
+	}
+
+	for _, label := range values["label"] {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			return Query{}, fmt.Errorf("label should be key=value: %q", label)
+		}
+		if q.Labels == nil {
+			q.Labels = make(map[string]string)
+		}
+		q.Labels[key] = value
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return Query{}, fmt.Errorf("limit should be a positive integer: %q", value)
+		}
+		q.Limit = limit
+	}
+
+	return q, nil
+}
@@ -0,0 +1,183 @@
+package logging
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const logfmtTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// logfmtHandler writes records as logfmt lines: space separated key=value pairs, whose values
+// are quoted when they contain spaces, quotes, '=' or control characters. Attributes of groups
+// are flattened into dotted keys, and values that are neither scalars nor text marshalers, such
+// as notifications, are encoded as JSON.
+type logfmtHandler struct {
+	w       io.Writer
+	lock    *sync.Mutex
+	options slog.HandlerOptions
+	// attrs holds the pairs added by WithAttrs, already encoded.
+	attrs  []byte
+	groups []string
+}
+
+func newLogfmtHandler(w io.Writer, options *slog.HandlerOptions) *logfmtHandler {
+	h := &logfmtHandler{w: w, lock: &sync.Mutex{}}
+	if options != nil {
+		h.options = *options
+	}
+	return h
+}
+
+func (h *logfmtHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.options.Level != nil {
+		minLevel = h.options.Level.Level()
+	}
+	return level >= minLevel
+}
+
+func (h *logfmtHandler) Handle(_ context.Context, record slog.Record) error {
+	buf := make([]byte, 0, 256)
+	if !record.Time.IsZero() {
+		buf = h.appendAttr(buf, nil, slog.Time(slog.TimeKey, record.Time))
+	}
+	buf = h.appendAttr(buf, nil, slog.Any(slog.LevelKey, record.Level))
+	buf = h.appendAttr(buf, nil, slog.String(slog.MessageKey, record.Message))
+	if len(h.attrs) > 0 {
+		buf = append(buf, ' ')
+		buf = append(buf, h.attrs...)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		buf = h.appendAttr(buf, h.groups, attr)
+		return true
+	})
+	buf = append(buf, '\n')
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	_, err := h.w.Write(buf)
+	return err
+}
+
+func (h *logfmtHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	child := *h
+	child.attrs = slices.Clone(h.attrs)
+	for _, attr := range attrs {
+		child.attrs = h.appendAttr(child.attrs, h.groups, attr)
+	}
+	return &child
+}
+
+func (h *logfmtHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	child := *h
+	child.groups = append(slices.Clip(h.groups), name)
+	return &child
+}
+
+// appendAttr appends attr as key=value, preceded by a space unless buf is empty. Groups are
+// appended as one pair per attribute.
+func (h *logfmtHandler) appendAttr(buf []byte, groups []string, attr slog.Attr) []byte {
+	attr.Value = attr.Value.Resolve()
+	if attr.Value.Kind() != slog.KindGroup && h.options.ReplaceAttr != nil {
+		attr = h.options.ReplaceAttr(groups, attr)
+		attr.Value = attr.Value.Resolve()
+	}
+	if attr.Equal(slog.Attr{}) {
+		return buf
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			groups = append(slices.Clip(groups), attr.Key)
+		}
+		for _, groupAttr := range attr.Value.Group() {
+			buf = h.appendAttr(buf, groups, groupAttr)
+		}
+		return buf
+	}
+
+	if len(buf) > 0 {
+		buf = append(buf, ' ')
+	}
+	buf = appendLogfmtKey(buf, strings.Join(append(slices.Clip(groups), attr.Key), "."))
+	buf = append(buf, '=')
+	return appendLogfmtValue(buf, attr.Value)
+}
+
+// appendLogfmtKey appends key with the characters that would end it replaced by '_'.
+func appendLogfmtKey(buf []byte, key string) []byte {
+	if key == "" {
+		return append(buf, '_')
+	}
+	for _, r := range key {
+		if r <= ' ' || r == '=' || r == '"' || !unicode.IsPrint(r) {
+			r = '_'
+		}
+		buf = append(buf, string(r)...)
+	}
+	return buf
+}
+
+func appendLogfmtValue(buf []byte, value slog.Value) []byte {
+	var s string
+	switch value.Kind() {
+	case slog.KindString:
+		s = value.String()
+	case slog.KindTime:
+		s = value.Time().Format(logfmtTimeFormat)
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case error:
+			s = v.Error()
+		case encoding.TextMarshaler:
+			text, err := v.MarshalText()
+			if err != nil {
+				s = fmt.Sprintf("!ERROR:%v", err)
+			} else {
+				s = string(text)
+			}
+		case []byte:
+			s = string(v)
+		default:
+			body, err := json.Marshal(v)
+			if err != nil {
+				s = fmt.Sprintf("%+v", v)
+			} else {
+				s = string(body)
+			}
+		}
+	default:
+		s = value.String()
+	}
+
+	if needsLogfmtQuote(s) {
+		return strconv.AppendQuote(buf, s)
+	}
+	return append(buf, s...)
+}
+
+func needsLogfmtQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+// Package logging builds the logger configured by the logging section of the configuration.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+const redacted = "[REDACTED]"
+
+// New returns the logger configured by loggingConfig, and the output to close when the logger
+// is no longer used.
+func New(loggingConfig config.LoggingConfig) (*slog.Logger, io.Closer, error) {
+	level := slog.LevelInfo
+	if loggingConfig.Level != "" {
+		var err error
+		if level, err = config.ParseLogLevel(loggingConfig.Level); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	overrides := make([]levelOverride, 0, len(loggingConfig.Components))
+	minLevel := level
+	for _, component := range loggingConfig.Components {
+		componentLevel, err := config.ParseLogLevel(component.Level)
+		if err != nil {
+			return nil, nil, err
+		}
+		overrides = append(overrides, levelOverride{id: component.Id, kind: component.Kind, level: componentLevel})
+		minLevel = min(minLevel, componentLevel)
+	}
+
+	var output io.WriteCloser
+	switch loggingConfig.Output {
+	case config.LogOutputStderr:
+		output = nopCloser{os.Stderr}
+	case config.LogOutputFile:
+		file, err := newRotatingFile(loggingConfig.File.Path, int64(loggingConfig.File.MaxSizeMB)*1024*1024, loggingConfig.File.MaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		output = file
+	default:
+		output = nopCloser{os.Stdout}
+	}
+
+	// The base handler accepts every level used by some component; componentHandler filters.
+	options := &slog.HandlerOptions{
+		Level:       minLevel,
+		ReplaceAttr: newRedactor(loggingConfig.RedactLabels),
+	}
+
+	var handler slog.Handler
+	switch loggingConfig.Format {
+	case config.LogFormatText:
+		handler = slog.NewTextHandler(output, options)
+	case config.LogFormatLogfmt:
+		handler = newLogfmtHandler(output, options)
+	default:
+		handler = slog.NewJSONHandler(output, options)
+	}
+
+	return slog.New(&componentHandler{
+		handler:      handler,
+		defaultLevel: level,
+		level:        level,
+		overrides:    overrides,
+	}), output, nil
+}
+
+type levelOverride struct {
+	id    string
+	kind  string
+	level slog.Level
+}
+
+func (o levelOverride) matches(id string, kind string) bool {
+	if o.id != "" && o.id != id {
+		return false
+	}
+	if o.kind != "" && o.kind != kind {
+		return false
+	}
+	return true
+}
+
+// componentHandler applies the level override of the component identified by the "id" and
+// "kind" attributes that builder.Build adds to the logger of each component.
+type componentHandler struct {
+	handler      slog.Handler
+	defaultLevel slog.Level
+	level        slog.Level
+	overrides    []levelOverride
+	id           string
+	kind         string
+}
+
+func (h *componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.handler.Enabled(ctx, level)
+}
+
+func (h *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler.Handle(ctx, record)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	child := *h
+	child.handler = h.handler.WithAttrs(attrs)
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "id":
+			child.id = attr.Value.String()
+		case "kind":
+			child.kind = attr.Value.String()
+		}
+	}
+	child.level = child.resolveLevel()
+	return &child
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	child := *h
+	child.handler = h.handler.WithGroup(name)
+	return &child
+}
+
+// resolveLevel prefers overrides that name the component id over those naming only its kind.
+func (h *componentHandler) resolveLevel() slog.Level {
+	if h.id == "" && h.kind == "" {
+		return h.defaultLevel
+	}
+
+	for _, byId := range []bool{true, false} {
+		for _, override := range h.overrides {
+			if (override.id != "") == byId && override.matches(h.id, h.kind) {
+				return override.level
+			}
+		}
+	}
+	return h.defaultLevel
+}
+
+// newRedactor returns a ReplaceAttr function hiding the values of the given keys, both in plain
+// attributes and in the labels of logged notifications.
+func newRedactor(keys []string) func(groups []string, attr slog.Attr) slog.Attr {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	sensitive := func(key string) bool {
+		return slices.ContainsFunc(keys, func(k string) bool {
+			return strings.EqualFold(k, key)
+		})
+	}
+
+	return func(groups []string, attr slog.Attr) slog.Attr {
+		if sensitive(attr.Key) {
+			return slog.String(attr.Key, redacted)
+		}
+		if attr.Value.Kind() != slog.KindAny {
+			return attr
+		}
+
+		switch v := attr.Value.Any().(type) {
+		case notification.Notification:
+			return slog.Any(attr.Key, redactNotification(v, sensitive))
+		case *notification.Notification:
+			if v != nil {
+				redactedNotification := redactNotification(*v, sensitive)
+				return slog.Any(attr.Key, &redactedNotification)
+			}
+		case map[string]string:
+			return slog.Any(attr.Key, redactLabels(v, sensitive))
+		}
+		return attr
+	}
+}
+
+func redactNotification(n notification.Notification, sensitive func(string) bool) notification.Notification {
+	n.Labels = redactLabels(n.Labels, sensitive)
+	if n.Group != nil {
+		group := *n.Group
+		group.Labels = redactLabels(group.Labels, sensitive)
+		members := make([]notification.Notification, 0, len(group.Members))
+		for _, member := range group.Members {
+			members = append(members, redactNotification(member, sensitive))
+		}
+		group.Members = members
+		n.Group = &group
+	}
+	return n
+}
+
+func redactLabels(labels map[string]string, sensitive func(string) bool) map[string]string {
+	if labels == nil {
+		return nil
+	}
+
+	redactedLabels := make(map[string]string, len(labels))
+	for key, value := range labels {
+		if sensitive(key) {
+			value = redacted
+		}
+		redactedLabels[key] = value
+	}
+	return redactedLabels
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
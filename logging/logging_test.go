@@ -0,0 +1,163 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+func readLogLines(t *testing.T, path string) []map[string]any {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open log file: %v", err)
+	}
+	defer f.Close()
+
+	lines := make([]map[string]any, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("unmarshal log line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestNewAppliesComponentLevelOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifier.log")
+	logger, output, err := New(config.LoggingConfig{
+		Level:  "warn",
+		Output: config.LogOutputFile,
+		File:   &config.LogFileConfig{Path: path},
+		Components: []config.LogComponentConfig{
+			{Kind: "webPush", Level: "error"},
+			{Id: "push-debug", Kind: "webPush", Level: "debug"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	logger.Info("base info")
+	logger.With("type", "sender", "kind", "webPush", "id", "push").Warn("kind warn")
+	logger.With("type", "sender", "kind", "webPush", "id", "push-debug").Debug("id debug")
+	logger.With("type", "sender", "kind", "dummy", "id", "dummy").Warn("default warn")
+	output.Close()
+
+	messages := make([]string, 0)
+	for _, line := range readLogLines(t, path) {
+		messages = append(messages, line["msg"].(string))
+	}
+	if got, want := strings.Join(messages, ","), "id debug,default warn"; got != want {
+		t.Fatalf("logged messages = %s, want %s", got, want)
+	}
+}
+
+func TestNewRedactsSensitiveLabels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifier.log")
+	logger, output, err := New(config.LoggingConfig{
+		Output:       config.LogOutputFile,
+		File:         &config.LogFileConfig{Path: path},
+		RedactLabels: []string{"token"},
+	})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	n := notification.Notification{Title: "t", Labels: map[string]string{"token": "secret", "env": "prod"}}
+	logger.Info("sent", "notification", n, "Token", "secret")
+	output.Close()
+
+	lines := readLogLines(t, path)
+	if strings.Contains(lines[0]["notification"].(map[string]any)["labels"].(map[string]any)["token"].(string), "secret") {
+		t.Fatalf("notification label is not redacted: %v", lines[0])
+	}
+	if lines[0]["notification"].(map[string]any)["labels"].(map[string]any)["env"] != "prod" {
+		t.Fatalf("unrelated label is redacted: %v", lines[0])
+	}
+	if lines[0]["Token"] != redacted {
+		t.Fatalf("Token attribute = %v, want %s", lines[0]["Token"], redacted)
+	}
+	if n.Labels["token"] != "secret" {
+		t.Fatal("redaction modified the logged notification")
+	}
+}
+
+func TestNewWritesLogfmt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifier.log")
+	logger, output, err := New(config.LoggingConfig{
+		Format:       config.LogFormatLogfmt,
+		Output:       config.LogOutputFile,
+		File:         &config.LogFileConfig{Path: path},
+		RedactLabels: []string{"token"},
+	})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	n := notification.Notification{Title: "disk full", Labels: map[string]string{"token": "secret"}}
+	logger.With("type", "sender").WithGroup("delivery").Info("Sent notification", "attempt", 2, "err", errors.New(`said "no"`), "notification", n)
+	output.Close()
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log file: %v", err)
+	}
+	line := strings.TrimSpace(string(body))
+	for _, want := range []string{
+		` level=INFO msg="Sent notification" type=sender delivery.attempt=2 delivery.err="said \"no\""`,
+		`delivery.notification="{\"title\":\"disk full\"`,
+		`\"token\":\"[REDACTED]\"`,
+	} {
+		if !strings.Contains(line, want) {
+			t.Fatalf("line = %s, want it to contain %s", line, want)
+		}
+	}
+	if !strings.HasPrefix(line, "time=") || strings.Contains(line, "secret") {
+		t.Fatalf("line = %s, want a time and no secret", line)
+	}
+}
+
+func TestRotatingFileKeepsBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifier.log")
+	rf, err := newRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("newRotatingFile returned error: %v", err)
+	}
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+	}
+	rf.Close()
+
+	want := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for file, content := range want {
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+		if string(got) != content {
+			t.Fatalf("%s = %q, want %q", file, got, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("%s.3 exists beyond maxBackups", path)
+	}
+}
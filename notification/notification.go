@@ -1,13 +1,34 @@
 package notification
 
 import (
-	"log/slog"
+	"crypto/rand"
+	"encoding/hex"
 )
 
 type Notification struct {
+	// Id identifies the notification across components. The router assigns it to every
+	// notification it receives, replacing the id set by the client, if any.
+	Id                 string            `json:"id,omitempty"`
 	Title              string            `json:"title"`
-	Severity           slog.Level        `json:"severity"`
+	Severity           Severity          `json:"severity"`
 	Message            string            `json:"message"`
 	NotificationSource string            `json:"notification_source"`
 	Labels             map[string]string `json:"labels"`
+	Group              *Group            `json:"group,omitempty"`
+	// TraceContext carries the W3C trace context of the notification across components.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// Group describes the notifications aggregated into a single digest notification.
+// Labels holds the values of the label keys the members were grouped by.
+type Group struct {
+	Labels  map[string]string `json:"labels"`
+	Members []Notification    `json:"members"`
+}
+
+// NewId returns a random notification id.
+func NewId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
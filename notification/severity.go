@@ -0,0 +1,105 @@
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// Severity is the importance of a notification. It shares its scale with slog.Level, so integer
+// severities sent by existing clients keep their meaning.
+type Severity int
+
+const (
+	SeverityDebug    Severity = Severity(slog.LevelDebug)
+	SeverityInfo     Severity = Severity(slog.LevelInfo)
+	SeverityWarning  Severity = Severity(slog.LevelWarn)
+	SeverityError    Severity = Severity(slog.LevelError)
+	SeverityCritical Severity = Severity(slog.LevelError + 4)
+)
+
+var severityNames = []struct {
+	severity Severity
+	name     string
+}{
+	{SeverityCritical, "critical"},
+	{SeverityError, "error"},
+	{SeverityWarning, "warning"},
+	{SeverityInfo, "info"},
+	{SeverityDebug, "debug"},
+}
+
+// ParseSeverity parses a named severity such as "warning", optionally followed by an offset
+// such as "error+2", or an integer on the slog.Level scale. Names are case-insensitive and
+// "warn" is accepted as an alias of "warning".
+func ParseSeverity(s string) (Severity, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+
+	if n, err := strconv.Atoi(value); err == nil {
+		return Severity(n), nil
+	}
+
+	name, offset := value, 0
+	if i := strings.IndexAny(value, "+-"); i > 0 {
+		n, err := strconv.Atoi(value[i:])
+		if err != nil {
+			return 0, fmt.Errorf("severity %q has invalid offset", s)
+		}
+		name, offset = value[:i], n
+	}
+
+	if name == "warn" {
+		name = "warning"
+	}
+
+	for _, named := range severityNames {
+		if named.name == name {
+			return named.severity + Severity(offset), nil
+		}
+	}
+
+	return 0, fmt.Errorf("severity %q is unknown", s)
+}
+
+// String returns the name of the severity, with an offset from the closest lower named
+// severity when it does not have a name of its own.
+func (s Severity) String() string {
+	for _, named := range severityNames {
+		if s >= named.severity {
+			return withOffset(named.name, int(s-named.severity))
+		}
+	}
+
+	return withOffset("debug", int(s-SeverityDebug))
+}
+
+func withOffset(name string, offset int) string {
+	if offset == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s%+d", name, offset)
+}
+
+// UnmarshalJSON accepts either an integer or a string understood by ParseSeverity. Severities
+// are marshaled as integers, so that consumers of notifications keep the wire format.
+func (s *Severity) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*s = Severity(n)
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("severity must be a number or a string: %w", err)
+	}
+
+	parsed, err := ParseSeverity(name)
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
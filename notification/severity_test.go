@@ -0,0 +1,87 @@
+package notification
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseSeverity(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		raw  string
+		want Severity
+	}{
+		{raw: "debug", want: SeverityDebug},
+		{raw: "info", want: SeverityInfo},
+		{raw: "warning", want: SeverityWarning},
+		{raw: "WARN", want: SeverityWarning},
+		{raw: "error", want: SeverityError},
+		{raw: "critical", want: SeverityCritical},
+		{raw: "error+2", want: SeverityError + 2},
+		{raw: "debug-1", want: SeverityDebug - 1},
+		{raw: "6", want: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseSeverity(tt.raw)
+			if err != nil {
+				t.Fatalf("ParseSeverity(%q) returned error: %v", tt.raw, err)
+			}
+			if got != tt.want {
+				t.Fatalf("ParseSeverity(%q) = %d, want %d", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSeverityRejectsUnknownName(t *testing.T) {
+	if _, err := ParseSeverity("fatal"); err == nil {
+		t.Fatal("ParseSeverity unexpectedly succeeded")
+	}
+}
+
+func TestSeverityStringRoundTrips(t *testing.T) {
+	for _, severity := range []Severity{SeverityDebug - 2, SeverityDebug, SeverityInfo + 1, SeverityWarning, SeverityError + 3, SeverityCritical + 5} {
+		parsed, err := ParseSeverity(severity.String())
+		if err != nil {
+			t.Fatalf("ParseSeverity(%q) returned error: %v", severity.String(), err)
+		}
+		if parsed != severity {
+			t.Fatalf("ParseSeverity(%q) = %d, want %d", severity.String(), parsed, severity)
+		}
+	}
+}
+
+func TestNotificationUnmarshalAcceptsNamedAndIntegerSeverity(t *testing.T) {
+	var named Notification
+	if err := json.Unmarshal([]byte(`{"title":"t","severity":"critical"}`), &named); err != nil {
+		t.Fatalf("json.Unmarshal returned error: %v", err)
+	}
+	if named.Severity != SeverityCritical {
+		t.Fatalf("Severity = %d, want %d", named.Severity, SeverityCritical)
+	}
+
+	var integer Notification
+	if err := json.Unmarshal([]byte(`{"title":"t","severity":4}`), &integer); err != nil {
+		t.Fatalf("json.Unmarshal returned error: %v", err)
+	}
+	if integer.Severity != SeverityWarning {
+		t.Fatalf("Severity = %d, want %d", integer.Severity, SeverityWarning)
+	}
+
+	body, err := json.Marshal(integer)
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal returned error: %v", err)
+	}
+	if decoded["severity"] != float64(SeverityWarning) {
+		t.Fatalf("severity = %v, want the integer %d", decoded["severity"], SeverityWarning)
+	}
+}
@@ -0,0 +1,375 @@
+// Package notifier runs the notifier as a library, so that a custom binary can register its own
+// component kinds with the builder package before calling Run.
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Kotaro7750/notifier/abstraction"
+	"github.com/Kotaro7750/notifier/admin"
+	"github.com/Kotaro7750/notifier/builder"
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/escalation"
+	"github.com/Kotaro7750/notifier/history"
+	"github.com/Kotaro7750/notifier/logging"
+	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/tracing"
+)
+
+const tracingShutdownTimeout = 5 * time.Second
+
+type options struct {
+	logger *slog.Logger
+}
+
+// Option customizes Run.
+type Option func(*options)
+
+// WithLogger sets the logger of the notifier and of every component, in place of the logger
+// configured by the logging section. Without either, logs are written to stdout as JSON.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Run builds the components configured in cfg and routes notifications from the receivers to
+// the senders until ctx is done. It then drains in-flight notifications and returns after every
+// component has shut down. Component kinds registered with builder.RegisterSender and
+// builder.RegisterReceiver are available to cfg.
+func Run(ctx context.Context, cfg config.Configuration, opts ...Option) error {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	logger := o.logger
+	if logger == nil && cfg.Logging != nil {
+		configuredLogger, output, err := logging.New(*cfg.Logging)
+		if err != nil {
+			return fmt.Errorf("logging: %w", err)
+		}
+		defer output.Close()
+		logger = configuredLogger
+	}
+	if logger == nil {
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+
+	if cfg.Tracing != nil {
+		shutdownTracing, err := tracing.Setup(ctx, *cfg.Tracing)
+		if err != nil {
+			return fmt.Errorf("tracing: %w", err)
+		}
+		defer func() {
+			// Spans are flushed even after ctx is canceled, within a bounded time.
+			flushCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+			defer cancel()
+			if err := shutdownTracing(flushCtx); err != nil {
+				logger.Error("Flushing spans failed", "err", err)
+			}
+		}()
+	}
+
+	stats := &drainStats{}
+	var historyStore *history.Store
+	observers := []builder.SenderObserver{stats}
+	if cfg.History != nil {
+		var err error
+		historyStore, err = history.Open(*cfg.History, logger.With("type", "history"))
+		if err != nil {
+			return fmt.Errorf("open history: %w", err)
+		}
+		// Deferred before components are started, so that it runs after they have stopped.
+		defer historyStore.Close()
+		observers = append(observers, historyStore)
+	}
+
+	receivers, senders, err := builder.Build(logger, cfg.ReceiverConfigurations, cfg.SenderConfigurations, observers...)
+
+	if err != nil {
+		return fmt.Errorf("build: %w", err)
+	}
+
+	senderChs := make([]<-chan struct{}, len(senders))
+
+	receiverChs := make([]<-chan struct{}, len(receivers))
+
+	for i, sender := range senders {
+		senderChs[i] = sender.Start()
+	}
+
+	for i, receiver := range receivers {
+		receiverChs[i] = receiver.Start()
+	}
+
+	abandonCh := make(chan struct{})
+	router := Router{senders: senders, failoverMembers: cfg.FailoverMemberIds(), abandonCh: abandonCh, stats: stats, history: historyStore}
+
+	var escalationComponent *abstraction.AutonomousChannelComponent
+	var escalationCh <-chan struct{}
+	if cfg.Escalation != nil {
+		manager, err := escalation.NewManager(*cfg.Escalation, router.deliverTo)
+		if err != nil {
+			stopComponents(receivers, receiverChs, senders, senderChs)
+			return fmt.Errorf("build escalation: %w", err)
+		}
+		manager.SetLogger(logger.With("type", "escalation", "id", manager.GetId()))
+
+		escalationComponent = abstraction.NewAutonomousChannelComponent(manager)
+		builder.Observe("escalation", escalationComponent, receivers)
+		escalationCh = escalationComponent.Start()
+
+		router.escalation = manager
+		router.escalationComponent = escalationComponent
+	}
+
+	adminDone := make(chan struct{})
+	var adminCh <-chan error
+	if cfg.Admin != nil {
+		adminServer := admin.NewServer(cfg.Admin.ListenAddress, logger.With("type", "admin"))
+		adminServer.AddComponents("receiver", receivers...)
+		adminServer.AddComponents("sender", senders...)
+		if escalationComponent != nil {
+			adminServer.AddComponents("escalation", escalationComponent)
+			adminServer.Handle("/escalations", router.escalation.ListHandler())
+		}
+		if historyStore != nil {
+			historyHandler := historyStore.Handler(logger.With("type", "history"))
+			adminServer.Handle("/history", historyHandler)
+			adminServer.Handle("/history/", historyHandler)
+		}
+
+		adminCh = adminServer.Start(adminDone)
+		go func() {
+			if err := <-adminCh; err != nil {
+				logger.Error("Admin server failed", "err", err)
+			}
+		}()
+	}
+
+	gracePeriod := defaultShutdownGracePeriod
+	queueFile := ""
+	if cfg.Shutdown != nil {
+		if cfg.Shutdown.GracePeriod > 0 {
+			gracePeriod = cfg.Shutdown.GracePeriod
+		}
+		queueFile = cfg.Shutdown.QueueFile
+	}
+
+	routerCh := make(chan notification.Notification)
+
+	forwarderWg := sync.WaitGroup{}
+	for _, receiver := range receivers {
+		forwarderWg.Add(1)
+		go func(r *abstraction.AutonomousChannelComponent) {
+			defer forwarderWg.Done()
+			for {
+				select {
+				case n, ok := <-r.GetChannel():
+					if !ok {
+						return
+					}
+					select {
+					case routerCh <- n:
+					case <-abandonCh:
+						stats.abandon(n)
+					}
+				case <-abandonCh:
+					// The receiver did not close its channel within the grace period.
+					return
+				}
+			}
+		}(receiver)
+	}
+
+	routerDone := make(chan struct{})
+	go func() {
+		defer close(routerDone)
+		for n := range routerCh {
+			router.Route(n)
+		}
+	}()
+
+	replayDone := make(chan struct{})
+	if queueFile == "" {
+		close(replayDone)
+	} else {
+		queued, err := loadQueue(queueFile)
+		if err != nil {
+			logger.Error("Loading queue file failed", "err", err)
+			close(replayDone)
+		} else if len(queued) == 0 {
+			close(replayDone)
+		} else {
+			if err := os.Remove(queueFile); err != nil {
+				logger.Error("Removing queue file failed", "err", err)
+			}
+			logger.Info("Routing notifications abandoned by the previous run", "count", len(queued))
+			go func() {
+				defer close(replayDone)
+				for i, q := range queued {
+					select {
+					case <-abandonCh:
+						// The file is already removed, so the rest is abandoned again.
+						for _, rest := range queued[i:] {
+							stats.abandon(rest.Notification, rest.SenderIds...)
+						}
+						return
+					default:
+					}
+
+					if len(q.SenderIds) == 0 {
+						router.route(q.Notification)
+					} else {
+						if err := router.deliverTo(q.Notification, q.SenderIds); err != nil {
+							logger.Error("Routing abandoned notification failed", "title", q.Notification.Title, "err", err)
+						}
+					}
+				}
+			}()
+		}
+	}
+
+	<-ctx.Done()
+	logger.Info("Shutting down", "grace_period", gracePeriod)
+
+	close(adminDone)
+
+	// Every wait below ends with the grace period. Notifications that are not handed to a
+	// component by then are abandoned.
+	deliveredBeforeDrain := stats.deliveredCount()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	stopAbandoning := context.AfterFunc(shutdownCtx, func() {
+		logger.Warn("Shutdown grace period elapsed, abandoning remaining notifications")
+		close(abandonCh)
+	})
+	defer stopAbandoning()
+
+	logger.Info("Shutting down receivers")
+
+	wg := sync.WaitGroup{}
+	for i, receiver := range receivers {
+		receiver.GetLogger().Info("Shutting down receiver")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			receiver.Shutdown()
+			if !waitFor(shutdownCtx, receiverChs[i]) {
+				receiver.GetLogger().Warn("Receiver did not shut down within the grace period")
+				return
+			}
+			receiver.GetLogger().Info("Complete shut down receiver")
+		}()
+	}
+
+	wg.Wait()
+	logger.Info("All receivers are shut down")
+
+	// Receiver channels are closed on shutdown, so the forwarders end once the notifications
+	// already received are handed to the router, or when the grace period elapses.
+	forwarderWg.Wait()
+	// The replay hands notifications to components like the router does, so it must end
+	// before they are shut down. It ends at the latest when the grace period elapses.
+	<-replayDone
+	close(routerCh)
+	<-routerDone
+	logger.Info("Router is drained")
+
+	if escalationComponent != nil {
+		escalationComponent.GetLogger().Info("Shutting down escalation")
+		escalationComponent.Shutdown()
+		if waitFor(shutdownCtx, escalationCh) {
+			escalationComponent.GetLogger().Info("Complete shut down escalation")
+		} else {
+			escalationComponent.GetLogger().Warn("Escalation did not shut down within the grace period")
+		}
+	}
+
+	logger.Info("Shutting down senders")
+
+	wg = sync.WaitGroup{}
+	for i, sender := range senders {
+		sender.GetLogger().Info("Shutting down sender")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Groups, delayed notifications and buffers are delivered rather than abandoned.
+			if err := sender.Flush(shutdownCtx); err != nil {
+				sender.GetLogger().Warn("Flushing sender failed", "err", err)
+			}
+			sender.Shutdown()
+			if !waitFor(shutdownCtx, senderChs[i]) {
+				sender.GetLogger().Warn("Sender did not shut down within the grace period")
+				return
+			}
+			sender.GetLogger().Info("Complete shut down sender")
+
+			for _, n := range sender.Pending() {
+				stats.abandon(n, sender.GetId())
+			}
+		}()
+	}
+
+	wg.Wait()
+	logger.Info("All senders are shut down")
+
+	abandoned := stats.abandonedNotifications()
+	for _, q := range abandoned {
+		for _, senderId := range q.SenderIds {
+			historyStore.Abandoned(senderId, q.Notification)
+		}
+	}
+	logger.Info("Drain completed", "delivered", stats.deliveredCount()-deliveredBeforeDrain, "abandoned", len(abandoned))
+
+	if queueFile != "" && len(abandoned) > 0 {
+		if err := appendQueue(queueFile, abandoned); err != nil {
+			return fmt.Errorf("persist abandoned notifications: %w", err)
+		}
+		logger.Info("Abandoned notifications are persisted", "queue_file", queueFile)
+	}
+
+	return nil
+}
+
+// waitFor waits until ch is closed or ctx is done, and reports whether ch was closed.
+func waitFor(ctx context.Context, ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	case <-ctx.Done():
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// stopComponents shuts down components that were started before Run failed.
+func stopComponents(
+	receivers []*abstraction.AutonomousChannelComponent,
+	receiverChs []<-chan struct{},
+	senders []*abstraction.AutonomousChannelComponent,
+	senderChs []<-chan struct{},
+) {
+	for i, receiver := range receivers {
+		receiver.Shutdown()
+		<-receiverChs[i]
+	}
+	for i, sender := range senders {
+		sender.Shutdown()
+		<-senderChs[i]
+	}
+}
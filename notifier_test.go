@@ -0,0 +1,113 @@
+package notifier
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/abstraction"
+	"github.com/Kotaro7750/notifier/builder"
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/receiver"
+	"github.com/Kotaro7750/notifier/sender"
+	"gopkg.in/yaml.v3"
+)
+
+// onceReceiverImpl emits a single notification.
+type onceReceiverImpl struct {
+	logger *slog.Logger
+}
+
+func (ori *onceReceiverImpl) GetId() string                 { return "once" }
+func (ori *onceReceiverImpl) GetLogger() *slog.Logger       { return ori.logger }
+func (ori *onceReceiverImpl) SetLogger(logger *slog.Logger) { ori.logger = logger }
+
+func (ori *onceReceiverImpl) Start(outputCh chan<- notification.Notification, done <-chan struct{}) <-chan error {
+	retCh := make(chan error)
+	go func() {
+		defer close(retCh)
+		select {
+		case outputCh <- notification.Notification{Title: "from plugin"}:
+		case <-done:
+			return
+		}
+		<-done
+	}()
+	return retCh
+}
+
+// channelSenderImpl forwards every notification to received.
+type channelSenderImpl struct {
+	logger   *slog.Logger
+	received chan<- notification.Notification
+}
+
+func (csi *channelSenderImpl) GetId() string                 { return "channel" }
+func (csi *channelSenderImpl) GetLogger() *slog.Logger       { return csi.logger }
+func (csi *channelSenderImpl) SetLogger(logger *slog.Logger) { csi.logger = logger }
+
+func (csi *channelSenderImpl) Start(inputCh <-chan notification.Notification, done <-chan struct{}) <-chan error {
+	retCh := make(chan error)
+	go func() {
+		defer close(retCh)
+		for {
+			select {
+			case n := <-inputCh:
+				csi.received <- n
+			case <-done:
+				return
+			}
+		}
+	}()
+	return retCh
+}
+
+func TestRunRoutesThroughRegisteredKinds(t *testing.T) {
+	received := make(chan notification.Notification, 1)
+
+	builder.RegisterReceiver("test_once", func(id string, properties yaml.Node) (abstraction.AbstractChannelComponent, error) {
+		return receiver.NewReceiver(&onceReceiverImpl{}), nil
+	})
+	builder.RegisterSender("test_channel", func(id string, properties yaml.Node) (abstraction.AbstractChannelComponent, error) {
+		return sender.NewSender(&channelSenderImpl{received: received}), nil
+	})
+
+	cfg := config.Configuration{
+		ReceiverConfigurations: []config.ChannelComponentConfig{{Id: "once", Kind: "test_once"}},
+		SenderConfigurations:   []config.ChannelComponentConfig{{Id: "channel", Kind: "test_channel"}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- Run(ctx, cfg, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	}()
+
+	select {
+	case n := <-received:
+		if n.Title != "from plugin" {
+			t.Fatalf("Title = %q, want %q", n.Title, "from plugin")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification was not routed")
+	}
+
+	cancel()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestRunRejectsInvalidConfiguration(t *testing.T) {
+	if err := Run(context.Background(), config.Configuration{}); err == nil {
+		t.Fatal("Run unexpectedly succeeded")
+	}
+}
@@ -114,7 +114,7 @@ func (dri *dummyReceiverImpl) Start(outputCh chan<- notification.Notification, d
 				outputCh <- notification.Notification{
 					Title:              "Dummy Title",
 					Message:            fmt.Sprintf("Hello from %s", dri.id),
-					Severity:           slog.LevelInfo,
+					Severity:           notification.SeverityInfo,
 					NotificationSource: dri.notificationSource,
 					Labels:             dri.labels,
 				}
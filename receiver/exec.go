@@ -0,0 +1,111 @@
+package receiver
+
+import (
+	"log/slog"
+
+	"github.com/Kotaro7750/notifier/abstraction"
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/execplugin"
+	"github.com/Kotaro7750/notifier/notification"
+
+	"gopkg.in/yaml.v3"
+)
+
+func ExecReceiverBuilder(id string, properties yaml.Node) (abstraction.AbstractChannelComponent, error) {
+	parsedProperties := execplugin.NewProperties()
+	if err := config.DecodeProperties(properties, &parsedProperties); err != nil {
+		return nil, err
+	}
+	if err := parsedProperties.Validate(); err != nil {
+		return nil, err
+	}
+
+	return NewReceiver(&execReceiverImpl{
+		id:         id,
+		logger:     nil,
+		properties: parsedProperties,
+	}), nil
+}
+
+// execReceiverImpl receives the notifications written by an exec plugin. Each execution spawns
+// a new plugin process.
+type execReceiverImpl struct {
+	id         string
+	logger     *slog.Logger
+	properties execplugin.Properties
+}
+
+func (eri *execReceiverImpl) GetId() string {
+	return eri.id
+}
+
+func (eri *execReceiverImpl) GetLogger() *slog.Logger {
+	return eri.logger
+}
+
+func (eri *execReceiverImpl) SetLogger(logger *slog.Logger) {
+	eri.logger = logger
+}
+
+func (eri *execReceiverImpl) Start(outputCh chan<- notification.Notification, done <-chan struct{}) <-chan error {
+	retCh := make(chan error, 1)
+
+	process, err := execplugin.Start(eri.properties, eri.GetLogger())
+	if err != nil {
+		retCh <- err
+		close(retCh)
+		return retCh
+	}
+
+	go func() {
+		defer close(retCh)
+
+		for {
+			select {
+			case m, ok := <-process.Messages():
+				if !ok {
+					if err := process.Wait(); err != nil {
+						retCh <- err
+					}
+					return
+				}
+
+				switch m.Type {
+				case execplugin.MessageNotification:
+					if m.Notification == nil {
+						eri.GetLogger().Warn("Notification message without notification from plugin", "id", m.Id)
+						eri.answer(process, execplugin.Message{Type: execplugin.MessageError, Id: m.Id, Error: "notification is required"})
+						continue
+					}
+					select {
+					case outputCh <- *m.Notification:
+					case <-done:
+						process.Stop()
+						return
+					}
+					eri.answer(process, execplugin.Message{Type: execplugin.MessageAck, Id: m.Id})
+				case execplugin.MessageError:
+					eri.GetLogger().Error("Plugin reported error", "err", m.Error)
+				default:
+					eri.GetLogger().Warn("Unexpected message from plugin", "type", m.Type, "id", m.Id)
+				}
+
+			case <-done:
+				process.Stop()
+				return
+			}
+		}
+	}()
+
+	return retCh
+}
+
+// answer replies to messages that carry an id.
+func (eri *execReceiverImpl) answer(process *execplugin.Process, m execplugin.Message) {
+	if m.Id == "" {
+		return
+	}
+	if _, err := process.Send(m); err != nil {
+		eri.GetLogger().Warn("Answering plugin failed", "type", m.Type, "id", m.Id, "err", err)
+	}
+}
@@ -10,7 +10,10 @@ import (
 	"github.com/Kotaro7750/notifier/abstraction"
 	"github.com/Kotaro7750/notifier/config"
 	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/tracing"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/yaml.v3"
 )
 
@@ -69,12 +72,26 @@ func (hri HTTPReceiverImpl) Start(outputCh chan<- notification.Notification, don
 	serveMux.HandleFunc("POST /notifications", func(w http.ResponseWriter, r *http.Request) {
 		var notification notification.Notification
 		err := json.NewDecoder(r.Body).Decode(&notification)
+
+		// The trace context of the request headers takes precedence over the one in the body.
+		ctx := tracing.ExtractHTTP(r)
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			ctx = tracing.Extract(ctx, notification)
+		}
+		_, span := tracing.StartWithContext(ctx, "notifier.receive",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attribute.String("notifier.receiver.id", hri.id)),
+		)
 		if err != nil {
+			tracing.End(span, err)
 			http.Error(w, "Error decoding JSON", http.StatusBadRequest)
 			return
 		}
+		span.SetAttributes(attribute.String("notifier.notification.title", notification.Title))
+		tracing.Inject(trace.ContextWithSpan(ctx, span), &notification)
 
 		outputCh <- notification
+		span.End()
 	})
 
 	s := &http.Server{
@@ -1,8 +1,15 @@
 package receiver
 
 import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Kotaro7750/notifier/notification"
 	"github.com/Kotaro7750/notifier/test_util"
 )
 
@@ -31,3 +38,48 @@ defaultSubscriber: ignored@example.com
 		t.Fatal("HTTPReceiverBuilder unexpectedly succeeded")
 	}
 }
+
+func TestHTTPReceiverPropagatesTraceContextFromHeaders(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	impl := &HTTPReceiverImpl{id: "receiver-1", listenAddr: address, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	outputCh := make(chan notification.Notification, 1)
+	done := make(chan struct{})
+	errCh := impl.Start(outputCh, done)
+	defer func() {
+		close(done)
+		<-errCh
+	}()
+
+	const traceId = "0af7651916cd43dd8448eb211c80319c"
+	var res *http.Response
+	for i := 0; i < 50; i++ {
+		req, err := http.NewRequest(http.MethodPost, "http://"+address+"/notifications", strings.NewReader(`{"title":"disk full"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("traceparent", "00-"+traceId+"-b7ad6b7169203331-01")
+		if res, err = http.DefaultClient.Do(req); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if res == nil {
+		t.Fatal("HTTP receiver did not start")
+	}
+	res.Body.Close()
+
+	select {
+	case n := <-outputCh:
+		if !strings.Contains(n.TraceContext["traceparent"], traceId) {
+			t.Fatalf("TraceContext = %v, want trace %s", n.TraceContext, traceId)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification was not received")
+	}
+}
@@ -0,0 +1,287 @@
+package receiver
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Kotaro7750/notifier/abstraction"
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// InternalNotificationSource is the NotificationSource of notifications about the notifier
+	// itself.
+	InternalNotificationSource = "notifier.internal"
+	// ComponentTypeLabel and ComponentIdLabel identify the component an internal notification
+	// is about.
+	ComponentTypeLabel = "component_type"
+	ComponentIdLabel   = "component_id"
+	// ComponentEventLabel is one of the internal event names below.
+	ComponentEventLabel = "component_event"
+
+	internalEventFailed           = "failed"
+	internalEventRestartThreshold = "restart_threshold_exceeded"
+	internalEventGaveUp           = "gave_up"
+	internalEventRecovered        = "recovered"
+
+	internalEventQueueSize = 64
+)
+
+type InternalReceiverProperties struct {
+	// RestartThreshold is the number of failures within RestartWindow after which a component
+	// is reported as restarting too often.
+	RestartThreshold int           `yaml:"restartThreshold"`
+	RestartWindow    time.Duration `yaml:"restartWindow"`
+	// RecoveredAfter is how long a restarted component must run without failing to be reported
+	// as recovered.
+	RecoveredAfter time.Duration `yaml:"recoveredAfter"`
+}
+
+func NewInternalReceiverProperties() InternalReceiverProperties {
+	return InternalReceiverProperties{
+		RestartThreshold: 3,
+		RestartWindow:    10 * time.Minute,
+		RecoveredAfter:   1 * time.Minute,
+	}
+}
+
+func (p InternalReceiverProperties) Validate() error {
+	if p.RestartThreshold <= 0 {
+		return fmt.Errorf("restartThreshold should be greater than 0")
+	}
+	if p.RestartWindow <= 0 {
+		return fmt.Errorf("restartWindow should be greater than 0")
+	}
+	if p.RecoveredAfter <= 0 {
+		return fmt.Errorf("recoveredAfter should be greater than 0")
+	}
+
+	return nil
+}
+
+func InternalReceiverBuilder(id string, properties yaml.Node) (abstraction.AbstractChannelComponent, error) {
+	parsedProperties := NewInternalReceiverProperties()
+	if err := config.DecodeProperties(properties, &parsedProperties); err != nil {
+		return nil, err
+	}
+	if err := parsedProperties.Validate(); err != nil {
+		return nil, err
+	}
+
+	return NewReceiver(&internalReceiverImpl{
+		id:               id,
+		logger:           nil,
+		restartThreshold: parsedProperties.RestartThreshold,
+		restartWindow:    parsedProperties.RestartWindow,
+		recoveredAfter:   parsedProperties.RecoveredAfter,
+		events:           make(chan componentEvent, internalEventQueueSize),
+		health:           make(map[componentKey]*componentHealth),
+	}), nil
+}
+
+type componentKey struct {
+	componentType string
+	id            string
+}
+
+type componentEvent struct {
+	componentType string
+	event         abstraction.Event
+}
+
+// componentHealth is the failure history of one component.
+type componentHealth struct {
+	failures []time.Time
+	// thresholdReported suppresses failure notifications until the component recovers.
+	thresholdReported bool
+	// recoveredAt is when the running execution will be reported as recovered. It is zero unless
+	// the component has been restarted after a failure.
+	recoveredAt time.Time
+}
+
+// internalReceiverImpl turns lifecycle events of the other components into notifications with
+// InternalNotificationSource, so that they can be routed with ordinary match rules.
+type internalReceiverImpl struct {
+	id               string
+	logger           *slog.Logger
+	restartThreshold int
+	restartWindow    time.Duration
+	recoveredAfter   time.Duration
+	// events buffers events while the receiver is not running, since the supervisor must not
+	// block on the handler.
+	events chan componentEvent
+	// health is only accessed by the running execution.
+	health map[componentKey]*componentHealth
+}
+
+func (iri *internalReceiverImpl) GetId() string {
+	return iri.id
+}
+
+func (iri *internalReceiverImpl) GetLogger() *slog.Logger {
+	return iri.logger
+}
+
+func (iri *internalReceiverImpl) SetLogger(logger *slog.Logger) {
+	iri.logger = logger
+}
+
+func (iri *internalReceiverImpl) HandleEvent(componentType string, event abstraction.Event) {
+	select {
+	case iri.events <- componentEvent{componentType: componentType, event: event}:
+	default:
+		iri.GetLogger().Warn("Component event dropped because the queue is full", "component_type", componentType, "component_id", event.ComponentId, "event", event.Type)
+	}
+}
+
+func (iri *internalReceiverImpl) Start(outputCh chan<- notification.Notification, done <-chan struct{}) <-chan error {
+	retCh := make(chan error)
+
+	go func() {
+		defer close(retCh)
+
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		for {
+			var timerCh <-chan time.Time
+			if next, ok := iri.nextRecovery(); ok {
+				timer.Reset(time.Until(next))
+				timerCh = timer.C
+			} else {
+				timer.Stop()
+			}
+
+			var notifications []notification.Notification
+			select {
+			case e := <-iri.events:
+				notifications = iri.handle(e, time.Now())
+			case <-timerCh:
+				notifications = iri.recover(time.Now())
+			case <-done:
+				return
+			}
+
+			for _, n := range notifications {
+				select {
+				case outputCh <- n:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	return retCh
+}
+
+// handle updates the component health with e and returns the notifications to emit.
+func (iri *internalReceiverImpl) handle(e componentEvent, now time.Time) []notification.Notification {
+	key := componentKey{componentType: e.componentType, id: e.event.ComponentId}
+	health, ok := iri.health[key]
+	if !ok {
+		health = &componentHealth{}
+		iri.health[key] = health
+	}
+
+	switch e.event.Type {
+	case abstraction.EventFailed:
+		health.recoveredAt = time.Time{}
+
+		recent := health.failures[:0]
+		for _, failedAt := range health.failures {
+			if now.Sub(failedAt) < iri.restartWindow {
+				recent = append(recent, failedAt)
+			}
+		}
+		health.failures = append(recent, now)
+
+		// Once the threshold is reported, further failures are not reported until the component
+		// recovers. This bounds the notifications when failing senders report each other.
+		if health.thresholdReported {
+			return nil
+		}
+
+		notifications := []notification.Notification{
+			iri.notification(key, internalEventFailed, notification.SeverityError,
+				fmt.Sprintf("%s %s failed", key.componentType, key.id), errorMessage(e.event.Err)),
+		}
+		if len(health.failures) >= iri.restartThreshold {
+			health.thresholdReported = true
+			notifications = append(notifications, iri.notification(key, internalEventRestartThreshold, notification.SeverityCritical,
+				fmt.Sprintf("%s %s is restarting repeatedly", key.componentType, key.id),
+				fmt.Sprintf("%d failures within %s", len(health.failures), iri.restartWindow)))
+		}
+		return notifications
+
+	case abstraction.EventGaveUp:
+		health.recoveredAt = time.Time{}
+		return []notification.Notification{
+			iri.notification(key, internalEventGaveUp, notification.SeverityCritical,
+				fmt.Sprintf("%s %s stopped", key.componentType, key.id), "Restarting was given up. "+errorMessage(e.event.Err)),
+		}
+
+	case abstraction.EventRestarted:
+		if len(health.failures) > 0 {
+			health.recoveredAt = now.Add(iri.recoveredAfter)
+		}
+	}
+
+	return nil
+}
+
+// recover reports the components that have been running without failure since their restart.
+func (iri *internalReceiverImpl) recover(now time.Time) []notification.Notification {
+	notifications := make([]notification.Notification, 0)
+	for key, health := range iri.health {
+		if health.recoveredAt.IsZero() || health.recoveredAt.After(now) {
+			continue
+		}
+
+		notifications = append(notifications, iri.notification(key, internalEventRecovered, notification.SeverityInfo,
+			fmt.Sprintf("%s %s recovered", key.componentType, key.id),
+			fmt.Sprintf("Running without failure for %s", iri.recoveredAfter)))
+		delete(iri.health, key)
+	}
+	return notifications
+}
+
+func (iri *internalReceiverImpl) nextRecovery() (time.Time, bool) {
+	var next time.Time
+	found := false
+	for _, health := range iri.health {
+		if health.recoveredAt.IsZero() {
+			continue
+		}
+		if !found || health.recoveredAt.Before(next) {
+			next = health.recoveredAt
+			found = true
+		}
+	}
+	return next, found
+}
+
+func (iri *internalReceiverImpl) notification(key componentKey, event string, severity notification.Severity, title string, message string) notification.Notification {
+	return notification.Notification{
+		Title:              title,
+		Severity:           severity,
+		Message:            message,
+		NotificationSource: InternalNotificationSource,
+		Labels: map[string]string{
+			ComponentTypeLabel:  key.componentType,
+			ComponentIdLabel:    key.id,
+			ComponentEventLabel: event,
+		},
+	}
+}
+
+func errorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	return fmt.Sprintf("err: %s", err)
+}
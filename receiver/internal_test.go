@@ -0,0 +1,110 @@
+package receiver
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/abstraction"
+	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/test_util"
+)
+
+func newTestInternalReceiver(t *testing.T) *internalReceiverImpl {
+	t.Helper()
+
+	component, err := InternalReceiverBuilder("internal", test_util.MustPropertiesNode(t, `
+restartThreshold: 2
+restartWindow: 1m
+recoveredAfter: 10s
+`))
+	if err != nil {
+		t.Fatalf("InternalReceiverBuilder returned error: %v", err)
+	}
+	component.SetLogger(slog.Default())
+
+	return component.(*Receiver).impl.(*internalReceiverImpl)
+}
+
+func failedEvent(id string) componentEvent {
+	return componentEvent{
+		componentType: "sender",
+		event:         abstraction.Event{Type: abstraction.EventFailed, ComponentId: id, Err: errors.New("failed")},
+	}
+}
+
+func componentEvents(notifications []notification.Notification) []string {
+	events := make([]string, 0, len(notifications))
+	for _, n := range notifications {
+		events = append(events, n.Labels[ComponentEventLabel])
+	}
+	return events
+}
+
+func TestInternalReceiverReportsFailuresUntilThreshold(t *testing.T) {
+	iri := newTestInternalReceiver(t)
+	now := time.Now()
+
+	first := iri.handle(failedEvent("push"), now)
+	if len(first) != 1 {
+		t.Fatalf("first failure notifications = %v, want [failed]", componentEvents(first))
+	}
+	n := first[0]
+	if n.NotificationSource != InternalNotificationSource {
+		t.Fatalf("NotificationSource = %q, want %q", n.NotificationSource, InternalNotificationSource)
+	}
+	if n.Labels[ComponentTypeLabel] != "sender" || n.Labels[ComponentIdLabel] != "push" {
+		t.Fatalf("Labels = %v, want sender push", n.Labels)
+	}
+	if n.Severity != notification.SeverityError {
+		t.Fatalf("Severity = %s, want %s", n.Severity, notification.SeverityError)
+	}
+
+	second := iri.handle(failedEvent("push"), now.Add(time.Second))
+	if got := componentEvents(second); len(got) != 2 || got[1] != internalEventRestartThreshold {
+		t.Fatalf("second failure notifications = %v, want [failed restart_threshold_exceeded]", got)
+	}
+
+	if third := iri.handle(failedEvent("push"), now.Add(2*time.Second)); len(third) != 0 {
+		t.Fatalf("failure after threshold notifications = %v, want none", componentEvents(third))
+	}
+}
+
+func TestInternalReceiverForgetsFailuresOutsideWindow(t *testing.T) {
+	iri := newTestInternalReceiver(t)
+	now := time.Now()
+
+	iri.handle(failedEvent("push"), now)
+	if got := iri.handle(failedEvent("push"), now.Add(time.Minute)); len(got) != 1 {
+		t.Fatalf("notifications = %v, want [failed]", componentEvents(got))
+	}
+}
+
+func TestInternalReceiverReportsRecovery(t *testing.T) {
+	iri := newTestInternalReceiver(t)
+	now := time.Now()
+
+	iri.handle(failedEvent("push"), now)
+	iri.handle(componentEvent{
+		componentType: "sender",
+		event:         abstraction.Event{Type: abstraction.EventRestarted, ComponentId: "push"},
+	}, now.Add(time.Second))
+
+	if got := iri.recover(now.Add(5 * time.Second)); len(got) != 0 {
+		t.Fatalf("notifications before recoveredAfter = %v, want none", componentEvents(got))
+	}
+
+	next, ok := iri.nextRecovery()
+	if !ok || !next.Equal(now.Add(11*time.Second)) {
+		t.Fatalf("nextRecovery() = %v, %v, want %v", next, ok, now.Add(11*time.Second))
+	}
+
+	got := iri.recover(next)
+	if events := componentEvents(got); len(events) != 1 || events[0] != internalEventRecovered {
+		t.Fatalf("notifications = %v, want [recovered]", events)
+	}
+	if _, ok := iri.nextRecovery(); ok {
+		t.Fatal("recovery is still pending after it was reported")
+	}
+}
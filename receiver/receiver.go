@@ -3,6 +3,7 @@ package receiver
 import (
 	"log/slog"
 
+	"github.com/Kotaro7750/notifier/abstraction"
 	"github.com/Kotaro7750/notifier/notification"
 )
 
@@ -21,6 +22,18 @@ type ReceiverImpl interface {
 	Start(outputCh chan<- notification.Notification, done <-chan struct{}) <-chan error
 }
 
+// ComponentEventHandler is implemented by ReceiverImpls that turn lifecycle events of the
+// supervised components into notifications. HandleEvent must not block.
+type ComponentEventHandler interface {
+	HandleEvent(componentType string, event abstraction.Event)
+}
+
+// ComponentEventHandler returns the wrapped impl when it handles component events.
+func (r *Receiver) ComponentEventHandler() (ComponentEventHandler, bool) {
+	handler, ok := r.impl.(ComponentEventHandler)
+	return handler, ok
+}
+
 func (r *Receiver) Start(outputCh chan notification.Notification, done <-chan struct{}) <-chan error {
 	return r.impl.Start(outputCh, done)
 }
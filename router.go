@@ -1,28 +1,168 @@
-package main
+package notifier
 
 import (
+	"errors"
+	"fmt"
+	"slices"
 	"sync"
 
 	"github.com/Kotaro7750/notifier/abstraction"
+	"github.com/Kotaro7750/notifier/escalation"
+	"github.com/Kotaro7750/notifier/history"
 	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/receiver"
+	"github.com/Kotaro7750/notifier/sender"
+	"github.com/Kotaro7750/notifier/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Router struct {
 	senders []*abstraction.AutonomousChannelComponent
+	// failoverMembers are the ids of the senders that receive notifications only through
+	// failover senders and escalation steps, so that they do not deliver them twice.
+	failoverMembers map[string]bool
+	// escalation routes notifications matching an escalation policy to escalationComponent
+	// instead of every sender. It is nil when no escalation is configured.
+	escalation          *escalation.Manager
+	escalationComponent *abstraction.AutonomousChannelComponent
+	// abandonCh is closed when the shutdown grace period elapses. Notifications that have not
+	// been handed to a component by then are abandoned and recorded in stats.
+	abandonCh <-chan struct{}
+	stats     *drainStats
+	// history records the routing of every notification. It is nil when no history is
+	// configured, which records nothing.
+	history *history.Store
 }
 
+// Route routes a notification from a receiver. It always assigns a new id, so that an id chosen
+// by a client cannot refer to the history of another notification.
 func (r Router) Route(n notification.Notification) {
-	var wg sync.WaitGroup
+	n.Id = notification.NewId()
+	r.route(n)
+}
+
+// route routes n with the id it has, such as a notification abandoned by the previous run.
+func (r Router) route(n notification.Notification) {
+	n = withoutReservedLabels(n)
+
+	ctx, span := tracing.Start(n, "notifier.route")
+	defer span.End()
+	tracing.Inject(ctx, &n)
+
+	escalated := r.escalation != nil && r.escalation.Matches(n)
+	r.history.Received(n, escalated)
+
+	if escalated {
+		span.SetAttributes(attribute.Bool("notifier.escalated", true))
+		select {
+		case r.escalationComponent.GetChannel() <- n:
+		case <-r.abandonCh:
+			r.stats.abandon(n)
+		}
+		return
+	}
 
+	senders := make([]*abstraction.AutonomousChannelComponent, 0, len(r.senders))
 	for _, sender := range r.senders {
+		if !r.failoverMembers[sender.GetId()] {
+			senders = append(senders, sender)
+		}
+	}
+	r.broadcast(n, senders)
+}
+
+// deliverTo sends n to the senders with the given ids only. It returns an error when some of
+// them are unknown, or when n was abandoned for some of them at shutdown.
+func (r Router) deliverTo(n notification.Notification, senderIds []string) error {
+	senders := make([]*abstraction.AutonomousChannelComponent, 0, len(senderIds))
+	for _, sender := range r.senders {
+		if slices.Contains(senderIds, sender.GetId()) {
+			senders = append(senders, sender)
+		}
+	}
+
+	var errs []error
+	for _, senderId := range senderIds {
+		if !slices.ContainsFunc(senders, func(s *abstraction.AutonomousChannelComponent) bool { return s.GetId() == senderId }) {
+			errs = append(errs, fmt.Errorf("sender %s is unknown", senderId))
+		}
+	}
+	for _, senderId := range r.broadcast(n, senders) {
+		errs = append(errs, fmt.Errorf("notification was abandoned for sender %s at shutdown", senderId))
+	}
+	return errors.Join(errs...)
+}
+
+// broadcast hands n to senders, and returns the ids of the senders it was abandoned for.
+func (r Router) broadcast(n notification.Notification, senders []*abstraction.AutonomousChannelComponent) []string {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	abandoned := make([]string, 0)
+
+	for _, sender := range senders {
+		if isAboutSender(n, sender.GetId()) {
+			continue
+		}
+
+		// Recorded before the hand-over so that decisions of the sender come after.
+		r.history.Routed(sender.GetId(), n)
+
 		wg.Add(1)
 		go func() {
-			c := sender.GetChannel()
+			defer wg.Done()
 
-			c <- n
-			wg.Done()
+			select {
+			case sender.GetChannel() <- n:
+			case <-r.abandonCh:
+				r.stats.abandon(n, sender.GetId())
+				lock.Lock()
+				abandoned = append(abandoned, sender.GetId())
+				lock.Unlock()
+			}
 		}()
 	}
 
 	wg.Wait()
+	return abandoned
+}
+
+// reservedLabels are the labels that components set on the notifications they deliver to
+// senders. Only such components may set them, so the router removes them from received
+// notifications.
+var reservedLabels = []string{
+	sender.RouteLabel,
+	sender.RepeatedLabel,
+	escalation.IdLabel,
+	escalation.StepLabel,
+	escalation.AckURLLabel,
+}
+
+// withoutReservedLabels removes reservedLabels from n.
+func withoutReservedLabels(n notification.Notification) notification.Notification {
+	reserved := slices.ContainsFunc(reservedLabels, func(key string) bool {
+		_, ok := n.Labels[key]
+		return ok
+	})
+	if !reserved {
+		return n
+	}
+
+	labels := make(map[string]string, len(n.Labels))
+	for key, value := range n.Labels {
+		if !slices.Contains(reservedLabels, key) {
+			labels[key] = value
+		}
+	}
+	n.Labels = labels
+	return n
+}
+
+// isAboutSender reports whether n is an internal notification about the sender with the given id.
+// Such notifications are not routed to that sender, so that a failing sender does not keep
+// notifying itself of its own failures.
+func isAboutSender(n notification.Notification, senderId string) bool {
+	return n.NotificationSource == receiver.InternalNotificationSource &&
+		n.Labels[receiver.ComponentTypeLabel] == "sender" &&
+		n.Labels[receiver.ComponentIdLabel] == senderId
 }
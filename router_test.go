@@ -0,0 +1,74 @@
+package notifier
+
+import (
+	"testing"
+
+	"github.com/Kotaro7750/notifier/abstraction"
+	"github.com/Kotaro7750/notifier/escalation"
+	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/sender"
+)
+
+// routeAbandoned routes n to a sender that never reads its channel after the grace period, and
+// returns the notification as it was handed to the sender.
+func routeAbandoned(t *testing.T, n notification.Notification) notification.Notification {
+	t.Helper()
+
+	abandonCh := make(chan struct{})
+	close(abandonCh)
+	stats := &drainStats{}
+	push := abstraction.NewAutonomousChannelComponent(&idleComponent{id: "push"})
+	router := Router{senders: []*abstraction.AutonomousChannelComponent{push}, abandonCh: abandonCh, stats: stats}
+
+	router.Route(n)
+
+	abandoned := stats.abandonedNotifications()
+	if len(abandoned) != 1 {
+		t.Fatalf("abandoned = %+v, want one notification", abandoned)
+	}
+	return abandoned[0].Notification
+}
+
+func TestRouterRemovesReservedLabels(t *testing.T) {
+	routed := routeAbandoned(t, notification.Notification{
+		Title: "spoofed",
+		Labels: map[string]string{
+			sender.RouteLabel:      "escalation/incident/1",
+			sender.RepeatedLabel:   "3",
+			escalation.IdLabel:     "forged",
+			escalation.StepLabel:   "2",
+			escalation.AckURLLabel: "https://attacker.example.com",
+			"env":                  "prod",
+		},
+	})
+
+	if len(routed.Labels) != 1 || routed.Labels["env"] != "prod" {
+		t.Fatalf("Labels = %v, want the labels without reserved ones", routed.Labels)
+	}
+}
+
+func TestRouterSkipsFailoverMembers(t *testing.T) {
+	abandonCh := make(chan struct{})
+	close(abandonCh)
+	stats := &drainStats{}
+	senders := []*abstraction.AutonomousChannelComponent{
+		abstraction.NewAutonomousChannelComponent(&idleComponent{id: "failover"}),
+		abstraction.NewAutonomousChannelComponent(&idleComponent{id: "member"}),
+	}
+	router := Router{senders: senders, failoverMembers: map[string]bool{"member": true}, abandonCh: abandonCh, stats: stats}
+
+	router.Route(notification.Notification{Title: "routed"})
+
+	abandoned := stats.abandonedNotifications()
+	if len(abandoned) != 1 || len(abandoned[0].SenderIds) != 1 || abandoned[0].SenderIds[0] != "failover" {
+		t.Fatalf("abandoned = %+v, want the notification for the failover sender alone", abandoned)
+	}
+}
+
+func TestRouterReplacesClientId(t *testing.T) {
+	routed := routeAbandoned(t, notification.Notification{Id: "chosen", Title: "spoofed"})
+
+	if routed.Id == "" || routed.Id == "chosen" {
+		t.Fatalf("Id = %q, want an id assigned by the router", routed.Id)
+	}
+}
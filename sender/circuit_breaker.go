@@ -0,0 +1,387 @@
+package sender
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Kotaro7750/notifier/abstraction"
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreakerStage stops forwarding notifications to a sender that keeps failing, and
+// holds them back until probes show that the sender has recovered.
+type circuitBreakerStage struct {
+	failureThreshold int
+	openDuration     time.Duration
+	halfOpenProbes   int
+	bufferSize       int
+	deadLetterFile   string
+	logger           func() *slog.Logger
+	// assumeDelivered treats every notification handed to the sender as delivered, for senders
+	// that do not report their deliveries and only fail by stopping.
+	assumeDelivered bool
+	// wakeCh tells the running stage that the state changed outside of it.
+	wakeCh chan struct{}
+	decide DecisionHandler
+	// flushCh receives flush requests. An open breaker is probed at once, and the buffer is
+	// kept if the probe fails.
+	flushCh chan chan struct{}
+
+	lock       sync.Mutex
+	state      breakerState
+	failures   int
+	openedAt   time.Time
+	probesSent int
+	probesDone int
+	buffer     []notification.Notification
+	// inFlight are the notifications handed to the sender whose outcome is not reported yet.
+	// They are buffered again when their delivery fails.
+	inFlight       []notification.Notification
+	shortCircuited int
+	deadLettered   int
+	dropped        int
+}
+
+func newCircuitBreakerStage(circuitBreaker config.CircuitBreakerConfig, logger func() *slog.Logger) *circuitBreakerStage {
+	halfOpenProbes := circuitBreaker.HalfOpenProbes
+	if halfOpenProbes == 0 {
+		halfOpenProbes = 1
+	}
+
+	return &circuitBreakerStage{
+		failureThreshold: circuitBreaker.FailureThreshold,
+		openDuration:     circuitBreaker.OpenDuration,
+		halfOpenProbes:   halfOpenProbes,
+		bufferSize:       circuitBreaker.BufferSize,
+		deadLetterFile:   circuitBreaker.DeadLetterFile,
+		logger:           logger,
+		wakeCh:           make(chan struct{}, 1),
+		flushCh:          make(chan chan struct{}),
+		state:            breakerClosed,
+	}
+}
+
+func (cb *circuitBreakerStage) run(in <-chan notification.Notification, out chan<- notification.Notification, stop <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	var flushed chan struct{}
+	for {
+		var timerCh <-chan time.Time
+		var inCh <-chan notification.Notification
+		var outCh chan<- notification.Notification
+		var pending notification.Notification
+
+		cb.lock.Lock()
+		// The buffer cannot be flushed any further while the breaker is open.
+		if flushed != nil && (len(cb.buffer) == 0 || cb.state == breakerOpen) {
+			close(flushed)
+			flushed = nil
+		}
+		if cb.state == breakerOpen {
+			timer.Reset(time.Until(cb.openedAt.Add(cb.openDuration)))
+			timerCh = timer.C
+		} else {
+			timer.Stop()
+		}
+		// Keep backpressure while closed; otherwise accept input to short-circuit it.
+		if cb.state != breakerClosed || len(cb.buffer) == 0 {
+			inCh = in
+		}
+		if len(cb.buffer) > 0 && cb.canSend() {
+			// pending is taken out before it is handed over, since the sender may report its
+			// outcome before this stage runs again.
+			pending = cb.take()
+			outCh = out
+		}
+		cb.lock.Unlock()
+
+		select {
+		case <-stop:
+			return
+		case n, ok := <-inCh:
+			if !ok {
+				in = nil
+				continue
+			}
+			cb.accept(n)
+		case outCh <- pending:
+			cb.sent()
+			continue
+		case <-timerCh:
+			cb.halfOpen(time.Now())
+		case flushed = <-cb.flushCh:
+			cb.halfOpen(time.Now().Add(cb.openDuration))
+		case <-cb.wakeCh:
+		}
+
+		if outCh != nil {
+			cb.putBack(pending)
+		}
+	}
+}
+
+// record updates the breaker with the outcome of one delivery.
+func (cb *circuitBreakerStage) record(err error) {
+	cb.recordAt(err, time.Now())
+}
+
+// recordDelivery updates the breaker with the outcome of delivering n, and buffers n again
+// in front of the others when it failed, so that it is sent again before them. It reports
+// whether the breaker took n back, in which case its decision about n is already reported.
+func (cb *circuitBreakerStage) recordDelivery(n notification.Notification, err error) bool {
+	return cb.recordDeliveryAt(n, err, time.Now())
+}
+
+func (cb *circuitBreakerStage) recordDeliveryAt(n notification.Notification, err error, now time.Time) bool {
+	cb.lock.Lock()
+	takenBack := false
+	i := slices.IndexFunc(cb.inFlight, func(inFlight notification.Notification) bool { return inFlight.Id == n.Id })
+	if i >= 0 {
+		cb.inFlight = slices.Delete(cb.inFlight, i, i+1)
+		if err != nil {
+			cb.requeue(n)
+			takenBack = true
+		}
+	}
+	cb.lock.Unlock()
+
+	cb.recordAt(err, now)
+	return takenBack
+}
+
+// requeue buffers n, whose delivery failed, in front of the buffer, or dead-letters it when
+// the buffer is full. It must be called with cb.lock held.
+func (cb *circuitBreakerStage) requeue(n notification.Notification) {
+	if len(cb.buffer) < cb.bufferSize {
+		cb.buffer = slices.Insert(cb.buffer, 0, n)
+		cb.decide.report(n, DecisionRequeued)
+		return
+	}
+	cb.overflow(n)
+}
+
+func (cb *circuitBreakerStage) recordAt(err error, now time.Time) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	switch cb.state {
+	case breakerClosed:
+		if err == nil {
+			cb.failures = 0
+			return
+		}
+		cb.failures++
+		if cb.failures >= cb.failureThreshold {
+			cb.open(now)
+		}
+	case breakerHalfOpen:
+		if err != nil {
+			cb.open(now)
+			break
+		}
+		cb.probesDone++
+		if cb.probesDone >= cb.halfOpenProbes {
+			cb.logger().Info("Circuit breaker closed")
+			cb.state = breakerClosed
+			cb.failures = 0
+		}
+	}
+
+	select {
+	case cb.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// open must be called with cb.lock held.
+func (cb *circuitBreakerStage) open(now time.Time) {
+	cb.logger().Warn("Circuit breaker opened", "open_duration", cb.openDuration)
+	cb.state = breakerOpen
+	cb.openedAt = now
+}
+
+func (cb *circuitBreakerStage) halfOpen(now time.Time) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.state != breakerOpen || now.Before(cb.openedAt.Add(cb.openDuration)) {
+		return
+	}
+	cb.logger().Info("Circuit breaker half-open", "probes", cb.halfOpenProbes)
+	cb.state = breakerHalfOpen
+	cb.probesSent = 0
+	cb.probesDone = 0
+}
+
+// shortCircuits reports whether the breaker holds new notifications back instead of
+// forwarding them.
+func (cb *circuitBreakerStage) shortCircuits() bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	return !cb.canSend()
+}
+
+// canSend must be called with cb.lock held.
+func (cb *circuitBreakerStage) canSend() bool {
+	switch cb.state {
+	case breakerClosed:
+		return true
+	case breakerHalfOpen:
+		return cb.probesSent < cb.halfOpenProbes
+	default:
+		return false
+	}
+}
+
+func (cb *circuitBreakerStage) accept(n notification.Notification) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.canSend() && len(cb.buffer) == 0 {
+		cb.buffer = append(cb.buffer, n)
+		return
+	}
+
+	cb.shortCircuited++
+	if len(cb.buffer) < cb.bufferSize {
+		cb.buffer = append(cb.buffer, n)
+		cb.decide.report(n, DecisionShortCircuited)
+		return
+	}
+	cb.overflow(n)
+}
+
+// overflow dead-letters n, which does not fit in the buffer, or drops it without a dead letter
+// file. It must be called with cb.lock held.
+func (cb *circuitBreakerStage) overflow(n notification.Notification) {
+	if cb.deadLetterFile == "" {
+		cb.dropped++
+		cb.logger().Warn("Circuit breaker dropped notification", "title", n.Title)
+		cb.decide.report(n, DecisionDropped)
+		return
+	}
+
+	if err := cb.writeDeadLetter(n); err != nil {
+		cb.dropped++
+		cb.logger().Error("Writing dead letter failed, notification dropped", "title", n.Title, "err", err)
+		cb.decide.report(n, DecisionDropped)
+		return
+	}
+	cb.deadLettered++
+	cb.decide.report(n, DecisionDeadLettered)
+}
+
+// take removes the first buffered notification to hand it to the sender, and tracks it until
+// its outcome is reported. It must be called with cb.lock held.
+func (cb *circuitBreakerStage) take() notification.Notification {
+	n := cb.buffer[0]
+	cb.buffer = cb.buffer[1:]
+	if !cb.assumeDelivered {
+		cb.inFlight = append(cb.inFlight, n)
+	}
+	return n
+}
+
+// putBack undoes take for n, which was not handed to the sender.
+func (cb *circuitBreakerStage) putBack(n notification.Notification) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if !cb.assumeDelivered {
+		cb.inFlight = cb.inFlight[:len(cb.inFlight)-1]
+	}
+	cb.buffer = slices.Insert(cb.buffer, 0, n)
+}
+
+func (cb *circuitBreakerStage) sent() {
+	cb.lock.Lock()
+	if cb.state == breakerHalfOpen {
+		cb.probesSent++
+	}
+	cb.lock.Unlock()
+
+	if cb.assumeDelivered {
+		cb.record(nil)
+	}
+}
+
+func (cb *circuitBreakerStage) writeDeadLetter(n notification.Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(cb.deadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(body, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (cb *circuitBreakerStage) status() map[string]any {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	return map[string]any{
+		"state":          cb.state.String(),
+		"failures":       cb.failures,
+		"buffered":       len(cb.buffer),
+		"shortCircuited": cb.shortCircuited,
+		"deadLettered":   cb.deadLettered,
+		"dropped":        cb.dropped,
+	}
+}
+
+func (cb *circuitBreakerStage) metrics(id string) []abstraction.Metric {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	labels := map[string]string{"id": id}
+	return []abstraction.Metric{
+		{Name: "notifier_circuit_breaker_state", Help: "Circuit breaker state: 0 closed, 1 open, 2 half-open.", Type: abstraction.MetricTypeGauge, Labels: labels, Value: float64(cb.state)},
+		{Name: "notifier_circuit_breaker_buffered", Help: "Notifications buffered by the circuit breaker.", Type: abstraction.MetricTypeGauge, Labels: labels, Value: float64(len(cb.buffer))},
+		{Name: "notifier_circuit_breaker_short_circuited_total", Help: "Notifications short-circuited by the circuit breaker.", Type: abstraction.MetricTypeCounter, Labels: labels, Value: float64(cb.shortCircuited)},
+		{Name: "notifier_circuit_breaker_dead_lettered_total", Help: "Notifications written to the dead letter file.", Type: abstraction.MetricTypeCounter, Labels: labels, Value: float64(cb.deadLettered)},
+		{Name: "notifier_circuit_breaker_dropped_total", Help: "Notifications dropped by the circuit breaker.", Type: abstraction.MetricTypeCounter, Labels: labels, Value: float64(cb.dropped)},
+	}
+}
+
+// pending returns the notifications buffered while the breaker is not closed, and those whose
+// delivery outcome was not reported.
+func (cb *circuitBreakerStage) pending() []notification.Notification {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	return slices.Concat(cb.inFlight, cb.buffer)
+}
@@ -0,0 +1,254 @@
+package sender
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+func newTestCircuitBreaker(circuitBreaker config.CircuitBreakerConfig) *circuitBreakerStage {
+	return newCircuitBreakerStage(circuitBreaker, slog.Default)
+}
+
+// handOver hands the first buffered notification to the sender as the running stage does.
+func handOver(cb *circuitBreakerStage) {
+	cb.lock.Lock()
+	cb.take()
+	cb.lock.Unlock()
+	cb.sent()
+}
+
+func TestCircuitBreakerOpensAfterFailureThreshold(t *testing.T) {
+	t.Parallel()
+
+	cb := newTestCircuitBreaker(config.CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: time.Minute})
+	now := time.Now()
+	failure := errors.New("failed")
+
+	cb.recordAt(failure, now)
+	cb.recordAt(nil, now)
+	cb.recordAt(failure, now)
+	if cb.state != breakerClosed {
+		t.Fatalf("state = %s after non-consecutive failures, want closed", cb.state)
+	}
+
+	cb.recordAt(failure, now)
+	if cb.state != breakerOpen {
+		t.Fatalf("state = %s, want open", cb.state)
+	}
+}
+
+func TestCircuitBreakerBuffersAndDeadLettersWhileOpen(t *testing.T) {
+	t.Parallel()
+
+	deadLetterFile := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	cb := newTestCircuitBreaker(config.CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenDuration:     time.Minute,
+		BufferSize:       1,
+		DeadLetterFile:   deadLetterFile,
+	})
+	cb.recordAt(errors.New("failed"), time.Now())
+
+	cb.accept(notification.Notification{Title: "first"})
+	cb.accept(notification.Notification{Title: "second"})
+
+	if len(cb.buffer) != 1 || cb.buffer[0].Title != "first" {
+		t.Fatalf("buffer = %v, want only first", cb.buffer)
+	}
+	if cb.shortCircuited != 2 || cb.deadLettered != 1 {
+		t.Fatalf("shortCircuited = %d, deadLettered = %d, want 2 and 1", cb.shortCircuited, cb.deadLettered)
+	}
+
+	f, err := os.Open(deadLetterFile)
+	if err != nil {
+		t.Fatalf("open dead letter file: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("dead letter file is empty")
+	}
+	var n notification.Notification
+	if err := json.Unmarshal(scanner.Bytes(), &n); err != nil {
+		t.Fatalf("unmarshal dead letter: %v", err)
+	}
+	if n.Title != "second" {
+		t.Fatalf("dead letter title = %q, want %q", n.Title, "second")
+	}
+}
+
+func TestCircuitBreakerClosesAfterSuccessfulProbe(t *testing.T) {
+	t.Parallel()
+
+	cb := newTestCircuitBreaker(config.CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Minute})
+	cb.assumeDelivered = true
+	now := time.Now()
+	cb.recordAt(errors.New("failed"), now)
+
+	cb.halfOpen(now.Add(30 * time.Second))
+	if cb.state != breakerOpen {
+		t.Fatalf("state = %s before openDuration elapsed, want open", cb.state)
+	}
+
+	cb.halfOpen(now.Add(time.Minute))
+	if cb.state != breakerHalfOpen {
+		t.Fatalf("state = %s, want half-open", cb.state)
+	}
+
+	cb.accept(notification.Notification{Title: "probe"})
+	handOver(cb)
+	if cb.state != breakerClosed {
+		t.Fatalf("state = %s after successful probe, want closed", cb.state)
+	}
+}
+
+func TestCircuitBreakerStageHoldsNotificationsWhileOpen(t *testing.T) {
+	t.Parallel()
+
+	cb := newTestCircuitBreaker(config.CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: 50 * time.Millisecond, BufferSize: 10})
+	cb.assumeDelivered = true
+	cb.record(errors.New("failed"))
+
+	in := make(chan notification.Notification)
+	out := make(chan notification.Notification)
+	stop := make(chan struct{})
+	defer close(stop)
+	go cb.run(in, out, stop)
+
+	in <- notification.Notification{Title: "held"}
+
+	select {
+	case n := <-out:
+		if n.Title != "held" {
+			t.Fatalf("Title = %q, want %q", n.Title, "held")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification was not released after the breaker half-opened")
+	}
+}
+
+func TestCircuitBreakerStageFlushProbesOpenBreaker(t *testing.T) {
+	t.Parallel()
+
+	cb := newTestCircuitBreaker(config.CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Hour, BufferSize: 10})
+	cb.record(errors.New("failed"))
+
+	in := make(chan notification.Notification)
+	out := make(chan notification.Notification)
+	stop := make(chan struct{})
+	defer close(stop)
+	go cb.run(in, out, stop)
+
+	in <- notification.Notification{Title: "first"}
+	in <- notification.Notification{Title: "second"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	flushed := make(chan error, 1)
+	go func() { flushed <- requestFlush(ctx, cb.flushCh) }()
+
+	probe := <-out
+	if probe.Title != "first" {
+		t.Fatalf("probe = %q, want first", probe.Title)
+	}
+	cb.recordDelivery(probe, errors.New("still failing"))
+
+	if err := <-flushed; err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	if pending := cb.pending(); len(pending) != 2 || pending[0].Title != "first" || pending[1].Title != "second" {
+		t.Fatalf("pending = %+v, want the failed probe kept in front of second", pending)
+	}
+}
+
+func TestCircuitBreakerRequeuesFailedDeliveries(t *testing.T) {
+	t.Parallel()
+
+	deadLetterFile := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	cb := newTestCircuitBreaker(config.CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenDuration:     time.Minute,
+		BufferSize:       1,
+		DeadLetterFile:   deadLetterFile,
+	})
+	decisions := make(map[string]Decision)
+	cb.decide = func(n notification.Notification, decision Decision) { decisions[n.Id] = decision }
+	now := time.Now()
+
+	first := notification.Notification{Id: "1", Title: "first"}
+	cb.accept(first)
+	handOver(cb)
+	cb.recordDeliveryAt(first, errors.New("failed"), now)
+	if len(cb.buffer) != 1 || cb.buffer[0].Id != "1" || decisions["1"] != DecisionRequeued {
+		t.Fatalf("buffer = %+v, decision = %s, want the failure counting toward the threshold requeued", cb.buffer, decisions["1"])
+	}
+
+	// The breaker opens on the second failure, and the full buffer sends it to the dead letters.
+	handOver(cb)
+	cb.accept(notification.Notification{Id: "2", Title: "second"})
+	cb.recordDeliveryAt(first, errors.New("failed"), now)
+	if cb.state != breakerOpen {
+		t.Fatalf("state = %s, want open", cb.state)
+	}
+	if len(cb.buffer) != 1 || cb.buffer[0].Id != "2" || decisions["1"] != DecisionDeadLettered {
+		t.Fatalf("buffer = %+v, decision = %s, want first dead-lettered behind the full buffer", cb.buffer, decisions["1"])
+	}
+
+	// A failed probe is kept in front of the buffer.
+	cb.halfOpen(now.Add(time.Minute))
+	handOver(cb)
+	cb.recordDeliveryAt(notification.Notification{Id: "2", Title: "second"}, errors.New("failed"), now.Add(time.Minute))
+	if cb.state != breakerOpen || len(cb.buffer) != 1 || cb.buffer[0].Id != "2" {
+		t.Fatalf("state = %s, buffer = %+v, want the failed probe buffered again", cb.state, cb.buffer)
+	}
+	if len(cb.inFlight) != 0 {
+		t.Fatalf("inFlight = %+v, want nothing once outcomes are reported", cb.inFlight)
+	}
+}
+
+func TestSenderSendsFailedDeliveryAgain(t *testing.T) {
+	impl := &recordingSenderImpl{id: "push", logger: slog.Default()}
+	impl.setFail(true)
+	s := NewSender(impl)
+	s.SetCircuitBreaker(config.CircuitBreakerConfig{FailureThreshold: 5, OpenDuration: time.Minute, BufferSize: 10})
+
+	outcomes := make(chan error, 1)
+	s.AddDecisionHandler(func(n notification.Notification, decision Decision) {
+		if decision == DecisionRequeued {
+			impl.setFail(false)
+		}
+	})
+	s.AddDeliveryHandler(func(n notification.Notification, err error) { outcomes <- err })
+
+	ch := make(chan notification.Notification)
+	done := make(chan struct{})
+	errCh := s.Start(ch, done)
+	defer func() {
+		close(done)
+		<-errCh
+	}()
+
+	ch <- notification.Notification{Id: "1", Title: "disk full"}
+	select {
+	case err := <-outcomes:
+		if err != nil {
+			t.Fatalf("outcome = %v, want the retried delivery to succeed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failed notification was not sent again")
+	}
+	if got := impl.titles(); len(got) != 1 || got[0] != "disk full" {
+		t.Fatalf("delivered %v, want [disk full]", got)
+	}
+}
@@ -14,7 +14,9 @@ import (
 	"github.com/Kotaro7750/notifier/abstraction"
 	"github.com/Kotaro7750/notifier/config"
 	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/tracing"
 
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/yaml.v3"
 )
 
@@ -22,9 +24,18 @@ const defaultDatadogEventRequestTimeout = 10 * time.Second
 const datadogSiteEnvVar = "DD_SITE"
 const datadogAPIKeyEnvVar = "DD_API_KEY"
 
+// defaultDatadogSeverityMapping maps severities onto Datadog alert types when severityMapping
+// is not configured.
+var defaultDatadogSeverityMapping = map[string]string{
+	"debug":   string(datadogV1.EVENTALERTTYPE_INFO),
+	"warning": string(datadogV1.EVENTALERTTYPE_WARNING),
+	"error":   string(datadogV1.EVENTALERTTYPE_ERROR),
+}
+
 type DatadogEventSenderProperties struct {
-	Site   string `yaml:"site"`
-	APIKey string `yaml:"apiKey"`
+	Site            string            `yaml:"site"`
+	APIKey          string            `yaml:"apiKey"`
+	SeverityMapping map[string]string `yaml:"severityMapping"`
 }
 
 func (p DatadogEventSenderProperties) Validate() error {
@@ -36,6 +47,12 @@ func (p DatadogEventSenderProperties) Validate() error {
 		return fmt.Errorf("apiKey is required")
 	}
 
+	if p.SeverityMapping != nil {
+		if _, err := parseDatadogSeverityMapping(p.SeverityMapping); err != nil {
+			return fmt.Errorf("severityMapping is invalid: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -59,10 +76,20 @@ func DatadogEventSenderBuilder(id string, properties yaml.Node) (abstraction.Abs
 		return nil, err
 	}
 
+	severityMappingTable := parsedProperties.SeverityMapping
+	if severityMappingTable == nil {
+		severityMappingTable = defaultDatadogSeverityMapping
+	}
+	alertTypes, err := parseDatadogSeverityMapping(severityMappingTable)
+	if err != nil {
+		return nil, err
+	}
+
 	site := parsedProperties.eventsURL()
 	configuration := datadog.NewConfiguration()
 	configuration.HTTPClient = &http.Client{
-		Timeout: defaultDatadogEventRequestTimeout,
+		Timeout:   defaultDatadogEventRequestTimeout,
+		Transport: tracing.NewTransport(nil),
 	}
 
 	ctx := context.WithValue(
@@ -81,12 +108,13 @@ func DatadogEventSenderBuilder(id string, properties yaml.Node) (abstraction.Abs
 	)
 
 	return NewSender(&datadogEventSenderImpl{
-		id:        id,
-		logger:    nil,
-		site:      site,
-		apiKey:    parsedProperties.APIKey,
-		ctx:       ctx,
-		eventsAPI: datadogV1.NewEventsApi(datadog.NewAPIClient(configuration)),
+		id:         id,
+		logger:     nil,
+		site:       site,
+		apiKey:     parsedProperties.APIKey,
+		ctx:        ctx,
+		eventsAPI:  datadogV1.NewEventsApi(datadog.NewAPIClient(configuration)),
+		alertTypes: alertTypes,
 	}), nil
 }
 
@@ -101,12 +129,14 @@ func (p DatadogEventSenderProperties) withEnvFallback() DatadogEventSenderProper
 }
 
 type datadogEventSenderImpl struct {
-	id        string
-	logger    *slog.Logger
-	site      string
-	apiKey    string
-	ctx       context.Context
-	eventsAPI *datadogV1.EventsApi
+	id         string
+	logger     *slog.Logger
+	site       string
+	apiKey     string
+	ctx        context.Context
+	eventsAPI  *datadogV1.EventsApi
+	alertTypes severityMapping[string]
+	onDelivery DeliveryHandler
 }
 
 func (dsi *datadogEventSenderImpl) GetId() string {
@@ -121,6 +151,10 @@ func (dsi *datadogEventSenderImpl) SetLogger(logger *slog.Logger) {
 	dsi.logger = logger
 }
 
+func (dsi *datadogEventSenderImpl) SetDeliveryHandler(handler DeliveryHandler) {
+	dsi.onDelivery = handler
+}
+
 func (dsi *datadogEventSenderImpl) Start(inputCh <-chan notification.Notification, done <-chan struct{}) <-chan error {
 	retCh := make(chan error)
 
@@ -135,7 +169,11 @@ func (dsi *datadogEventSenderImpl) Start(inputCh <-chan notification.Notificatio
 					return
 				}
 
-				if err := dsi.send(n); err != nil {
+				err := dsi.send(n)
+				if dsi.onDelivery != nil {
+					dsi.onDelivery(n, err)
+				}
+				if err != nil {
 					retCh <- err
 					return
 				}
@@ -149,11 +187,15 @@ func (dsi *datadogEventSenderImpl) Start(inputCh <-chan notification.Notificatio
 	return retCh
 }
 
-func (dsi *datadogEventSenderImpl) send(n notification.Notification) error {
+func (dsi *datadogEventSenderImpl) send(n notification.Notification) (err error) {
+	_, span := startSendSpan(n, dsi.id)
+	defer func() { tracing.End(span, err) }()
+
 	body := *datadogV1.NewEventCreateRequest(n.Message, n.Title)
-	body.SetAlertType(datadogAlertType(n.Severity))
+	body.SetAlertType(datadogV1.EventAlertType(dsi.alertTypes.lookup(n.Severity)))
 
-	_, resp, err := dsi.eventsAPI.CreateEvent(dsi.ctx, body)
+	// dsi.ctx holds the site and API key, so the span is added to it rather than the reverse.
+	_, resp, err := dsi.eventsAPI.CreateEvent(trace.ContextWithSpan(dsi.ctx, span), body)
 	if err != nil {
 		if resp != nil {
 			return fmt.Errorf("send datadog event: status %s: %w", resp.Status, err)
@@ -164,13 +206,18 @@ func (dsi *datadogEventSenderImpl) send(n notification.Notification) error {
 	return nil
 }
 
-func datadogAlertType(severity slog.Level) datadogV1.EventAlertType {
-	switch {
-	case severity >= slog.LevelError:
-		return datadogV1.EVENTALERTTYPE_ERROR
-	case severity >= slog.LevelWarn:
-		return datadogV1.EVENTALERTTYPE_WARNING
-	default:
-		return datadogV1.EVENTALERTTYPE_INFO
+// parseDatadogSeverityMapping builds a severity mapping whose values are Datadog alert types.
+func parseDatadogSeverityMapping(table map[string]string) (severityMapping[string], error) {
+	mapping, err := parseSeverityMapping(table)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, threshold := range mapping {
+		if _, err := datadogV1.NewEventAlertTypeFromValue(threshold.value); err != nil {
+			return nil, err
+		}
 	}
+
+	return mapping, nil
 }
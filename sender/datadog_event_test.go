@@ -3,6 +3,8 @@ package sender
 import (
 	"testing"
 
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+	"github.com/Kotaro7750/notifier/notification"
 	"github.com/Kotaro7750/notifier/test_util"
 )
 
@@ -101,3 +103,65 @@ unknown: value
 		t.Fatal("DatadogEventSenderBuilder unexpectedly succeeded")
 	}
 }
+
+func TestDatadogEventSenderBuilderUsesDefaultSeverityMapping(t *testing.T) {
+	properties := test_util.MustPropertiesNode(t, `
+site: datadoghq.com
+apiKey: test-api-key
+`)
+
+	component, err := DatadogEventSenderBuilder("sender-1", properties)
+	if err != nil {
+		t.Fatalf("DatadogEventSenderBuilder returned error: %v", err)
+	}
+
+	impl := component.(*Sender).impl.(*datadogEventSenderImpl)
+	tests := map[notification.Severity]datadogV1.EventAlertType{
+		notification.SeverityDebug - 4: datadogV1.EVENTALERTTYPE_INFO,
+		notification.SeverityInfo:      datadogV1.EVENTALERTTYPE_INFO,
+		notification.SeverityWarning:   datadogV1.EVENTALERTTYPE_WARNING,
+		notification.SeverityError:     datadogV1.EVENTALERTTYPE_ERROR,
+		notification.SeverityCritical:  datadogV1.EVENTALERTTYPE_ERROR,
+	}
+	for severity, want := range tests {
+		if got := datadogV1.EventAlertType(impl.alertTypes.lookup(severity)); got != want {
+			t.Fatalf("alert type for %s = %q, want %q", severity, got, want)
+		}
+	}
+}
+
+func TestDatadogEventSenderBuilderUsesConfiguredSeverityMapping(t *testing.T) {
+	properties := test_util.MustPropertiesNode(t, `
+site: datadoghq.com
+apiKey: test-api-key
+severityMapping:
+  info: success
+  critical: error
+`)
+
+	component, err := DatadogEventSenderBuilder("sender-1", properties)
+	if err != nil {
+		t.Fatalf("DatadogEventSenderBuilder returned error: %v", err)
+	}
+
+	impl := component.(*Sender).impl.(*datadogEventSenderImpl)
+	if got := impl.alertTypes.lookup(notification.SeverityError); got != "success" {
+		t.Fatalf("alert type for error = %q, want %q", got, "success")
+	}
+	if got := impl.alertTypes.lookup(notification.SeverityCritical); got != "error" {
+		t.Fatalf("alert type for critical = %q, want %q", got, "error")
+	}
+}
+
+func TestDatadogEventSenderBuilderRejectsUnknownAlertType(t *testing.T) {
+	properties := test_util.MustPropertiesNode(t, `
+site: datadoghq.com
+apiKey: test-api-key
+severityMapping:
+  error: page
+`)
+
+	if _, err := DatadogEventSenderBuilder("sender-1", properties); err == nil {
+		t.Fatal("DatadogEventSenderBuilder unexpectedly succeeded")
+	}
+}
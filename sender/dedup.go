@@ -0,0 +1,139 @@
+package sender
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+// RepeatedLabel holds how many times the content of a notification was suppressed by dedup
+// since it was last delivered. It is reserved, so that it does not overwrite a label of the
+// notification.
+const RepeatedLabel = "notifier_repeated"
+
+// RouteLabel holds the route of notifications delivered to senders by another way than their
+// match condition, such as the steps of an escalation policy. Such notifications bypass match
+// and minSeverity, and stages keeping state per route, such as dedup, key it by this label.
+// Notifications without it take the direct route, and the router removes it from received
+// notifications.
+const RouteLabel = "notifier_route"
+
+// dedupRepeatsRetention is how long after its window an entry with repeats waits for its
+// content to come back, so that content that never does is forgotten.
+const dedupRepeatsRetention = 24 * time.Hour
+
+// dedupFilter suppresses notifications whose content was already delivered on the same route
+// within the window and annotates the next delivered one with how many times it was repeated.
+type dedupFilter struct {
+	fields  []string
+	labels  []string
+	window  time.Duration
+	entries map[[sha256.Size]byte]*dedupEntry
+	// nextSweep is when forgetExpired next scans entries, so that it does not scan them for
+	// every notification.
+	nextSweep time.Time
+}
+
+type dedupEntry struct {
+	expiresAt time.Time
+	repeats   int
+}
+
+func newDedupFilter(dedup config.DedupConfig) *dedupFilter {
+	fields := dedup.Fields
+	if len(fields) == 0 && len(dedup.Labels) == 0 {
+		fields = []string{config.DedupFieldTitle, config.DedupFieldMessage, config.DedupFieldNotificationSource}
+	}
+
+	return &dedupFilter{
+		fields:  fields,
+		labels:  dedup.Labels,
+		window:  dedup.Window,
+		entries: make(map[[sha256.Size]byte]*dedupEntry),
+	}
+}
+
+func (d *dedupFilter) filter(n notification.Notification) (notification.Notification, bool) {
+	return d.filterAt(n, time.Now())
+}
+
+func (d *dedupFilter) filterAt(n notification.Notification, now time.Time) (notification.Notification, bool) {
+	d.forgetExpired(now)
+
+	key := d.key(n)
+	entry, ok := d.entries[key]
+	if ok && now.Before(entry.expiresAt) {
+		entry.repeats++
+		return n, false
+	}
+
+	if ok && entry.repeats > 0 && now.Before(entry.expiresAt.Add(dedupRepeatsRetention)) {
+		n = annotateRepeats(n, entry.repeats)
+	}
+
+	d.entries[key] = &dedupEntry{expiresAt: now.Add(d.window)}
+	return n, true
+}
+
+// forgetExpired removes entries that can no longer suppress or annotate anything, at most once
+// per window. Entries with repeats are kept for dedupRepeatsRetention after their window, so
+// that the next occurrence of their content carries the count.
+func (d *dedupFilter) forgetExpired(now time.Time) {
+	if now.Before(d.nextSweep) {
+		return
+	}
+	d.nextSweep = now.Add(d.window)
+
+	for key, entry := range d.entries {
+		forgetAt := entry.expiresAt
+		if entry.repeats > 0 {
+			forgetAt = forgetAt.Add(dedupRepeatsRetention)
+		}
+		if !now.Before(forgetAt) {
+			delete(d.entries, key)
+		}
+	}
+}
+
+// key hashes the route of n with its compared fields and labels, so that each route has its
+// own entries.
+func (d *dedupFilter) key(n notification.Notification) [sha256.Size]byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "route=%s\n", strconv.Quote(n.Labels[RouteLabel]))
+	for _, field := range d.fields {
+		var value string
+		switch field {
+		case config.DedupFieldTitle:
+			value = n.Title
+		case config.DedupFieldMessage:
+			value = n.Message
+		case config.DedupFieldNotificationSource:
+			value = n.NotificationSource
+		}
+		fmt.Fprintf(h, "%s=%s\n", field, strconv.Quote(value))
+	}
+	for _, label := range d.labels {
+		value, ok := n.Labels[label]
+		fmt.Fprintf(h, "labels.%s=%t,%s\n", strconv.Quote(label), ok, strconv.Quote(value))
+	}
+
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+func annotateRepeats(n notification.Notification, repeats int) notification.Notification {
+	labels := make(map[string]string, len(n.Labels)+1)
+	for key, value := range n.Labels {
+		labels[key] = value
+	}
+	labels[RepeatedLabel] = strconv.Itoa(repeats)
+
+	n.Labels = labels
+	n.Message = fmt.Sprintf("%s\n(repeated %d times)", n.Message, repeats)
+	return n
+}
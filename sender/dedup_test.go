@@ -0,0 +1,138 @@
+package sender
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+func TestDedupFilterSuppressesRepeatsWithinWindow(t *testing.T) {
+	filter := newDedupFilter(config.DedupConfig{Window: time.Minute})
+
+	now := time.Now()
+	n := notification.Notification{Title: "disk full", Message: "host-1", NotificationSource: "monitor"}
+
+	if _, ok := filter.filterAt(n, now); !ok {
+		t.Fatal("first notification was suppressed")
+	}
+	if _, ok := filter.filterAt(n, now.Add(10*time.Second)); ok {
+		t.Fatal("repeat within window was delivered")
+	}
+	if _, ok := filter.filterAt(n, now.Add(20*time.Second)); ok {
+		t.Fatal("repeat within window was delivered")
+	}
+
+	other := notification.Notification{Title: "disk full", Message: "host-2", NotificationSource: "monitor"}
+	if _, ok := filter.filterAt(other, now.Add(30*time.Second)); !ok {
+		t.Fatal("notification with different content was suppressed")
+	}
+
+	got, ok := filter.filterAt(n, now.Add(time.Minute))
+	if !ok {
+		t.Fatal("notification after window was suppressed")
+	}
+	if got.Labels[RepeatedLabel] != "2" {
+		t.Fatalf("Labels[%s] = %q, want %q", RepeatedLabel, got.Labels[RepeatedLabel], "2")
+	}
+	if got.Message != "host-1\n(repeated 2 times)" {
+		t.Fatalf("Message = %q", got.Message)
+	}
+}
+
+func TestDedupFilterUsesConfiguredFields(t *testing.T) {
+	filter := newDedupFilter(config.DedupConfig{
+		Fields: []string{config.DedupFieldTitle},
+		Labels: []string{"env"},
+		Window: time.Minute,
+	})
+
+	now := time.Now()
+	if _, ok := filter.filterAt(notification.Notification{Title: "down", Message: "a", Labels: map[string]string{"env": "prod"}}, now); !ok {
+		t.Fatal("first notification was suppressed")
+	}
+	if _, ok := filter.filterAt(notification.Notification{Title: "down", Message: "b", Labels: map[string]string{"env": "prod"}}, now); ok {
+		t.Fatal("notification differing only in message was delivered")
+	}
+	if _, ok := filter.filterAt(notification.Notification{Title: "down", Message: "a", Labels: map[string]string{"env": "stg"}}, now); !ok {
+		t.Fatal("notification with different label was suppressed")
+	}
+}
+
+func TestDedupFilterDoesNotAnnotateWithoutRepeats(t *testing.T) {
+	filter := newDedupFilter(config.DedupConfig{Window: time.Minute})
+
+	now := time.Now()
+	n := notification.Notification{Title: "disk full"}
+	filter.filterAt(n, now)
+
+	got, ok := filter.filterAt(n, now.Add(2*time.Minute))
+	if !ok {
+		t.Fatal("notification after window was suppressed")
+	}
+	if _, ok := got.Labels[RepeatedLabel]; ok {
+		t.Fatalf("Labels = %v, want no %s label", got.Labels, RepeatedLabel)
+	}
+}
+
+func TestDedupFilterKeepsRepeatsUntilNextOccurrence(t *testing.T) {
+	filter := newDedupFilter(config.DedupConfig{Window: time.Minute})
+
+	now := time.Now()
+	n := notification.Notification{Title: "disk full", Labels: map[string]string{"repeated": "user value"}}
+	filter.filterAt(n, now)
+	filter.filterAt(n, now.Add(time.Second))
+
+	got, ok := filter.filterAt(n, now.Add(time.Hour))
+	if !ok {
+		t.Fatal("notification after window was suppressed")
+	}
+	if got.Labels[RepeatedLabel] != "1" {
+		t.Fatalf("Labels[%s] = %q, want %q", RepeatedLabel, got.Labels[RepeatedLabel], "1")
+	}
+	if got.Labels["repeated"] != "user value" {
+		t.Fatalf("Labels = %v, want the label of the notification kept", got.Labels)
+	}
+}
+
+func TestDedupFilterForgetsRepeatsAfterRetention(t *testing.T) {
+	filter := newDedupFilter(config.DedupConfig{Window: time.Minute})
+
+	now := time.Now()
+	n := notification.Notification{Title: "disk full"}
+	filter.filterAt(n, now)
+	filter.filterAt(n, now.Add(time.Second))
+
+	later := now.Add(time.Minute + dedupRepeatsRetention)
+	filter.filterAt(notification.Notification{Title: "other"}, later)
+	if len(filter.entries) != 1 {
+		t.Fatalf("entries = %d, want only the one of the latest notification", len(filter.entries))
+	}
+
+	got, ok := filter.filterAt(n, later)
+	if !ok {
+		t.Fatal("notification after retention was suppressed")
+	}
+	if _, ok := got.Labels[RepeatedLabel]; ok {
+		t.Fatalf("Labels = %v, want no %s label after retention", got.Labels, RepeatedLabel)
+	}
+}
+
+func TestDedupFilterKeepsStatePerRoute(t *testing.T) {
+	filter := newDedupFilter(config.DedupConfig{Window: time.Minute})
+
+	now := time.Now()
+	direct := notification.Notification{Title: "disk full"}
+	escalated := notification.Notification{Title: "disk full", Labels: map[string]string{RouteLabel: "escalation/db/1"}}
+
+	if _, ok := filter.filterAt(direct, now); !ok {
+		t.Fatal("direct notification was suppressed")
+	}
+	if _, ok := filter.filterAt(escalated, now.Add(time.Second)); !ok {
+		t.Fatal("notification on another route was suppressed")
+	}
+	if _, ok := filter.filterAt(escalated, now.Add(2*time.Second)); ok {
+		t.Fatal("repeat on the same route was delivered")
+	}
+}
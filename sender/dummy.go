@@ -8,6 +8,7 @@ import (
 	"github.com/Kotaro7750/notifier/abstraction"
 	"github.com/Kotaro7750/notifier/config"
 	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/tracing"
 
 	"gopkg.in/yaml.v3"
 )
@@ -57,6 +58,7 @@ type dummySenderImpl struct {
 	logger           *slog.Logger
 	errorInterval    time.Duration
 	shutdownDuration time.Duration
+	onDelivery       DeliveryHandler
 }
 
 func (dsi *dummySenderImpl) GetId() string {
@@ -71,6 +73,10 @@ func (dsi *dummySenderImpl) SetLogger(logger *slog.Logger) {
 	dsi.logger = logger
 }
 
+func (dsi *dummySenderImpl) SetDeliveryHandler(handler DeliveryHandler) {
+	dsi.onDelivery = handler
+}
+
 func (dsi *dummySenderImpl) Start(inputCh <-chan notification.Notification, done <-chan struct{}) <-chan error {
 	retCh := make(chan error)
 
@@ -94,7 +100,12 @@ func (dsi *dummySenderImpl) Start(inputCh <-chan notification.Notification, done
 				if !ok {
 					dsi.GetLogger().Info("inputCh closed")
 				} else {
+					_, span := startSendSpan(n, dsi.id)
 					dsi.GetLogger().Info("Notify send from dummySender", "notification", n)
+					tracing.End(span, nil)
+					if dsi.onDelivery != nil {
+						dsi.onDelivery(n, nil)
+					}
 				}
 
 			case <-errorTickCh:
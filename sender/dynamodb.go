@@ -0,0 +1,151 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	defaultDynamoDBRegion             = "ap-northeast-1"
+	defaultDynamoDBSubscriptionsTable = "notifier-subscriptions"
+	defaultDynamoDBConfigTable        = "notifier-config"
+	defaultDynamoDBRequestTimeout     = 10 * time.Second
+	// dynamoDBTableCreationTimeout bounds the wait for a created table to become active.
+	dynamoDBTableCreationTimeout = 2 * time.Minute
+)
+
+// DynamoDBProperties configures the DynamoDB repository and the DynamoDB VAPID key source.
+type DynamoDBProperties struct {
+	Region string `yaml:"region"`
+	// Endpoint overrides the endpoint of DynamoDB, such as http://localhost:8000 for
+	// DynamoDB Local.
+	Endpoint string `yaml:"endpoint"`
+	// SubscriptionsTable holds subscriptions keyed by their Endpoint attribute, and ConfigTable
+	// holds the VAPID keys keyed by their Key attribute.
+	SubscriptionsTable string `yaml:"subscriptionsTable"`
+	ConfigTable        string `yaml:"configTable"`
+	// KeyPrefix is prepended to the keys of the items of this sender, so that several webPush
+	// senders can share the tables. Subscriptions are loaded by the exact prefix, so a sender
+	// without one does not load those of the others.
+	KeyPrefix string `yaml:"keyPrefix"`
+	// CreateTables creates the tables with on-demand capacity when they do not exist.
+	CreateTables bool `yaml:"createTables"`
+	// RequestTimeout bounds each request to DynamoDB.
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+}
+
+func NewDynamoDBProperties() DynamoDBProperties {
+	return DynamoDBProperties{
+		Region:             defaultDynamoDBRegion,
+		SubscriptionsTable: defaultDynamoDBSubscriptionsTable,
+		ConfigTable:        defaultDynamoDBConfigTable,
+		RequestTimeout:     defaultDynamoDBRequestTimeout,
+	}
+}
+
+func (p DynamoDBProperties) Validate() error {
+	if p.Region == "" {
+		return fmt.Errorf("region is required")
+	}
+
+	if p.Endpoint != "" {
+		endpoint, err := url.Parse(p.Endpoint)
+		if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+			return fmt.Errorf("endpoint should be an absolute URL: %q", p.Endpoint)
+		}
+	}
+
+	if p.SubscriptionsTable == "" {
+		return fmt.Errorf("subscriptionsTable is required")
+	}
+	if p.ConfigTable == "" {
+		return fmt.Errorf("configTable is required")
+	}
+
+	if p.RequestTimeout <= 0 {
+		return fmt.Errorf("requestTimeout should be greater than 0")
+	}
+
+	return nil
+}
+
+// dynamoDBConnection creates the DynamoDB client configured by its properties on first use, so
+// that AWS configuration is only loaded when DynamoDB is used.
+type dynamoDBConnection struct {
+	properties DynamoDBProperties
+	client     *dynamodb.Client
+}
+
+func newDynamoDBConnection(properties DynamoDBProperties) *dynamoDBConnection {
+	return &dynamoDBConnection{properties: properties}
+}
+
+func (c *dynamoDBConnection) getClient() (*dynamodb.Client, error) {
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), awsconfig.WithRegion(c.properties.Region))
+	if err != nil {
+		return nil, fmt.Errorf("Load AWS config failed. err: %s", err)
+	}
+	c.client = dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if c.properties.Endpoint != "" {
+			o.BaseEndpoint = aws.String(c.properties.Endpoint)
+		}
+	})
+	return c.client, nil
+}
+
+// ensureTable creates table, keyed by the string attribute keyAttribute, when createTables is
+// set and the table does not exist.
+func (c *dynamoDBConnection) ensureTable(ctx context.Context, table string, keyAttribute string) error {
+	if !c.properties.CreateTables {
+		return nil
+	}
+
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+
+	describeCtx, cancel := context.WithTimeout(ctx, c.properties.RequestTimeout)
+	_, err = client.DescribeTable(describeCtx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	cancel()
+	var notFound *types.ResourceNotFoundException
+	if err == nil || !errors.As(err, &notFound) {
+		return err
+	}
+
+	createCtx, cancel := context.WithTimeout(ctx, c.properties.RequestTimeout)
+	_, err = client.CreateTable(createCtx, &dynamodb.CreateTableInput{
+		TableName: aws.String(table),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(keyAttribute), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(keyAttribute), KeyType: types.KeyTypeHash},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	cancel()
+	// Another instance starting at the same time may be creating the table.
+	var inUse *types.ResourceInUseException
+	if err != nil && !errors.As(err, &inUse) {
+		return fmt.Errorf("CreateTable %s failed: %w", table, err)
+	}
+
+	waiter := dynamodb.NewTableExistsWaiter(client)
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)}, dynamoDBTableCreationTimeout); err != nil {
+		return fmt.Errorf("waiting for table %s failed: %w", table, err)
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/test_util"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+)
+
+// fakeDynamoDB answers DynamoDB requests with handle, called with the operation of the request,
+// such as Scan, and its decoded body.
+func fakeDynamoDB(t *testing.T, handle func(operation string, body map[string]any) (int, any)) *httptest.Server {
+	t.Helper()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		_, operation, _ := strings.Cut(r.Header.Get("X-Amz-Target"), ".")
+
+		status, response := handle(operation, body)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newTestDynamoDBSubscriptionRepository(t *testing.T, endpoint string, keyPrefix string) *DynamoDBSubscriptionRepository {
+	t.Helper()
+
+	properties := NewDynamoDBProperties()
+	properties.Endpoint = endpoint
+	properties.SubscriptionsTable = "subscriptions"
+	properties.KeyPrefix = keyPrefix
+	properties.RequestTimeout = time.Second
+
+	client, err := newDynamoDBConnection(properties).getClient()
+	if err != nil {
+		t.Fatalf("getClient returned error: %v", err)
+	}
+	return NewDynamoDBSubscriptionRepository(client, properties)
+}
+
+func TestDynamoDBSubscriptionRepositoryReturnsScanError(t *testing.T) {
+	server := fakeDynamoDB(t, func(operation string, body map[string]any) (int, any) {
+		return http.StatusBadRequest, map[string]string{
+			"__type":  "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException",
+			"message": "Requested resource not found",
+		}
+	})
+	repository := newTestDynamoDBSubscriptionRepository(t, server.URL, "")
+
+	if _, err := repository.LoadAll(context.Background()); err == nil {
+		t.Fatal("LoadAll unexpectedly succeeded")
+	}
+}
+
+func TestDynamoDBSubscriptionRepositoryTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	server := fakeDynamoDB(t, func(operation string, body map[string]any) (int, any) {
+		<-release
+		return http.StatusOK, map[string]any{}
+	})
+	defer close(release)
+	repository := newTestDynamoDBSubscriptionRepository(t, server.URL, "")
+	repository.requestTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	if _, err := repository.LoadAll(context.Background()); err == nil {
+		t.Fatal("LoadAll unexpectedly succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("LoadAll took %v, want it to time out", elapsed)
+	}
+}
+
+func TestDynamoDBSubscriptionRepositoryPrefixesKeys(t *testing.T) {
+	var lock sync.Mutex
+	requests := make(map[string]map[string]any)
+	server := fakeDynamoDB(t, func(operation string, body map[string]any) (int, any) {
+		lock.Lock()
+		requests[operation] = body
+		lock.Unlock()
+
+		if operation != "Scan" {
+			return http.StatusOK, map[string]any{}
+		}
+		return http.StatusOK, map[string]any{
+			"Items": []map[string]any{{
+				"Endpoint": map[string]string{"S": "team-a/https://push.example.com/a"},
+				"Keys": map[string]any{"M": map[string]any{
+					"Auth":   map[string]string{"S": "auth"},
+					"P256dh": map[string]string{"S": "p256dh"},
+				}},
+				"Owner":     map[string]string{"S": "alice"},
+				"KeyPrefix": map[string]string{"S": "team-a/"},
+			}},
+		}
+	})
+	repository := newTestDynamoDBSubscriptionRepository(t, server.URL, "team-a/")
+
+	subscription := WebPushSubscription{Subscription: webpush.Subscription{Endpoint: "https://push.example.com/a"}}
+	if err := repository.Store(context.Background(), subscription); err != nil {
+		t.Fatalf("Store returned error: %v", err)
+	}
+	if err := repository.Delete(context.Background(), subscription); err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+	subscriptions, err := repository.LoadAll(context.Background())
+	if err != nil {
+		t.Fatalf("LoadAll returned error: %v", err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	putKey := requests["PutItem"]["Item"].(map[string]any)["Endpoint"].(map[string]any)["S"]
+	if putKey != "team-a/https://push.example.com/a" {
+		t.Fatalf("PutItem Endpoint = %v, want the prefixed endpoint", putKey)
+	}
+	deleteKey := requests["DeleteItem"]["Key"].(map[string]any)["Endpoint"].(map[string]any)["S"]
+	if deleteKey != "team-a/https://push.example.com/a" {
+		t.Fatalf("DeleteItem Endpoint = %v, want the prefixed endpoint", deleteKey)
+	}
+	if putPrefix := requests["PutItem"]["Item"].(map[string]any)["KeyPrefix"].(map[string]any)["S"]; putPrefix != "team-a/" {
+		t.Fatalf("PutItem KeyPrefix = %v, want the prefix", putPrefix)
+	}
+	scan := requests["Scan"]
+	if scan["TableName"] != "subscriptions" || scan["FilterExpression"] != "#keyPrefix = :keyPrefix" ||
+		scan["ExpressionAttributeValues"].(map[string]any)[":keyPrefix"].(map[string]any)["S"] != "team-a/" {
+		t.Fatalf("Scan = %v, want a scan of subscriptions filtered by the exact prefix", scan)
+	}
+
+	if len(subscriptions) != 1 || subscriptions[0].Endpoint != "https://push.example.com/a" || subscriptions[0].Owner != "alice" {
+		t.Fatalf("subscriptions = %+v, want the endpoint without its prefix", subscriptions)
+	}
+}
+
+func TestDynamoDBSubscriptionRepositoryWithoutPrefixSkipsPrefixedItems(t *testing.T) {
+	var lock sync.Mutex
+	requests := make(map[string]map[string]any)
+	server := fakeDynamoDB(t, func(operation string, body map[string]any) (int, any) {
+		lock.Lock()
+		requests[operation] = body
+		lock.Unlock()
+		return http.StatusOK, map[string]any{}
+	})
+	repository := newTestDynamoDBSubscriptionRepository(t, server.URL, "")
+
+	subscription := WebPushSubscription{Subscription: webpush.Subscription{Endpoint: "https://push.example.com/a"}}
+	if err := repository.Store(context.Background(), subscription); err != nil {
+		t.Fatalf("Store returned error: %v", err)
+	}
+	if _, err := repository.LoadAll(context.Background()); err != nil {
+		t.Fatalf("LoadAll returned error: %v", err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if _, ok := requests["PutItem"]["Item"].(map[string]any)["KeyPrefix"]; ok {
+		t.Fatalf("PutItem Item = %v, want no KeyPrefix", requests["PutItem"]["Item"])
+	}
+	if got := requests["Scan"]["FilterExpression"]; got != "attribute_not_exists(#keyPrefix)" {
+		t.Fatalf("Scan FilterExpression = %v, want only items without a prefix", got)
+	}
+}
+
+func TestWebPushSenderBuilderUsesDynamoDBProperties(t *testing.T) {
+	keys := mustGenerateVAPIDKeys(t)
+	var lock sync.Mutex
+	tables := make(map[string]string)
+	var vapidItemKey any
+	server := fakeDynamoDB(t, func(operation string, body map[string]any) (int, any) {
+		lock.Lock()
+		defer lock.Unlock()
+		tables[operation] = body["TableName"].(string)
+
+		switch operation {
+		case "DescribeTable":
+			return http.StatusOK, map[string]any{"Table": map[string]any{"TableStatus": "ACTIVE"}}
+		case "GetItem":
+			vapidItemKey = body["Key"].(map[string]any)["Key"].(map[string]any)["S"]
+			return http.StatusOK, map[string]any{"Item": map[string]any{
+				"Key":   map[string]string{"S": "team-a/vapid"},
+				"Value": map[string]string{"S": keys.PrivateKey + " " + keys.PublicKey},
+			}}
+		default:
+			return http.StatusOK, map[string]any{}
+		}
+	})
+
+	properties := test_util.MustPropertiesNode(t, `
+listenAddress: :8091
+repositoryType: DynamoDB
+dynamoDB:
+  region: us-east-1
+  endpoint: `+server.URL+`
+  subscriptionsTable: team-subscriptions
+  configTable: team-config
+  keyPrefix: team-a/
+  createTables: true
+`)
+
+	component, err := WebPushSenderBuilder("sender-1", properties)
+	if err != nil {
+		t.Fatalf("WebPushSenderBuilder returned error: %v", err)
+	}
+
+	impl := component.(*Sender).impl.(*webPushSenderImpl)
+	if impl.vapidKeys != keys {
+		t.Fatalf("vapidKeys = %+v, want the keys stored in DynamoDB", impl.vapidKeys)
+	}
+	repository := impl.subscriptionRepository.(*cachedSubscriptionRepository).repository.(*DynamoDBSubscriptionRepository)
+	if repository.table != "team-subscriptions" || repository.keyPrefix != "team-a/" {
+		t.Fatalf("repository = %+v, want the configured table and prefix", repository)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if tables["GetItem"] != "team-config" || vapidItemKey != "team-a/vapid" {
+		t.Fatalf("GetItem of %v in %s, want team-a/vapid in team-config", vapidItemKey, tables["GetItem"])
+	}
+	if _, ok := tables["DescribeTable"]; !ok {
+		t.Fatal("tables were not checked with createTables")
+	}
+}
+
+func TestDynamoDBPropertiesValidate(t *testing.T) {
+	tests := map[string]func(*DynamoDBProperties){
+		"missing region":      func(p *DynamoDBProperties) { p.Region = "" },
+		"relative endpoint":   func(p *DynamoDBProperties) { p.Endpoint = "localhost:8000" },
+		"missing table":       func(p *DynamoDBProperties) { p.SubscriptionsTable = "" },
+		"zero requestTimeout": func(p *DynamoDBProperties) { p.RequestTimeout = 0 },
+	}
+
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			properties := NewDynamoDBProperties()
+			modify(&properties)
+			if err := properties.Validate(); err == nil {
+				t.Fatal("Validate unexpectedly succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Kotaro7750/notifier/abstraction"
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/execplugin"
+	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/tracing"
+
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/yaml.v3"
+)
+
+var errExecResponseTimeout = errors.New("plugin did not answer notification")
+
+func ExecSenderBuilder(id string, properties yaml.Node) (abstraction.AbstractChannelComponent, error) {
+	parsedProperties := execplugin.NewProperties()
+	if err := config.DecodeProperties(properties, &parsedProperties); err != nil {
+		return nil, err
+	}
+	if err := parsedProperties.Validate(); err != nil {
+		return nil, err
+	}
+
+	return NewSender(&execSenderImpl{
+		id:         id,
+		logger:     nil,
+		properties: parsedProperties,
+	}), nil
+}
+
+// execSenderImpl delivers notifications through an exec plugin, one at a time. Each execution
+// spawns a new plugin process.
+type execSenderImpl struct {
+	id         string
+	logger     *slog.Logger
+	properties execplugin.Properties
+	onDelivery DeliveryHandler
+}
+
+func (esi *execSenderImpl) GetId() string {
+	return esi.id
+}
+
+func (esi *execSenderImpl) GetLogger() *slog.Logger {
+	return esi.logger
+}
+
+func (esi *execSenderImpl) SetLogger(logger *slog.Logger) {
+	esi.logger = logger
+}
+
+func (esi *execSenderImpl) SetDeliveryHandler(handler DeliveryHandler) {
+	esi.onDelivery = handler
+}
+
+func (esi *execSenderImpl) Start(inputCh <-chan notification.Notification, done <-chan struct{}) <-chan error {
+	retCh := make(chan error, 1)
+
+	process, err := execplugin.Start(esi.properties, esi.GetLogger())
+	if err != nil {
+		retCh <- err
+		close(retCh)
+		return retCh
+	}
+
+	go func() {
+		defer close(retCh)
+
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		var inFlight *notification.Notification
+		var inFlightId string
+		var inFlightSpan trace.Span
+
+		for {
+			// Wait for the answer to the notification in flight before sending the next one.
+			var receiveCh <-chan notification.Notification
+			var timerCh <-chan time.Time
+			if inFlight == nil {
+				receiveCh = inputCh
+				timer.Stop()
+			} else {
+				timerCh = timer.C
+			}
+
+			select {
+			case n, ok := <-receiveCh:
+				if !ok {
+					inputCh = nil
+					continue
+				}
+				_, span := startSendSpan(n, esi.id)
+				id, err := process.Send(execplugin.Message{Type: execplugin.MessageNotification, Notification: &n})
+				if err != nil {
+					esi.report(n, span, err)
+					process.Fail(fmt.Errorf("write notification: %w", err))
+					retCh <- process.Wait()
+					return
+				}
+				inFlight = &n
+				inFlightId = id
+				inFlightSpan = span
+				timer.Reset(esi.properties.ResponseTimeout)
+
+			case m, ok := <-process.Messages():
+				if !ok {
+					if inFlight != nil {
+						esi.report(*inFlight, inFlightSpan, fmt.Errorf("plugin exited"))
+					}
+					if err := process.Wait(); err != nil {
+						retCh <- err
+					}
+					return
+				}
+				if inFlight == nil || m.Id != inFlightId {
+					esi.GetLogger().Warn("Unexpected message from plugin", "type", m.Type, "id", m.Id)
+					continue
+				}
+				switch m.Type {
+				case execplugin.MessageAck:
+					esi.report(*inFlight, inFlightSpan, nil)
+				case execplugin.MessageError:
+					esi.report(*inFlight, inFlightSpan, errors.New(m.Error))
+				default:
+					esi.GetLogger().Warn("Unexpected message from plugin", "type", m.Type, "id", m.Id)
+					continue
+				}
+				inFlight = nil
+
+			case <-timerCh:
+				esi.report(*inFlight, inFlightSpan, errExecResponseTimeout)
+				process.Fail(errExecResponseTimeout)
+				retCh <- process.Wait()
+				return
+
+			case <-done:
+				process.Stop()
+				if inFlight != nil {
+					esi.report(*inFlight, inFlightSpan, fmt.Errorf("plugin stopped"))
+				}
+				return
+			}
+		}
+	}()
+
+	return retCh
+}
+
+func (esi *execSenderImpl) report(n notification.Notification, span trace.Span, err error) {
+	tracing.End(span, err)
+	if err != nil {
+		esi.GetLogger().Error("Sending notification through plugin failed", "title", n.Title, "err", err)
+	}
+	if esi.onDelivery != nil {
+		esi.onDelivery(n, err)
+	}
+}
@@ -0,0 +1,79 @@
+package sender
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/execplugin"
+	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/test_util"
+)
+
+// TestExecHelperProcess is the plugin run by the exec sender tests. It acknowledges
+// notifications and rejects those titled "fail".
+func TestExecHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var m execplugin.Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			os.Exit(2)
+		}
+		switch {
+		case m.Type == execplugin.MessagePing:
+			encoder.Encode(execplugin.Message{Type: execplugin.MessagePong, Id: m.Id})
+		case m.Notification.Title == "fail":
+			encoder.Encode(execplugin.Message{Type: execplugin.MessageError, Id: m.Id, Error: "rejected"})
+		default:
+			encoder.Encode(execplugin.Message{Type: execplugin.MessageAck, Id: m.Id})
+		}
+	}
+	os.Exit(0)
+}
+
+func TestExecSenderReportsPluginAnswers(t *testing.T) {
+	component, err := ExecSenderBuilder("exec", test_util.MustPropertiesNode(t, `
+command: `+os.Args[0]+`
+args: ["-test.run=TestExecHelperProcess"]
+env:
+  GO_WANT_HELPER_PROCESS: "1"
+responseTimeout: 5s
+`))
+	if err != nil {
+		t.Fatalf("ExecSenderBuilder returned error: %v", err)
+	}
+	s := component.(*Sender)
+	s.SetLogger(slog.Default())
+
+	outcomes := make(chan error, 2)
+	s.AddDeliveryHandler(func(n notification.Notification, err error) { outcomes <- err })
+
+	ch := make(chan notification.Notification)
+	done := make(chan struct{})
+	errCh := s.Start(ch, done)
+
+	for _, title := range []string{"ok", "fail"} {
+		ch <- notification.Notification{Title: title}
+		select {
+		case err := <-outcomes:
+			if (err != nil) != (title == "fail") {
+				t.Fatalf("outcome of %q = %v", title, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("outcome of %q was not reported", title)
+		}
+	}
+
+	close(done)
+	if err := <-errCh; err != nil {
+		t.Fatalf("exec sender stopped with error: %v", err)
+	}
+}
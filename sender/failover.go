@@ -0,0 +1,396 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Kotaro7750/notifier/abstraction"
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	errFailoverAttemptTimeout = errors.New("delivery attempt timed out")
+	errFailoverStopped        = errors.New("failover sender is shutting down")
+	errFailoverDeclined       = errors.New("member declined the notification")
+	errFailoverMembersUnset   = errors.New("members are not set")
+)
+
+type FailoverSenderProperties struct {
+	// Members are the ids of the configured senders tried in order for every notification.
+	// Their own stages, such as match and circuitBreaker, apply to what they are handed.
+	Members []string `yaml:"members"`
+	// Cooldown is how long a member is skipped after a failed delivery.
+	Cooldown time.Duration `yaml:"cooldown"`
+	// AttemptTimeout bounds how long one member may take to accept and deliver a notification.
+	AttemptTimeout time.Duration `yaml:"attemptTimeout"`
+}
+
+func NewFailoverSenderProperties() FailoverSenderProperties {
+	return FailoverSenderProperties{
+		Cooldown:       1 * time.Minute,
+		AttemptTimeout: 30 * time.Second,
+	}
+}
+
+func (p FailoverSenderProperties) Validate() error {
+	if len(p.Members) == 0 {
+		return fmt.Errorf("at least one member is required")
+	}
+
+	ids := make(map[string]bool, len(p.Members))
+	for i, id := range p.Members {
+		if id == "" {
+			return fmt.Errorf("members[%d] should not be empty", i)
+		}
+		if ids[id] {
+			return fmt.Errorf("members[%d]: %s is duplicated", i, id)
+		}
+		ids[id] = true
+	}
+
+	if p.Cooldown < 0 {
+		return fmt.Errorf("cooldown should be greater than or equal to 0")
+	}
+
+	if p.AttemptTimeout <= 0 {
+		return fmt.Errorf("attemptTimeout should be greater than 0")
+	}
+
+	return nil
+}
+
+// FailoverSenderBuilder builds a failover sender. Its members are the senders built for the
+// configured ids, which the builder hands to it with Sender.SetMembers.
+func FailoverSenderBuilder(id string, properties yaml.Node) (abstraction.AbstractChannelComponent, error) {
+	parsedProperties := NewFailoverSenderProperties()
+	if err := config.DecodeProperties(properties, &parsedProperties); err != nil {
+		return nil, err
+	}
+	if err := parsedProperties.Validate(); err != nil {
+		return nil, err
+	}
+
+	return NewSender(&failoverSenderImpl{
+		id:             id,
+		logger:         nil,
+		ids:            parsedProperties.Members,
+		cooldown:       parsedProperties.Cooldown,
+		attemptTimeout: parsedProperties.AttemptTimeout,
+		now:            time.Now,
+	}), nil
+}
+
+// failoverMember is one member sender and its health.
+type failoverMember struct {
+	id         string
+	supervised *abstraction.AutonomousChannelComponent
+	// sender is the member when it is built on Sender, so that its circuit breaker can be
+	// checked. It is nil otherwise.
+	sender *Sender
+	// reportsDeliveries is false for members whose deliveries are assumed to succeed once
+	// they accept the notification.
+	reportsDeliveries bool
+	outcomes          chan error
+
+	lock sync.Mutex
+	// attemptId is the id of the notification being attempted. Only its outcome is sent to
+	// outcomes, since the member also delivers notifications of other routes.
+	attemptId      string
+	unhealthyUntil time.Time
+	lastErr        error
+}
+
+func newFailoverMember(member Member) *failoverMember {
+	m := &failoverMember{
+		id:         member.Supervised.GetId(),
+		supervised: member.Supervised,
+		outcomes:   make(chan error, 1),
+	}
+
+	if s, ok := member.Component.(*Sender); ok {
+		m.sender = s
+		s.AddDecisionHandler(func(n notification.Notification, decision Decision) {
+			m.resolve(n.Id, decisionOutcome(decision))
+		})
+		if s.ReportsDeliveries() {
+			m.reportsDeliveries = true
+			s.AddDeliveryHandler(func(n notification.Notification, err error) {
+				m.resolve(n.Id, err)
+			})
+		}
+	}
+
+	return m
+}
+
+// decisionOutcome returns the outcome of an attempt that a stage of the member decided on.
+func decisionOutcome(decision Decision) error {
+	switch decision {
+	case DecisionGrouped, DecisionDelayed:
+		// The member delivers the notification later.
+		return nil
+	case DecisionDuplicate:
+		// The member already delivered the notification, so the next one must not repeat it.
+		return nil
+	case DecisionShortCircuited, DecisionRequeued, DecisionDeadLettered:
+		// The circuit breaker of the member holds the notification and delivers it once the
+		// breaker closes, so passing it on would deliver it twice.
+		return nil
+	case DecisionUnmatched, DecisionBelowMinSeverity:
+		return errFailoverDeclined
+	default:
+		return fmt.Errorf("notification was %s", decision)
+	}
+}
+
+// begin starts an attempt to deliver the notification with the given id.
+func (m *failoverMember) begin(id string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.attemptId = id
+	// Discard an outcome that arrived after an earlier attempt ended.
+	select {
+	case <-m.outcomes:
+	default:
+	}
+}
+
+func (m *failoverMember) end() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.attemptId = ""
+}
+
+// resolve ends the attempt with err, if the notification with the given id is being attempted.
+func (m *failoverMember) resolve(id string, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if id == "" || id != m.attemptId {
+		return
+	}
+	m.attemptId = ""
+	select {
+	case m.outcomes <- err:
+	default:
+	}
+}
+
+func (m *failoverMember) healthy(now time.Time) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return !now.Before(m.unhealthyUntil)
+}
+
+// shortCircuits reports whether the circuit breaker of the member holds notifications back.
+func (m *failoverMember) shortCircuits() bool {
+	return m.sender != nil && m.sender.circuitBreaker != nil && m.sender.circuitBreaker.shortCircuits()
+}
+
+func (m *failoverMember) markFailed(err error, until time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.unhealthyUntil = until
+	m.lastErr = err
+}
+
+// failoverSenderImpl delivers every notification to the first member that succeeds. A member
+// that fails is skipped for the cooldown, unless every member is cooling down. A member whose
+// stages decline a notification, for instance because it does not match, is skipped without
+// being marked as failed.
+type failoverSenderImpl struct {
+	id     string
+	logger *slog.Logger
+	// ids are the ids of the members, which are resolved by setMembers.
+	ids            []string
+	members        []*failoverMember
+	cooldown       time.Duration
+	attemptTimeout time.Duration
+	now            func() time.Time
+	onDelivery     DeliveryHandler
+}
+
+func (fsi *failoverSenderImpl) GetId() string {
+	return fsi.id
+}
+
+func (fsi *failoverSenderImpl) GetLogger() *slog.Logger {
+	return fsi.logger
+}
+
+func (fsi *failoverSenderImpl) SetLogger(logger *slog.Logger) {
+	fsi.logger = logger
+}
+
+func (fsi *failoverSenderImpl) SetDeliveryHandler(handler DeliveryHandler) {
+	fsi.onDelivery = handler
+}
+
+func (fsi *failoverSenderImpl) memberIds() []string {
+	return fsi.ids
+}
+
+func (fsi *failoverSenderImpl) setMembers(members []Member) {
+	fsi.members = make([]*failoverMember, 0, len(members))
+	for _, member := range members {
+		fsi.members = append(fsi.members, newFailoverMember(member))
+	}
+}
+
+func (fsi *failoverSenderImpl) Start(inputCh <-chan notification.Notification, done <-chan struct{}) <-chan error {
+	retCh := make(chan error)
+
+	go func() {
+		defer close(retCh)
+
+		// Members are supervised as senders of their own, so the failover sender only hands
+		// notifications to them.
+		if len(fsi.members) != len(fsi.ids) {
+			retCh <- errFailoverMembersUnset
+			return
+		}
+
+		for {
+			select {
+			case n, ok := <-inputCh:
+				if !ok {
+					inputCh = nil
+					continue
+				}
+				if err := fsi.deliver(n, done); errors.Is(err, errFailoverStopped) {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return retCh
+}
+
+// deliver tries the healthy members in order, then the cooling down ones, until one succeeds.
+// Members whose circuit breaker is open come last, since they would only hold the notification
+// until the breaker closes.
+func (fsi *failoverSenderImpl) deliver(n notification.Notification, done <-chan struct{}) error {
+	// Outcomes reported by members are told apart by the id of their notification.
+	if n.Id == "" {
+		n.Id = notification.NewId()
+	}
+
+	now := fsi.now()
+	order := make([]int, 0, len(fsi.members))
+	shortCircuiting := make([]int, 0)
+	for i, m := range fsi.members {
+		if m.shortCircuits() {
+			shortCircuiting = append(shortCircuiting, i)
+		} else if m.healthy(now) {
+			order = append(order, i)
+		}
+	}
+	for i, m := range fsi.members {
+		if !m.healthy(now) && !slices.Contains(shortCircuiting, i) {
+			order = append(order, i)
+		}
+	}
+	order = append(order, shortCircuiting...)
+
+	var lastErr error
+	for _, i := range order {
+		m := fsi.members[i]
+
+		err := fsi.attempt(m, n, done)
+		if err == nil {
+			if fsi.onDelivery != nil {
+				fsi.onDelivery(n, nil)
+			}
+			return nil
+		}
+		if errors.Is(err, errFailoverStopped) {
+			return err
+		}
+		if errors.Is(err, errFailoverDeclined) {
+			fsi.GetLogger().Debug("Failover member declined notification", "member_id", m.id, "title", n.Title)
+			continue
+		}
+
+		fsi.GetLogger().Warn("Failover member failed", "member_id", m.id, "title", n.Title, "err", err)
+		m.markFailed(err, fsi.now().Add(fsi.cooldown))
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		fsi.GetLogger().Info("Every failover member declined notification", "title", n.Title)
+		return nil
+	}
+
+	fsi.GetLogger().Error("Every failover member failed", "title", n.Title, "err", lastErr)
+	if fsi.onDelivery != nil {
+		fsi.onDelivery(n, lastErr)
+	}
+	return lastErr
+}
+
+// attempt hands n to one member and waits for the outcome it reports.
+func (fsi *failoverSenderImpl) attempt(m *failoverMember, n notification.Notification, done <-chan struct{}) error {
+	m.begin(n.Id)
+	defer m.end()
+
+	timer := time.NewTimer(fsi.attemptTimeout)
+	defer timer.Stop()
+
+	select {
+	case m.supervised.GetChannel() <- n:
+	case <-timer.C:
+		return errFailoverAttemptTimeout
+	case <-done:
+		return errFailoverStopped
+	}
+
+	if !m.reportsDeliveries {
+		return nil
+	}
+
+	select {
+	case err := <-m.outcomes:
+		return err
+	case <-timer.C:
+		return errFailoverAttemptTimeout
+	case <-done:
+		return errFailoverStopped
+	}
+}
+
+func (fsi *failoverSenderImpl) status() map[string]any {
+	now := fsi.now()
+	members := make([]map[string]any, 0, len(fsi.members))
+	for _, m := range fsi.members {
+		m.lock.Lock()
+		member := map[string]any{
+			"id":      m.id,
+			"healthy": !now.Before(m.unhealthyUntil),
+		}
+		if now.Before(m.unhealthyUntil) {
+			member["unhealthyUntil"] = m.unhealthyUntil
+		}
+		if m.lastErr != nil {
+			member["lastError"] = m.lastErr.Error()
+		}
+		m.lock.Unlock()
+
+		members = append(members, member)
+	}
+
+	return map[string]any{"members": members}
+}
@@ -0,0 +1,323 @@
+package sender
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/abstraction"
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/test_util"
+)
+
+// recordingSenderImpl reports every notification as failed while fail is set.
+type recordingSenderImpl struct {
+	id         string
+	logger     *slog.Logger
+	onDelivery DeliveryHandler
+
+	lock      sync.Mutex
+	fail      bool
+	delivered []string
+}
+
+func (rsi *recordingSenderImpl) GetId() string                        { return rsi.id }
+func (rsi *recordingSenderImpl) GetLogger() *slog.Logger              { return rsi.logger }
+func (rsi *recordingSenderImpl) SetLogger(logger *slog.Logger)        { rsi.logger = logger }
+func (rsi *recordingSenderImpl) SetDeliveryHandler(h DeliveryHandler) { rsi.onDelivery = h }
+
+func (rsi *recordingSenderImpl) setFail(fail bool) {
+	rsi.lock.Lock()
+	defer rsi.lock.Unlock()
+	rsi.fail = fail
+}
+
+func (rsi *recordingSenderImpl) titles() []string {
+	rsi.lock.Lock()
+	defer rsi.lock.Unlock()
+	return append([]string(nil), rsi.delivered...)
+}
+
+func (rsi *recordingSenderImpl) Start(inputCh <-chan notification.Notification, done <-chan struct{}) <-chan error {
+	retCh := make(chan error)
+
+	go func() {
+		defer close(retCh)
+		for {
+			select {
+			case n := <-inputCh:
+				rsi.lock.Lock()
+				var err error
+				if rsi.fail {
+					err = errors.New("failed")
+				} else {
+					rsi.delivered = append(rsi.delivered, n.Title)
+				}
+				rsi.lock.Unlock()
+				rsi.onDelivery(n, err)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return retCh
+}
+
+// newTestFailoverSender builds a failover sender whose members are recording senders supervised
+// like configured senders. configure may set the stages of a member before it is started.
+func newTestFailoverSender(t *testing.T, cooldown string, configure func(id string, s *Sender)) (*Sender, map[string]*recordingSenderImpl) {
+	t.Helper()
+
+	component, err := FailoverSenderBuilder("failover", test_util.MustPropertiesNode(t, `
+cooldown: `+cooldown+`
+attemptTimeout: 1s
+members: [primary, secondary]
+`))
+	if err != nil {
+		t.Fatalf("FailoverSenderBuilder returned error: %v", err)
+	}
+	component.SetLogger(slog.Default())
+	s := component.(*Sender)
+
+	impls := make(map[string]*recordingSenderImpl)
+	members := make([]Member, 0)
+	for _, id := range s.MemberIds() {
+		impls[id] = &recordingSenderImpl{id: id, logger: slog.Default()}
+		member := NewSender(impls[id])
+		if configure != nil {
+			configure(id, member)
+		}
+		members = append(members, Member{Component: member, Supervised: abstraction.NewAutonomousChannelComponent(member)})
+	}
+	s.SetMembers(members)
+
+	for _, member := range members {
+		stopped := member.Supervised.Start()
+		t.Cleanup(func() {
+			member.Supervised.Shutdown()
+			<-stopped
+		})
+	}
+
+	return s, impls
+}
+
+func TestFailoverSenderDeliversToNextMemberAndSkipsFailedOne(t *testing.T) {
+	s, impls := newTestFailoverSender(t, "1m", nil)
+	fsi := s.impl.(*failoverSenderImpl)
+	now := time.Now()
+	fsi.now = func() time.Time { return now }
+
+	outcomes := make(chan error, 4)
+	s.AddDeliveryHandler(func(n notification.Notification, err error) { outcomes <- err })
+
+	ch := make(chan notification.Notification)
+	done := make(chan struct{})
+	errCh := s.Start(ch, done)
+	defer func() {
+		close(done)
+		<-errCh
+	}()
+
+	impls["primary"].setFail(true)
+	ch <- notification.Notification{Title: "first"}
+	if err := <-outcomes; err != nil {
+		t.Fatalf("first delivery failed: %v", err)
+	}
+
+	impls["primary"].setFail(false)
+	ch <- notification.Notification{Title: "second"}
+	if err := <-outcomes; err != nil {
+		t.Fatalf("second delivery failed: %v", err)
+	}
+
+	if got := impls["primary"].titles(); len(got) != 0 {
+		t.Fatalf("primary delivered %v during cooldown, want nothing", got)
+	}
+	if got := impls["secondary"].titles(); len(got) != 2 {
+		t.Fatalf("secondary delivered %v, want [first second]", got)
+	}
+
+	now = now.Add(time.Minute)
+	ch <- notification.Notification{Title: "third"}
+	if err := <-outcomes; err != nil {
+		t.Fatalf("third delivery failed: %v", err)
+	}
+	if got := impls["primary"].titles(); len(got) != 1 || got[0] != "third" {
+		t.Fatalf("primary delivered %v after cooldown, want [third]", got)
+	}
+}
+
+func TestFailoverSenderReportsFailureWhenEveryMemberFails(t *testing.T) {
+	s, impls := newTestFailoverSender(t, "1m", nil)
+
+	outcomes := make(chan error, 1)
+	s.AddDeliveryHandler(func(n notification.Notification, err error) { outcomes <- err })
+
+	ch := make(chan notification.Notification)
+	done := make(chan struct{})
+	errCh := s.Start(ch, done)
+	defer func() {
+		close(done)
+		<-errCh
+	}()
+
+	impls["primary"].setFail(true)
+	impls["secondary"].setFail(true)
+	ch <- notification.Notification{Title: "lost"}
+	if err := <-outcomes; err == nil {
+		t.Fatal("delivery unexpectedly succeeded")
+	}
+}
+
+func TestFailoverSenderSkipsMemberThatDeclinesWithoutCooldown(t *testing.T) {
+	s, impls := newTestFailoverSender(t, "1m", func(id string, member *Sender) {
+		if id == "primary" {
+			member.SetMinSeverity(notification.SeverityError)
+		}
+	})
+
+	outcomes := make(chan error, 2)
+	s.AddDeliveryHandler(func(n notification.Notification, err error) { outcomes <- err })
+
+	ch := make(chan notification.Notification)
+	done := make(chan struct{})
+	errCh := s.Start(ch, done)
+	defer func() {
+		close(done)
+		<-errCh
+	}()
+
+	ch <- notification.Notification{Title: "minor", Severity: notification.SeverityInfo}
+	if err := <-outcomes; err != nil {
+		t.Fatalf("minor delivery failed: %v", err)
+	}
+	ch <- notification.Notification{Title: "major", Severity: notification.SeverityError}
+	if err := <-outcomes; err != nil {
+		t.Fatalf("major delivery failed: %v", err)
+	}
+
+	if got := impls["primary"].titles(); len(got) != 1 || got[0] != "major" {
+		t.Fatalf("primary delivered %v, want [major]", got)
+	}
+	if got := impls["secondary"].titles(); len(got) != 1 || got[0] != "minor" {
+		t.Fatalf("secondary delivered %v, want [minor]", got)
+	}
+}
+
+func TestFailoverSenderDoesNotPassDuplicateToNextMember(t *testing.T) {
+	s, impls := newTestFailoverSender(t, "1m", func(id string, member *Sender) {
+		if id == "primary" {
+			member.SetDedup(config.DedupConfig{Window: time.Minute})
+		}
+	})
+
+	outcomes := make(chan error, 2)
+	s.AddDeliveryHandler(func(n notification.Notification, err error) { outcomes <- err })
+
+	ch := make(chan notification.Notification)
+	done := make(chan struct{})
+	errCh := s.Start(ch, done)
+	defer func() {
+		close(done)
+		<-errCh
+	}()
+
+	for _, id := range []string{"1", "2"} {
+		ch <- notification.Notification{Id: id, Title: "disk full"}
+		if err := <-outcomes; err != nil {
+			t.Fatalf("delivery of %s failed: %v", id, err)
+		}
+	}
+
+	if got := impls["primary"].titles(); len(got) != 1 {
+		t.Fatalf("primary delivered %v, want [disk full]", got)
+	}
+	if got := impls["secondary"].titles(); len(got) != 0 {
+		t.Fatalf("secondary delivered %v, want nothing", got)
+	}
+}
+
+// openCircuitBreaker sets a circuit breaker on member and opens it for an hour.
+func openCircuitBreaker(member *Sender) {
+	member.SetCircuitBreaker(config.CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Hour, BufferSize: 10})
+	member.circuitBreaker.recordAt(errors.New("failed"), time.Now())
+}
+
+func TestFailoverSenderSkipsMemberWithOpenCircuitBreaker(t *testing.T) {
+	s, impls := newTestFailoverSender(t, "1m", func(id string, member *Sender) {
+		if id == "primary" {
+			openCircuitBreaker(member)
+		}
+	})
+
+	outcomes := make(chan error, 1)
+	s.AddDeliveryHandler(func(n notification.Notification, err error) { outcomes <- err })
+
+	ch := make(chan notification.Notification)
+	done := make(chan struct{})
+	errCh := s.Start(ch, done)
+	defer func() {
+		close(done)
+		<-errCh
+	}()
+
+	ch <- notification.Notification{Title: "disk full"}
+	if err := <-outcomes; err != nil {
+		t.Fatalf("delivery failed: %v", err)
+	}
+
+	if got := impls["secondary"].titles(); len(got) != 1 {
+		t.Fatalf("secondary delivered %v, want [disk full]", got)
+	}
+	if got := impls["primary"].titles(); len(got) != 0 {
+		t.Fatalf("primary delivered %v while its breaker is open", got)
+	}
+}
+
+func TestFailoverSenderLeavesNotificationHeldByCircuitBreaker(t *testing.T) {
+	s, impls := newTestFailoverSender(t, "1m", func(id string, member *Sender) {
+		openCircuitBreaker(member)
+	})
+	members := s.impl.(*failoverSenderImpl).members
+
+	outcomes := make(chan error, 1)
+	s.AddDeliveryHandler(func(n notification.Notification, err error) { outcomes <- err })
+
+	ch := make(chan notification.Notification)
+	done := make(chan struct{})
+	errCh := s.Start(ch, done)
+	defer func() {
+		close(done)
+		<-errCh
+	}()
+
+	ch <- notification.Notification{Title: "disk full"}
+	if err := <-outcomes; err != nil {
+		t.Fatalf("delivery failed: %v", err)
+	}
+
+	// The first member holds the notification, so the second one must not be handed it too.
+	if got := members[0].sender.Pending(); len(got) != 1 {
+		t.Fatalf("primary holds %v, want [disk full]", got)
+	}
+	if got := members[1].sender.Pending(); len(got) != 0 {
+		t.Fatalf("secondary holds %v, want nothing", got)
+	}
+	if got := append(impls["primary"].titles(), impls["secondary"].titles()...); len(got) != 0 {
+		t.Fatalf("members delivered %v while their breakers are open", got)
+	}
+}
+
+func TestFailoverSenderPropertiesRejectDuplicatedMember(t *testing.T) {
+	properties := NewFailoverSenderProperties()
+	properties.Members = []string{"primary", "primary"}
+
+	if err := properties.Validate(); err == nil {
+		t.Fatal("Validate unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,200 @@
+package sender
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+// groupStage batches notifications that share the same values for the configured label keys
+// and forwards each batch as a single digest notification.
+type groupStage struct {
+	by            []string
+	groupWait     time.Duration
+	groupInterval time.Duration
+	groups        map[string]*notificationGroup
+	decide        DecisionHandler
+	// flushCh receives flush requests, after which groups are sent without waiting.
+	flushCh  chan chan struct{}
+	flushing bool
+}
+
+type notificationGroup struct {
+	labels  map[string]string
+	members []notification.Notification
+	flushAt time.Time
+}
+
+func newGroupStage(group config.GroupConfig) *groupStage {
+	return &groupStage{
+		by:            group.By,
+		groupWait:     group.GroupWait,
+		groupInterval: group.GroupInterval,
+		groups:        make(map[string]*notificationGroup),
+		flushCh:       make(chan chan struct{}),
+	}
+}
+
+func (g *groupStage) run(in <-chan notification.Notification, out chan<- notification.Notification, stop <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	var flushed chan struct{}
+	for {
+		if flushed != nil && len(g.pending()) == 0 {
+			close(flushed)
+			flushed = nil
+		}
+
+		// Groups pending from a previous execution are flushed according to their own schedule.
+		var timerCh <-chan time.Time
+		if next, ok := g.nextFlushAt(); ok {
+			timer.Reset(time.Until(next))
+			timerCh = timer.C
+		} else {
+			timer.Stop()
+		}
+
+		select {
+		case <-stop:
+			return
+		case n, ok := <-in:
+			if !ok {
+				in = nil
+				continue
+			}
+			g.add(n, time.Now())
+		case flushed = <-g.flushCh:
+			g.flushing = true
+			now := time.Now()
+			for _, group := range g.groups {
+				group.flushAt = now
+			}
+		case <-timerCh:
+			now := time.Now()
+			for _, key := range g.dueKeys(now) {
+				group := g.groups[key]
+				if len(group.members) == 0 {
+					// Nothing arrived during the last interval, so the group is finished.
+					delete(g.groups, key)
+					continue
+				}
+
+				select {
+				case out <- group.digest():
+				case <-stop:
+					// Keep the members so that they are sent after restart.
+					return
+				}
+				group.members = nil
+				group.flushAt = now.Add(g.groupInterval)
+			}
+		}
+	}
+}
+
+func (g *groupStage) add(n notification.Notification, now time.Time) {
+	labels := make(map[string]string, len(g.by))
+	values := make([]string, 0, len(g.by))
+	for _, key := range g.by {
+		value, ok := n.Labels[key]
+		if !ok {
+			// Unquoted, so that a missing label differs from any value including "".
+			values = append(values, "-")
+			continue
+		}
+		labels[key] = value
+		values = append(values, strconv.Quote(value))
+	}
+	key := strings.Join(values, ",")
+
+	group, ok := g.groups[key]
+	if !ok {
+		group = &notificationGroup{
+			labels:  labels,
+			flushAt: now.Add(g.groupWait),
+		}
+		g.groups[key] = group
+	}
+
+	group.members = append(group.members, n)
+	if g.flushing {
+		group.flushAt = now
+	}
+	g.decide.report(n, DecisionGrouped)
+}
+
+func (g *groupStage) nextFlushAt() (time.Time, bool) {
+	var next time.Time
+	found := false
+	for _, group := range g.groups {
+		if !found || group.flushAt.Before(next) {
+			next = group.flushAt
+			found = true
+		}
+	}
+	return next, found
+}
+
+func (g *groupStage) dueKeys(now time.Time) []string {
+	keys := make([]string, 0)
+	for key, group := range g.groups {
+		if !group.flushAt.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// digest aggregates the members into one notification. A group with a single member is
+// delivered unchanged.
+func (ng *notificationGroup) digest() notification.Notification {
+	if len(ng.members) == 1 {
+		return ng.members[0]
+	}
+
+	first := ng.members[0]
+	severity := first.Severity
+	source := first.NotificationSource
+	lines := make([]string, 0, len(ng.members))
+	for _, member := range ng.members {
+		severity = max(severity, member.Severity)
+		if member.NotificationSource != source {
+			source = ""
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %s", member.Title, member.Message))
+	}
+
+	labels := make(map[string]string, len(ng.labels))
+	for key, value := range ng.labels {
+		labels[key] = value
+	}
+
+	return notification.Notification{
+		Title:              fmt.Sprintf("[%d] %s", len(ng.members), first.Title),
+		Severity:           severity,
+		Message:            strings.Join(lines, "\n"),
+		NotificationSource: source,
+		Labels:             labels,
+		Group: &notification.Group{
+			Labels:  ng.labels,
+			Members: slices.Clone(ng.members),
+		},
+	}
+}
+
+// pending returns the members of the groups that have not been sent yet. It must not be called
+// while the stage is running.
+func (g *groupStage) pending() []notification.Notification {
+	pending := make([]notification.Notification, 0)
+	for _, group := range g.groups {
+		pending = append(pending, group.members...)
+	}
+	return pending
+}
@@ -0,0 +1,156 @@
+package sender
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+func TestGroupStageSendsDigestAfterGroupWait(t *testing.T) {
+	stage := newGroupStage(config.GroupConfig{
+		By:            []string{"env"},
+		GroupWait:     50 * time.Millisecond,
+		GroupInterval: time.Hour,
+	})
+
+	in := make(chan notification.Notification)
+	out := make(chan notification.Notification)
+	stop := make(chan struct{})
+	defer close(stop)
+	go stage.run(in, out, stop)
+
+	in <- notification.Notification{Title: "disk full", Message: "host-1", Severity: notification.SeverityWarning, NotificationSource: "monitor", Labels: map[string]string{"env": "prod", "host": "1"}}
+	in <- notification.Notification{Title: "disk full", Message: "host-2", Severity: notification.SeverityError, NotificationSource: "monitor", Labels: map[string]string{"env": "prod", "host": "2"}}
+	in <- notification.Notification{Title: "disk full", Message: "host-3", Severity: notification.SeverityInfo, NotificationSource: "monitor", Labels: map[string]string{"env": "stg", "host": "3"}}
+
+	digests := map[string]notification.Notification{}
+	for range 2 {
+		select {
+		case n := <-out:
+			digests[n.Labels["env"]] = n
+		case <-time.After(time.Second):
+			t.Fatal("digest was not sent")
+		}
+	}
+
+	prod := digests["prod"]
+	if prod.Title != "[2] disk full" {
+		t.Fatalf("Title = %q, want %q", prod.Title, "[2] disk full")
+	}
+	if prod.Message != "- disk full: host-1\n- disk full: host-2" {
+		t.Fatalf("Message = %q", prod.Message)
+	}
+	if prod.Severity != notification.SeverityError {
+		t.Fatalf("Severity = %v, want %v", prod.Severity, notification.SeverityError)
+	}
+	if prod.NotificationSource != "monitor" {
+		t.Fatalf("NotificationSource = %q, want %q", prod.NotificationSource, "monitor")
+	}
+	if prod.Group == nil || len(prod.Group.Members) != 2 {
+		t.Fatalf("Group = %+v, want 2 members", prod.Group)
+	}
+
+	stg := digests["stg"]
+	if stg.Message != "host-3" || stg.Group != nil {
+		t.Fatalf("single member group = %+v, want original notification", stg)
+	}
+}
+
+func TestGroupStageSeparatesMissingLabelFromEmptyValue(t *testing.T) {
+	stage := newGroupStage(config.GroupConfig{
+		By:            []string{"env"},
+		GroupWait:     50 * time.Millisecond,
+		GroupInterval: time.Hour,
+	})
+
+	in := make(chan notification.Notification)
+	out := make(chan notification.Notification)
+	stop := make(chan struct{})
+	defer close(stop)
+	go stage.run(in, out, stop)
+
+	in <- notification.Notification{Title: "empty", Labels: map[string]string{"env": ""}}
+	in <- notification.Notification{Title: "missing"}
+
+	titles := map[string]bool{}
+	for range 2 {
+		select {
+		case n := <-out:
+			titles[n.Title] = true
+		case <-time.After(time.Second):
+			t.Fatalf("got digests %v, want separate ones for empty and missing", titles)
+		}
+	}
+	if !titles["empty"] || !titles["missing"] {
+		t.Fatalf("got digests %v, want empty and missing", titles)
+	}
+}
+
+func TestGroupStageBatchesDuringGroupInterval(t *testing.T) {
+	stage := newGroupStage(config.GroupConfig{
+		GroupWait:     0,
+		GroupInterval: 100 * time.Millisecond,
+	})
+
+	in := make(chan notification.Notification)
+	out := make(chan notification.Notification)
+	stop := make(chan struct{})
+	defer close(stop)
+	go stage.run(in, out, stop)
+
+	in <- notification.Notification{Title: "first"}
+	select {
+	case n := <-out:
+		if n.Title != "first" {
+			t.Fatalf("Title = %q, want %q", n.Title, "first")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("first notification was not sent")
+	}
+
+	in <- notification.Notification{Title: "second"}
+	in <- notification.Notification{Title: "third"}
+	select {
+	case n := <-out:
+		if n.Title != "[2] second" {
+			t.Fatalf("Title = %q, want %q", n.Title, "[2] second")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("batched notification was not sent")
+	}
+}
+
+func TestGroupStageKeepsPendingMembersAcrossRestart(t *testing.T) {
+	stage := newGroupStage(config.GroupConfig{
+		GroupWait:     50 * time.Millisecond,
+		GroupInterval: time.Hour,
+	})
+
+	in := make(chan notification.Notification)
+	out := make(chan notification.Notification)
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		stage.run(in, out, stop)
+	}()
+
+	in <- notification.Notification{Title: "pending"}
+	close(stop)
+	<-finished
+
+	stop = make(chan struct{})
+	defer close(stop)
+	go stage.run(in, out, stop)
+
+	select {
+	case n := <-out:
+		if n.Title != "pending" {
+			t.Fatalf("Title = %q, want %q", n.Title, "pending")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending notification was not sent after restart")
+	}
+}
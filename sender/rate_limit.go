@@ -0,0 +1,321 @@
+package sender
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+const defaultRateLimitMaxQueued = 100
+
+// rateLimitStage limits the notifications forwarded to a sender with token buckets, one per
+// combination of values for the configured keys.
+type rateLimitStage struct {
+	// rate is the number of tokens added per second.
+	rate      float64
+	burst     float64
+	by        []string
+	policy    string
+	maxQueued int
+	logger    func() *slog.Logger
+	buckets   map[string]*rateLimitBucket
+	decide    DecisionHandler
+	// flushCh receives flush requests, after which queued notifications and summaries are
+	// forwarded without waiting for tokens.
+	flushCh  chan chan struct{}
+	flushing bool
+}
+
+type rateLimitBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	// source and labels identify the bucket in summaries of suppressed notifications.
+	source             string
+	labels             map[string]string
+	queued             []notification.Notification
+	suppressed         int
+	suppressedSeverity notification.Severity
+	lastSuppressedAt   time.Time
+}
+
+func newRateLimitStage(rateLimit config.RateLimitConfig, logger func() *slog.Logger) *rateLimitStage {
+	burst := rateLimit.Burst
+	if burst == 0 {
+		burst = rateLimit.Limit
+	}
+
+	policy := rateLimit.Policy
+	if policy == "" {
+		policy = config.RateLimitPolicyDrop
+	}
+
+	maxQueued := rateLimit.MaxQueued
+	if maxQueued == 0 {
+		maxQueued = defaultRateLimitMaxQueued
+	}
+
+	return &rateLimitStage{
+		rate:      float64(rateLimit.Limit) / rateLimit.Interval.Seconds(),
+		burst:     float64(burst),
+		by:        rateLimit.By,
+		policy:    policy,
+		maxQueued: maxQueued,
+		logger:    logger,
+		buckets:   make(map[string]*rateLimitBucket),
+		flushCh:   make(chan chan struct{}),
+	}
+}
+
+func (rl *rateLimitStage) run(in <-chan notification.Notification, out chan<- notification.Notification, stop <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	var flushed chan struct{}
+	for {
+		if flushed != nil && rl.holdsNothing() {
+			close(flushed)
+			flushed = nil
+		}
+
+		var timerCh <-chan time.Time
+		if next, ok := rl.nextEventAt(); ok {
+			timer.Reset(time.Until(next))
+			timerCh = timer.C
+		} else {
+			timer.Stop()
+		}
+
+		select {
+		case <-stop:
+			return
+		case n, ok := <-in:
+			if !ok {
+				in = nil
+				continue
+			}
+			if !rl.admit(n, time.Now()) {
+				continue
+			}
+			select {
+			case out <- n:
+			case <-stop:
+				return
+			}
+		case flushed = <-rl.flushCh:
+			rl.flushing = true
+		case <-timerCh:
+			for {
+				n, commit, ok := rl.next(time.Now())
+				if !ok {
+					break
+				}
+				select {
+				case out <- n:
+					commit()
+				case <-stop:
+					return
+				}
+			}
+		}
+	}
+}
+
+// admit reports whether n can be forwarded immediately. Otherwise n is handled according to
+// the policy.
+func (rl *rateLimitStage) admit(n notification.Notification, now time.Time) bool {
+	bucket := rl.bucketFor(n, now)
+	bucket.refill(now, rl.rate, rl.burst)
+
+	// Queued notifications keep their order ahead of new arrivals.
+	if len(bucket.queued) == 0 && bucket.tokens >= 1 {
+		bucket.tokens--
+		return true
+	}
+
+	switch rl.policy {
+	case config.RateLimitPolicyDelay:
+		if len(bucket.queued) >= rl.maxQueued {
+			rl.logger().Warn("Rate limit queue is full, notification dropped", "title", n.Title)
+			rl.decide.report(n, DecisionRateLimited)
+			return false
+		}
+		bucket.queued = append(bucket.queued, n)
+		rl.decide.report(n, DecisionDelayed)
+	case config.RateLimitPolicyCollapse:
+		if bucket.suppressed == 0 || n.Severity > bucket.suppressedSeverity {
+			bucket.suppressedSeverity = n.Severity
+		}
+		bucket.suppressed++
+		bucket.lastSuppressedAt = now
+		rl.decide.report(n, DecisionRateLimited)
+	default:
+		rl.logger().Warn("Rate limit exceeded, notification dropped", "title", n.Title)
+		rl.decide.report(n, DecisionRateLimited)
+	}
+
+	return false
+}
+
+// next returns a notification that has become deliverable by now. commit must be called once
+// the notification has been forwarded.
+func (rl *rateLimitStage) next(now time.Time) (notification.Notification, func(), bool) {
+	keys := make([]string, 0, len(rl.buckets))
+	for key := range rl.buckets {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		bucket := rl.buckets[key]
+		bucket.refill(now, rl.rate, rl.burst)
+
+		if len(bucket.queued) > 0 && (bucket.tokens >= 1 || rl.flushing) {
+			return bucket.queued[0], func() {
+				bucket.queued = bucket.queued[1:]
+				bucket.tokens = max(bucket.tokens-1, 0)
+			}, true
+		}
+
+		if bucket.suppressed > 0 && (bucket.tokens >= 1 && !now.Before(rl.floodSubsidedAt(bucket)) || rl.flushing) {
+			return bucket.summary(), func() {
+				bucket.suppressed = 0
+				bucket.tokens = max(bucket.tokens-1, 0)
+			}, true
+		}
+	}
+
+	return notification.Notification{}, nil, false
+}
+
+func (rl *rateLimitStage) nextEventAt() (time.Time, bool) {
+	var next time.Time
+	found := false
+	for _, bucket := range rl.buckets {
+		var at time.Time
+		switch {
+		case len(bucket.queued) > 0:
+			at = rl.tokenAvailableAt(bucket)
+		case bucket.suppressed > 0:
+			at = rl.tokenAvailableAt(bucket)
+			if subsidedAt := rl.floodSubsidedAt(bucket); subsidedAt.After(at) {
+				at = subsidedAt
+			}
+		default:
+			continue
+		}
+		if rl.flushing {
+			at = time.Now()
+		}
+
+		if !found || at.Before(next) {
+			next = at
+			found = true
+		}
+	}
+	return next, found
+}
+
+func (rl *rateLimitStage) tokenAvailableAt(bucket *rateLimitBucket) time.Time {
+	if bucket.tokens >= 1 {
+		return bucket.updatedAt
+	}
+	return bucket.updatedAt.Add(time.Duration((1 - bucket.tokens) / rl.rate * float64(time.Second)))
+}
+
+// floodSubsidedAt is when a flood is considered over: nothing has been suppressed for the
+// time it takes to refill one token.
+func (rl *rateLimitStage) floodSubsidedAt(bucket *rateLimitBucket) time.Time {
+	return bucket.lastSuppressedAt.Add(time.Duration(float64(time.Second) / rl.rate))
+}
+
+func (rl *rateLimitStage) bucketFor(n notification.Notification, now time.Time) *rateLimitBucket {
+	source := ""
+	labels := make(map[string]string)
+	values := make([]string, 0, len(rl.by))
+	for _, key := range rl.by {
+		var value string
+		if key == config.RateLimitByNotificationSource {
+			value = n.NotificationSource
+			source = value
+		} else {
+			value = n.Labels[key]
+			if _, ok := n.Labels[key]; ok {
+				labels[key] = value
+			}
+		}
+		values = append(values, strconv.Quote(value))
+	}
+	key := strings.Join(values, ",")
+
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		rl.forgetIdleBuckets(now)
+		bucket = &rateLimitBucket{
+			tokens:    rl.burst,
+			updatedAt: now,
+			source:    source,
+			labels:    labels,
+		}
+		rl.buckets[key] = bucket
+	}
+	return bucket
+}
+
+// forgetIdleBuckets removes buckets that are back to their initial state, so that buckets for
+// label values that are no longer seen do not accumulate.
+func (rl *rateLimitStage) forgetIdleBuckets(now time.Time) {
+	for key, bucket := range rl.buckets {
+		bucket.refill(now, rl.rate, rl.burst)
+		if len(bucket.queued) == 0 && bucket.suppressed == 0 && bucket.tokens >= rl.burst {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+func (b *rateLimitBucket) refill(now time.Time, rate float64, burst float64) {
+	if now.After(b.updatedAt) {
+		b.tokens = min(burst, b.tokens+now.Sub(b.updatedAt).Seconds()*rate)
+		b.updatedAt = now
+	}
+}
+
+func (b *rateLimitBucket) summary() notification.Notification {
+	labels := make(map[string]string, len(b.labels))
+	for key, value := range b.labels {
+		labels[key] = value
+	}
+
+	return notification.Notification{
+		Title:              fmt.Sprintf("%d notifications suppressed", b.suppressed),
+		Severity:           b.suppressedSeverity,
+		Message:            fmt.Sprintf("%d notifications were suppressed because the rate limit was exceeded", b.suppressed),
+		NotificationSource: b.source,
+		Labels:             labels,
+	}
+}
+
+// holdsNothing reports whether no notification is queued and no summary is due.
+func (rl *rateLimitStage) holdsNothing() bool {
+	for _, bucket := range rl.buckets {
+		if len(bucket.queued) > 0 || bucket.suppressed > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// pending returns the notifications queued by the delay policy. It must not be called while
+// the stage is running.
+func (rl *rateLimitStage) pending() []notification.Notification {
+	pending := make([]notification.Notification, 0)
+	for _, bucket := range rl.buckets {
+		pending = append(pending, bucket.queued...)
+	}
+	return pending
+}
@@ -0,0 +1,118 @@
+package sender
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+func TestRateLimitStageDropsExcess(t *testing.T) {
+	stage := newRateLimitStage(config.RateLimitConfig{
+		Limit:    2,
+		Interval: time.Hour,
+	}, slog.Default)
+
+	now := time.Now()
+	admitted := 0
+	for range 5 {
+		if stage.admit(notification.Notification{Title: "flood"}, now) {
+			admitted++
+		}
+	}
+
+	if admitted != 2 {
+		t.Fatalf("admitted = %d, want 2", admitted)
+	}
+	if _, _, ok := stage.next(now.Add(time.Minute)); ok {
+		t.Fatal("next() returned a notification for drop policy")
+	}
+}
+
+func TestRateLimitStageSeparatesBucketsByKey(t *testing.T) {
+	stage := newRateLimitStage(config.RateLimitConfig{
+		Limit:    1,
+		Interval: time.Hour,
+		By:       []string{config.RateLimitByNotificationSource},
+	}, slog.Default)
+
+	now := time.Now()
+	if !stage.admit(notification.Notification{NotificationSource: "billing"}, now) {
+		t.Fatal("first billing notification was not admitted")
+	}
+	if stage.admit(notification.Notification{NotificationSource: "billing"}, now) {
+		t.Fatal("second billing notification was admitted")
+	}
+	if !stage.admit(notification.Notification{NotificationSource: "payments"}, now) {
+		t.Fatal("first payments notification was not admitted")
+	}
+}
+
+func TestRateLimitStageDelaysExcess(t *testing.T) {
+	stage := newRateLimitStage(config.RateLimitConfig{
+		Limit:    1,
+		Interval: time.Second,
+		Policy:   config.RateLimitPolicyDelay,
+	}, slog.Default)
+
+	now := time.Now()
+	if !stage.admit(notification.Notification{Title: "first"}, now) {
+		t.Fatal("first notification was not admitted")
+	}
+	if stage.admit(notification.Notification{Title: "second"}, now) {
+		t.Fatal("second notification was admitted")
+	}
+	if _, _, ok := stage.next(now); ok {
+		t.Fatal("queued notification was released before a token was available")
+	}
+
+	n, commit, ok := stage.next(now.Add(time.Second))
+	if !ok {
+		t.Fatal("queued notification was not released")
+	}
+	if n.Title != "second" {
+		t.Fatalf("Title = %q, want %q", n.Title, "second")
+	}
+	commit()
+
+	if _, _, ok := stage.next(now.Add(time.Second)); ok {
+		t.Fatal("queued notification was released twice")
+	}
+}
+
+func TestRateLimitStageCollapsesExcessIntoSummary(t *testing.T) {
+	stage := newRateLimitStage(config.RateLimitConfig{
+		Limit:    1,
+		Interval: time.Second,
+		By:       []string{"env"},
+		Policy:   config.RateLimitPolicyCollapse,
+	}, slog.Default)
+
+	now := time.Now()
+	labels := map[string]string{"env": "prod"}
+	stage.admit(notification.Notification{Labels: labels}, now)
+	stage.admit(notification.Notification{Labels: labels, Severity: notification.SeverityWarning}, now)
+	stage.admit(notification.Notification{Labels: labels, Severity: notification.SeverityError}, now.Add(500*time.Millisecond))
+
+	if _, _, ok := stage.next(now.Add(time.Second)); ok {
+		t.Fatal("summary was sent before the flood subsided")
+	}
+
+	summary, commit, ok := stage.next(now.Add(1500 * time.Millisecond))
+	if !ok {
+		t.Fatal("summary was not sent after the flood subsided")
+	}
+	commit()
+
+	if summary.Title != "2 notifications suppressed" {
+		t.Fatalf("Title = %q, want %q", summary.Title, "2 notifications suppressed")
+	}
+	if summary.Severity != notification.SeverityError {
+		t.Fatalf("Severity = %v, want %v", summary.Severity, notification.SeverityError)
+	}
+	if summary.Labels["env"] != "prod" {
+		t.Fatalf("Labels[env] = %q, want %q", summary.Labels["env"], "prod")
+	}
+}
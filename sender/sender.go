@@ -1,20 +1,39 @@
 package sender
 
 import (
+	"context"
 	"log/slog"
 	"sync"
 
+	"github.com/Kotaro7750/notifier/abstraction"
 	"github.com/Kotaro7750/notifier/config"
 	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Sender struct {
-	impl  SenderImpl
-	match MatchCondition
+	impl           SenderImpl
+	match          MatchCondition
+	minSeverity    *notification.Severity
+	dedup          *dedupFilter
+	group          *groupStage
+	rateLimit      *rateLimitStage
+	circuitBreaker *circuitBreakerStage
+	// deliveryHandlers are notified of every outcome reported by impl.
+	deliveryHandlers []DeliveryHandler
+	// decisionHandlers are notified of every notification the stages do not forward as is.
+	decisionHandlers []DecisionHandler
 }
 
 func NewSender(impl SenderImpl) *Sender {
-	return &Sender{impl: impl}
+	s := &Sender{impl: impl}
+	if reporter, ok := impl.(DeliveryReporter); ok {
+		reporter.SetDeliveryHandler(s.handleDelivery)
+	}
+	return s
 }
 
 type SenderImpl interface {
@@ -24,70 +43,315 @@ type SenderImpl interface {
 	Start(inputCh <-chan notification.Notification, done <-chan struct{}) <-chan error
 }
 
+// DeliveryHandler receives the outcome of delivering one notification. err is nil when the
+// notification was delivered.
+type DeliveryHandler func(n notification.Notification, err error)
+
+// DeliveryReporter is implemented by SenderImpls that report the outcome of every notification
+// they deliver. Wrappers that need outcomes, such as the circuit breaker, register a handler.
+type DeliveryReporter interface {
+	SetDeliveryHandler(handler DeliveryHandler)
+}
+
+// Decision is what a sender stage did with a notification it did not forward as is.
+type Decision string
+
+const (
+	// DecisionUnmatched is reported for notifications not matching the match condition.
+	DecisionUnmatched Decision = "unmatched"
+	// DecisionBelowMinSeverity is reported for notifications less severe than minSeverity.
+	DecisionBelowMinSeverity Decision = "below_min_severity"
+	// DecisionDuplicate is reported for notifications suppressed by dedup.
+	DecisionDuplicate Decision = "duplicate"
+	// DecisionGrouped is reported for notifications added to a group. They are delivered as
+	// members of a digest notification.
+	DecisionGrouped Decision = "grouped"
+	// DecisionDelayed is reported for notifications queued by the rate limit.
+	DecisionDelayed Decision = "delayed"
+	// DecisionRateLimited is reported for notifications dropped or collapsed by the rate limit.
+	DecisionRateLimited Decision = "rate_limited"
+	// DecisionShortCircuited is reported for notifications held back by an open circuit breaker.
+	DecisionShortCircuited Decision = "short_circuited"
+	// DecisionRequeued is reported for notifications whose delivery failed and that the circuit
+	// breaker buffered to send again.
+	DecisionRequeued Decision = "requeued"
+	// DecisionDeadLettered is reported for notifications written to the dead letter file.
+	DecisionDeadLettered Decision = "dead_lettered"
+	// DecisionDropped is reported for notifications dropped because the circuit breaker buffer
+	// is full.
+	DecisionDropped Decision = "dropped"
+)
+
+// DecisionHandler receives the decision of a stage about one notification.
+type DecisionHandler func(n notification.Notification, decision Decision)
+
+// report calls the handler, if any. Stages hold a DecisionHandler that is nil until the
+// Sender wires them.
+func (h DecisionHandler) report(n notification.Notification, decision Decision) {
+	if h != nil {
+		h(n, decision)
+	}
+}
+
+// implStatusReporter is implemented by SenderImpls that add their own state to Sender.Status.
+type implStatusReporter interface {
+	status() map[string]any
+}
+
+// implMemberDelegator is implemented by SenderImpls that deliver through other configured
+// senders, such as the failover sender.
+type implMemberDelegator interface {
+	memberIds() []string
+	setMembers(members []Member)
+}
+
+// Member is a configured sender that another sender delivers through.
+type Member struct {
+	// Component is the sender as built, whose decisions and deliveries are observed.
+	Component abstraction.AbstractChannelComponent
+	// Supervised runs Component, and receives the notifications handed to the member.
+	Supervised *abstraction.AutonomousChannelComponent
+}
+
+// requestFlush asks a running stage through flushCh to forward the notifications it holds back,
+// and waits until the stage holds none it can forward.
+func requestFlush(ctx context.Context, flushCh chan<- chan struct{}) error {
+	flushed := make(chan struct{})
+	select {
+	case flushCh <- flushed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stage transforms the notification stream between the supervisor channel and the SenderImpl.
+// run reads notifications from in and writes results to out until stop is closed. The caller
+// owns out and closes it after run returns. State that must survive a restart of the sender
+// is kept on the stage itself, since run is invoked once per execution.
+type stage interface {
+	run(in <-chan notification.Notification, out chan<- notification.Notification, stop <-chan struct{})
+}
+
+// transformStage forwards the notification it returns for each input, or nothing when it
+// returns false.
+type transformStage func(n notification.Notification) (notification.Notification, bool)
+
+func (f transformStage) run(in <-chan notification.Notification, out chan<- notification.Notification, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case n, ok := <-in:
+			if !ok {
+				// Keep out open until stop so the wrapped sender is not told to finish early.
+				in = nil
+				continue
+			}
+			n, ok = f(n)
+			if !ok {
+				continue
+			}
+			select {
+			case out <- n:
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+// startSendSpan starts the span tracing the delivery of n by the SenderImpl with the given id.
+// SenderImpls end it with tracing.End once the outcome is known.
+func startSendSpan(n notification.Notification, senderId string) (context.Context, trace.Span) {
+	return tracing.Start(n, "notifier.send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("notifier.sender.id", senderId),
+			attribute.String("notifier.notification.title", n.Title),
+		),
+	)
+}
+
+// isRouted reports whether n was delivered to the sender by a route choosing its senders, such
+// as an escalation step, rather than by the match condition of the sender. Such notifications
+// bypass match and minSeverity.
+func isRouted(n notification.Notification) bool {
+	_, ok := n.Labels[RouteLabel]
+	return ok
+}
+
+func (s *Sender) stages() []stage {
+	stages := make([]stage, 0)
+
+	if s.match.hasConditions() {
+		stages = append(stages, transformStage(func(n notification.Notification) (notification.Notification, bool) {
+			if isRouted(n) {
+				return n, true
+			}
+
+			_, span := tracing.Start(n, "notifier.match", trace.WithAttributes(attribute.String("notifier.sender.id", s.GetId())))
+			defer span.End()
+
+			matched := s.match.IsMatched(n)
+			span.SetAttributes(attribute.Bool("notifier.matched", matched))
+			if !matched {
+				s.decide(n, DecisionUnmatched)
+			}
+			return n, matched
+		}))
+	}
+
+	if s.minSeverity != nil {
+		minSeverity := *s.minSeverity
+		stages = append(stages, transformStage(func(n notification.Notification) (notification.Notification, bool) {
+			if n.Severity < minSeverity && !isRouted(n) {
+				s.decide(n, DecisionBelowMinSeverity)
+				return n, false
+			}
+			return n, true
+		}))
+	}
+
+	if s.dedup != nil {
+		stages = append(stages, transformStage(func(n notification.Notification) (notification.Notification, bool) {
+			filtered, ok := s.dedup.filter(n)
+			if !ok {
+				s.decide(n, DecisionDuplicate)
+			}
+			return filtered, ok
+		}))
+	}
+
+	if s.group != nil {
+		stages = append(stages, s.group)
+	}
+
+	if s.rateLimit != nil {
+		stages = append(stages, s.rateLimit)
+	}
+
+	if s.circuitBreaker != nil {
+		stages = append(stages, s.circuitBreaker)
+	}
+
+	return stages
+}
+
 func (s *Sender) Start(inputCh chan notification.Notification, done <-chan struct{}) <-chan error {
-	if !s.match.hasConditions() {
+	stages := s.stages()
+	if len(stages) == 0 {
 		return s.impl.Start(inputCh, done)
 	}
 
-	filteredCh := make(chan notification.Notification)
-	implErrCh := s.impl.Start(filteredCh, done)
+	// The wrapper owns every channel between stages and closes each of them exactly once
+	// when the stages stop forwarding.
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	var ch <-chan notification.Notification = inputCh
+	for _, st := range stages {
+		out := make(chan notification.Notification)
+		wg.Add(1)
+		go func(in <-chan notification.Notification) {
+			defer wg.Done()
+			defer close(out)
+			st.run(in, out, stopCh)
+		}(ch)
+		ch = out
+	}
+
+	implErrCh := s.impl.Start(ch, done)
 	retCh := make(chan error)
 
 	go func() {
 		defer close(retCh)
 
-		// The wrapper owns filteredCh and closes it exactly once when it stops forwarding.
-		closeFilteredCh := sync.OnceFunc(func() {
-			close(filteredCh)
-		})
-
+		// Stages watch stopCh while sending, so a stage cannot block forever after the
+		// wrapped sender has already stopped consuming its input.
 		finishWithImplResult := func(err error, ok bool) {
-			closeFilteredCh()
+			// Senders that report deliveries have already reported this failure.
+			if _, reportsDeliveries := s.impl.(DeliveryReporter); err != nil && !reportsDeliveries && s.circuitBreaker != nil {
+				s.circuitBreaker.record(err)
+			}
+			close(stopCh)
+			wg.Wait()
 			if ok {
 				retCh <- err
 			}
 		}
 
-		// Do not report wrapper shutdown until the wrapped sender has actually stopped.
-		waitForImplStop := func() {
+		select {
+		case <-done:
+			// Do not report wrapper shutdown until the wrapped sender has actually stopped.
 			err, ok := <-implErrCh
 			finishWithImplResult(err, ok)
-		}
-
-		for {
-			select {
-			case <-done:
-				waitForImplStop()
-				return
-			case err, ok := <-implErrCh:
-				finishWithImplResult(err, ok)
-				return
-			case n, ok := <-inputCh:
-				if !ok {
-					waitForImplStop()
-					return
-				}
-				if !s.match.IsMatched(n) {
-					continue
-				}
-				// Also watch implErrCh here so a matched send cannot block forever after the
-				// wrapped sender has already stopped consuming filteredCh.
-				select {
-				case filteredCh <- n:
-				case err, ok := <-implErrCh:
-					finishWithImplResult(err, ok)
-					return
-				case <-done:
-					waitForImplStop()
-					return
-				}
-			}
+		case err, ok := <-implErrCh:
+			finishWithImplResult(err, ok)
 		}
 	}()
 
 	return retCh
 }
 
+func (s *Sender) handleDelivery(n notification.Notification, err error) {
+	// A failed notification taken back by the circuit breaker is not reported as failed, since
+	// the decision of the breaker says whether it is sent again, dead-lettered or dropped.
+	if s.circuitBreaker != nil && s.circuitBreaker.recordDelivery(n, err) {
+		return
+	}
+	for _, handler := range s.deliveryHandlers {
+		handler(n, err)
+	}
+}
+
+func (s *Sender) decide(n notification.Notification, decision Decision) {
+	for _, handler := range s.decisionHandlers {
+		handler(n, decision)
+	}
+}
+
+// AddDecisionHandler registers handler for the decisions of the stages. It must be called
+// before the sender is started.
+func (s *Sender) AddDecisionHandler(handler DecisionHandler) {
+	s.decisionHandlers = append(s.decisionHandlers, handler)
+}
+
+// ReportsDeliveries reports whether the wrapped SenderImpl reports the outcome of every
+// notification, so that handlers added by AddDeliveryHandler are called.
+func (s *Sender) ReportsDeliveries() bool {
+	_, ok := s.impl.(DeliveryReporter)
+	return ok
+}
+
+// AddDeliveryHandler registers handler for the delivery outcomes reported by the wrapped
+// SenderImpl. It must be called before the sender is started.
+func (s *Sender) AddDeliveryHandler(handler DeliveryHandler) {
+	s.deliveryHandlers = append(s.deliveryHandlers, handler)
+}
+
+// MemberIds returns the ids of the configured senders the wrapped SenderImpl delivers through,
+// if any. They are resolved by SetMembers.
+func (s *Sender) MemberIds() []string {
+	if delegator, ok := s.impl.(implMemberDelegator); ok {
+		return delegator.memberIds()
+	}
+	return nil
+}
+
+// SetMembers hands the senders listed by MemberIds, in the same order, to the wrapped
+// SenderImpl. It must be called before the sender is started.
+func (s *Sender) SetMembers(members []Member) {
+	if delegator, ok := s.impl.(implMemberDelegator); ok {
+		delegator.setMembers(members)
+	}
+}
+
 func (s *Sender) GetLogger() *slog.Logger {
 	return s.impl.GetLogger()
 }
@@ -103,3 +367,90 @@ func (s *Sender) GetId() string {
 func (s *Sender) SetMatch(match config.MetadataCondition) {
 	s.match = NewMatchCondition(match)
 }
+
+func (s *Sender) SetMinSeverity(minSeverity notification.Severity) {
+	s.minSeverity = &minSeverity
+}
+
+func (s *Sender) SetGroup(group config.GroupConfig) {
+	s.group = newGroupStage(group)
+	s.group.decide = s.decide
+}
+
+func (s *Sender) SetRateLimit(rateLimit config.RateLimitConfig) {
+	s.rateLimit = newRateLimitStage(rateLimit, s.impl.GetLogger)
+	s.rateLimit.decide = s.decide
+}
+
+func (s *Sender) SetDedup(dedup config.DedupConfig) {
+	s.dedup = newDedupFilter(dedup)
+}
+
+func (s *Sender) SetCircuitBreaker(circuitBreaker config.CircuitBreakerConfig) {
+	s.circuitBreaker = newCircuitBreakerStage(circuitBreaker, s.impl.GetLogger)
+	s.circuitBreaker.decide = s.decide
+	if _, ok := s.impl.(DeliveryReporter); !ok {
+		s.circuitBreaker.assumeDelivered = true
+	}
+}
+
+// Flush asks the running stages to forward the groups, the notifications delayed by the rate
+// limit and the circuit breaker buffer without waiting for their schedule. Stages are flushed
+// in order, so that what one of them forwards is flushed by the next ones. It returns once
+// they hold nothing they can forward, or ctx is done.
+func (s *Sender) Flush(ctx context.Context) error {
+	flushChs := make([]chan chan struct{}, 0, 3)
+	if s.group != nil {
+		flushChs = append(flushChs, s.group.flushCh)
+	}
+	if s.rateLimit != nil {
+		flushChs = append(flushChs, s.rateLimit.flushCh)
+	}
+	if s.circuitBreaker != nil {
+		flushChs = append(flushChs, s.circuitBreaker.flushCh)
+	}
+
+	for _, flushCh := range flushChs {
+		if err := requestFlush(ctx, flushCh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pending returns the notifications held by the stages that have not been handed to the
+// wrapped SenderImpl. It must be called after the sender has stopped.
+func (s *Sender) Pending() []notification.Notification {
+	pending := make([]notification.Notification, 0)
+	if s.group != nil {
+		pending = append(pending, s.group.pending()...)
+	}
+	if s.rateLimit != nil {
+		pending = append(pending, s.rateLimit.pending()...)
+	}
+	if s.circuitBreaker != nil {
+		pending = append(pending, s.circuitBreaker.pending()...)
+	}
+	return pending
+}
+
+func (s *Sender) Status() map[string]any {
+	status := make(map[string]any)
+	if reporter, ok := s.impl.(implStatusReporter); ok {
+		for key, value := range reporter.status() {
+			status[key] = value
+		}
+	}
+	if s.circuitBreaker != nil {
+		status["circuitBreaker"] = s.circuitBreaker.status()
+	}
+	return status
+}
+
+func (s *Sender) Metrics() []abstraction.Metric {
+	metrics := make([]abstraction.Metric, 0)
+	if s.circuitBreaker != nil {
+		metrics = append(metrics, s.circuitBreaker.metrics(s.GetId())...)
+	}
+	return metrics
+}
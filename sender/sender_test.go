@@ -0,0 +1,133 @@
+package sender
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+func TestSenderReportsDecisionsOfStages(t *testing.T) {
+	impl := &dummySenderImpl{id: "sender-1", logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	s := NewSender(impl)
+	s.SetMatch(config.MetadataCondition{NotificationSource: "billing"})
+	s.SetMinSeverity(notification.SeverityError)
+
+	decisions := make(chan Decision, 2)
+	s.AddDecisionHandler(func(n notification.Notification, decision Decision) {
+		decisions <- decision
+	})
+	delivered := make(chan notification.Notification, 1)
+	s.AddDeliveryHandler(func(n notification.Notification, err error) {
+		delivered <- n
+	})
+
+	inputCh := make(chan notification.Notification)
+	done := make(chan struct{})
+	errCh := s.Start(inputCh, done)
+	defer func() {
+		close(done)
+		<-errCh
+	}()
+
+	inputCh <- notification.Notification{Title: "other", NotificationSource: "payments", Severity: notification.SeverityError}
+	inputCh <- notification.Notification{Title: "minor", NotificationSource: "billing", Severity: notification.SeverityInfo}
+	inputCh <- notification.Notification{Title: "major", NotificationSource: "billing", Severity: notification.SeverityError}
+
+	for _, want := range []Decision{DecisionUnmatched, DecisionBelowMinSeverity} {
+		select {
+		case got := <-decisions:
+			if got != want {
+				t.Fatalf("decision = %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("decision %q was not reported", want)
+		}
+	}
+
+	select {
+	case n := <-delivered:
+		if n.Title != "major" {
+			t.Fatalf("delivered %q, want major", n.Title)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification was not delivered")
+	}
+}
+
+func TestSenderDeliversRoutedNotificationsRegardlessOfMatch(t *testing.T) {
+	impl := &dummySenderImpl{id: "sender-1", logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	s := NewSender(impl)
+	s.SetMatch(config.MetadataCondition{NotificationSource: "billing"})
+	s.SetMinSeverity(notification.SeverityError)
+
+	delivered := make(chan notification.Notification, 1)
+	s.AddDeliveryHandler(func(n notification.Notification, err error) {
+		delivered <- n
+	})
+
+	inputCh := make(chan notification.Notification)
+	done := make(chan struct{})
+	errCh := s.Start(inputCh, done)
+	defer func() {
+		close(done)
+		<-errCh
+	}()
+
+	inputCh <- notification.Notification{
+		Title:              "escalated",
+		NotificationSource: "payments",
+		Severity:           notification.SeverityInfo,
+		Labels:             map[string]string{RouteLabel: "escalation/incident/1"},
+	}
+
+	select {
+	case n := <-delivered:
+		if n.Title != "escalated" {
+			t.Fatalf("delivered %q, want escalated", n.Title)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("routed notification was not delivered")
+	}
+}
+
+func TestSenderFlushDeliversNotificationsHeldByStages(t *testing.T) {
+	impl := &recordingSenderImpl{id: "sender-1", logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	s := NewSender(impl)
+	s.SetGroup(config.GroupConfig{By: []string{"env"}, GroupWait: time.Hour, GroupInterval: time.Hour})
+	s.SetRateLimit(config.RateLimitConfig{Limit: 1, Interval: time.Hour, Policy: config.RateLimitPolicyDelay})
+
+	delivered := make(chan notification.Notification, 2)
+	s.AddDeliveryHandler(func(n notification.Notification, err error) {
+		delivered <- n
+	})
+
+	inputCh := make(chan notification.Notification)
+	done := make(chan struct{})
+	errCh := s.Start(inputCh, done)
+	defer func() {
+		close(done)
+		<-errCh
+	}()
+
+	inputCh <- notification.Notification{Title: "prod", Labels: map[string]string{"env": "prod"}}
+	inputCh <- notification.Notification{Title: "stg", Labels: map[string]string{"env": "stg"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+
+	for range 2 {
+		select {
+		case <-delivered:
+		case <-time.After(time.Second):
+			t.Fatalf("delivered %v, want both notifications", impl.titles())
+		}
+	}
+}
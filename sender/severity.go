@@ -0,0 +1,58 @@
+package sender
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+// severityMapping maps notification severities onto the severity vocabulary of a sender, or
+// onto other settings chosen by severity. A notification takes the value of the highest
+// threshold it reaches, and severities below every threshold take the value of the lowest one.
+type severityMapping[T any] []severityThreshold[T]
+
+type severityThreshold[T any] struct {
+	severity notification.Severity
+	value    T
+}
+
+// parseSeverityMapping builds a mapping from a table keyed by named severity, such as
+// {warning: warning, error: error}.
+func parseSeverityMapping[T any](table map[string]T) (severityMapping[T], error) {
+	if len(table) == 0 {
+		return nil, fmt.Errorf("severity mapping must contain at least one entry")
+	}
+
+	mapping := make(severityMapping[T], 0, len(table))
+	for key, value := range table {
+		severity, err := notification.ParseSeverity(key)
+		if err != nil {
+			return nil, err
+		}
+		mapping = append(mapping, severityThreshold[T]{severity: severity, value: value})
+	}
+
+	slices.SortFunc(mapping, func(a, b severityThreshold[T]) int {
+		return int(a.severity - b.severity)
+	})
+
+	for i := 1; i < len(mapping); i++ {
+		if mapping[i].severity == mapping[i-1].severity {
+			return nil, fmt.Errorf("severity %s is mapped more than once", mapping[i].severity)
+		}
+	}
+
+	return mapping, nil
+}
+
+func (m severityMapping[T]) lookup(severity notification.Severity) T {
+	value := m[0].value
+	for _, threshold := range m {
+		if severity < threshold.severity {
+			break
+		}
+		value = threshold.value
+	}
+	return value
+}
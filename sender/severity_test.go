@@ -0,0 +1,48 @@
+package sender
+
+import (
+	"testing"
+
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+func TestSeverityMappingLookup(t *testing.T) {
+	t.Parallel()
+
+	mapping, err := parseSeverityMapping(map[string]string{
+		"info":     "low",
+		"warning":  "normal",
+		"critical": "high",
+	})
+	if err != nil {
+		t.Fatalf("parseSeverityMapping returned error: %v", err)
+	}
+
+	tests := []struct {
+		severity notification.Severity
+		want     string
+	}{
+		{severity: notification.SeverityDebug, want: "low"},
+		{severity: notification.SeverityInfo, want: "low"},
+		{severity: notification.SeverityWarning, want: "normal"},
+		{severity: notification.SeverityError, want: "normal"},
+		{severity: notification.SeverityCritical, want: "high"},
+		{severity: notification.SeverityCritical + 4, want: "high"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.severity.String(), func(t *testing.T) {
+			t.Parallel()
+
+			if got := mapping.lookup(tt.severity); got != tt.want {
+				t.Fatalf("lookup(%s) = %q, want %q", tt.severity, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSeverityMappingRejectsDuplicateSeverity(t *testing.T) {
+	if _, err := parseSeverityMapping(map[string]string{"warn": "a", "warning": "b"}); err == nil {
+		t.Fatal("parseSeverityMapping unexpectedly succeeded")
+	}
+}
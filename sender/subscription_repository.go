@@ -0,0 +1,326 @@
+package sender
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	RepositoryTypeInMemory = "InMemory"
+	RepositoryTypeDynamoDB = "DynamoDB"
+	RepositoryTypeFile     = "File"
+	RepositoryTypeSQLite   = "SQLite"
+)
+
+// SubscriptionRepositoryProperties selects where subscriptions are stored.
+type SubscriptionRepositoryProperties struct {
+	RepositoryType string `yaml:"repositoryType"`
+	// RepositoryPath is the file of the File and SQLite repositories.
+	RepositoryPath string             `yaml:"repositoryPath"`
+	DynamoDB       DynamoDBProperties `yaml:"dynamoDB"`
+}
+
+func NewSubscriptionRepositoryProperties() SubscriptionRepositoryProperties {
+	return SubscriptionRepositoryProperties{
+		DynamoDB: NewDynamoDBProperties(),
+	}
+}
+
+func (p SubscriptionRepositoryProperties) Validate() error {
+	switch p.RepositoryType {
+	case "":
+		return fmt.Errorf("repositoryType is required")
+	case RepositoryTypeInMemory:
+	case RepositoryTypeDynamoDB:
+		if err := p.DynamoDB.Validate(); err != nil {
+			return fmt.Errorf("dynamoDB is invalid: %w", err)
+		}
+	case RepositoryTypeFile, RepositoryTypeSQLite:
+		if p.RepositoryPath == "" {
+			return fmt.Errorf("repositoryPath is required for repositoryType %s", p.RepositoryType)
+		}
+	default:
+		return fmt.Errorf("repositoryType is invalid. repositoryType: %s", p.RepositoryType)
+	}
+
+	return nil
+}
+
+// OpenSubscriptionRepository opens the repository selected by p. Repositories holding
+// resources, such as the SQLite repository, implement io.Closer.
+func OpenSubscriptionRepository(ctx context.Context, p SubscriptionRepositoryProperties) (SubscriptionRepository, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p.open(ctx, newDynamoDBConnection(p.DynamoDB))
+}
+
+func (p SubscriptionRepositoryProperties) open(ctx context.Context, dynamoDB *dynamoDBConnection) (SubscriptionRepository, error) {
+	switch p.RepositoryType {
+	case RepositoryTypeDynamoDB:
+		client, err := dynamoDB.getClient()
+		if err != nil {
+			return nil, err
+		}
+		if err := dynamoDB.ensureTable(ctx, p.DynamoDB.SubscriptionsTable, "Endpoint"); err != nil {
+			return nil, err
+		}
+		return NewDynamoDBSubscriptionRepository(client, p.DynamoDB), nil
+	case RepositoryTypeInMemory:
+		return NewInMemorySubscriptionRepository(), nil
+	case RepositoryTypeFile:
+		return NewFileSubscriptionRepository(p.RepositoryPath)
+	case RepositoryTypeSQLite:
+		return NewSQLiteSubscriptionRepository(p.RepositoryPath)
+	default:
+		return nil, fmt.Errorf("repositoryType is invalid. repositoryType: %s", p.RepositoryType)
+	}
+}
+
+// MigrateSubscriptions copies every subscription of from into to, replacing subscriptions of
+// to with the same endpoint. It returns the number of subscriptions copied.
+func MigrateSubscriptions(ctx context.Context, from, to SubscriptionRepository) (int, error) {
+	subscriptions, err := from.LoadAll(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("load subscriptions: %w", err)
+	}
+
+	for i, subscription := range subscriptions {
+		if err := to.Store(ctx, subscription); err != nil {
+			return i, fmt.Errorf("store subscription %s: %w", subscription.Endpoint, err)
+		}
+	}
+
+	return len(subscriptions), nil
+}
+
+// FileSubscriptionRepository keeps subscriptions in a JSON file. The file is read once when the
+// repository is created and rewritten atomically on every change, so that a crash leaves
+// either the previous or the new subscriptions. The file must not be shared by several
+// processes writing to it.
+type FileSubscriptionRepository struct {
+	path string
+
+	lock          sync.Mutex
+	subscriptions []WebPushSubscription
+}
+
+// NewFileSubscriptionRepository reads the subscriptions of path. A missing file holds no
+// subscriptions and is created on the first change.
+func NewFileSubscriptionRepository(path string) (*FileSubscriptionRepository, error) {
+	subscriptions := make([]WebPushSubscription, 0)
+
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read subscription file: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, &subscriptions); err != nil {
+			return nil, fmt.Errorf("decode subscription file %s: %w", path, err)
+		}
+	}
+
+	return &FileSubscriptionRepository{
+		path:          path,
+		subscriptions: subscriptions,
+	}, nil
+}
+
+func (fsr *FileSubscriptionRepository) LoadAll(ctx context.Context) ([]WebPushSubscription, error) {
+	fsr.lock.Lock()
+	defer fsr.lock.Unlock()
+
+	return slices.Clone(fsr.subscriptions), nil
+}
+
+func (fsr *FileSubscriptionRepository) Store(ctx context.Context, subscription WebPushSubscription) error {
+	fsr.lock.Lock()
+	defer fsr.lock.Unlock()
+
+	subscriptions := slices.Clone(fsr.subscriptions)
+	i := slices.IndexFunc(subscriptions, func(s WebPushSubscription) bool {
+		return s.Endpoint == subscription.Endpoint
+	})
+	if i >= 0 {
+		subscriptions[i] = subscription
+	} else {
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return fsr.write(subscriptions)
+}
+
+func (fsr *FileSubscriptionRepository) Delete(ctx context.Context, subscription WebPushSubscription) error {
+	fsr.lock.Lock()
+	defer fsr.lock.Unlock()
+
+	subscriptions := slices.DeleteFunc(slices.Clone(fsr.subscriptions), func(s WebPushSubscription) bool {
+		return s.Endpoint == subscription.Endpoint
+	})
+	if len(subscriptions) == len(fsr.subscriptions) {
+		return nil
+	}
+
+	return fsr.write(subscriptions)
+}
+
+// write replaces the file by a temporary file holding subscriptions, and keeps subscriptions
+// once the file is replaced.
+func (fsr *FileSubscriptionRepository) write(subscriptions []WebPushSubscription) error {
+	content, err := json.MarshalIndent(subscriptions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode subscriptions: %w", err)
+	}
+
+	dir, name := filepath.Split(fsr.path)
+	if dir == "" {
+		dir = "."
+	}
+	file, err := os.CreateTemp(dir, name+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create subscription file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write subscription file: %w", err)
+	}
+
+	if err := os.Rename(file.Name(), fsr.path); err != nil {
+		return fmt.Errorf("replace subscription file: %w", err)
+	}
+
+	fsr.subscriptions = subscriptions
+	return nil
+}
+
+// SQLiteSubscriptionRepository keeps subscriptions in a table of an SQLite database, which may
+// be shared by several processes on the same host.
+type SQLiteSubscriptionRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteSubscriptionRepository opens the database of path, creating it and its table if
+// needed.
+func NewSQLiteSubscriptionRepository(path string) (*SQLiteSubscriptionRepository, error) {
+	// busy_timeout makes writers wait for each other rather than failing with SQLITE_BUSY.
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("open subscription database: %w", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS subscriptions (
+	endpoint TEXT PRIMARY KEY,
+	p256dh TEXT NOT NULL,
+	auth TEXT NOT NULL,
+	owner TEXT NOT NULL DEFAULT '',
+	filter TEXT,
+	secret_hash TEXT NOT NULL DEFAULT ''
+)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create subscriptions table: %w", err)
+	}
+	if err := addSQLiteSecretHashColumn(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteSubscriptionRepository{db: db}, nil
+}
+
+// addSQLiteSecretHashColumn adds the secret_hash column to tables created before subscriptions
+// had secrets.
+func addSQLiteSecretHashColumn(db *sql.DB) error {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('subscriptions') WHERE name = 'secret_hash'`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("inspect subscriptions table: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := db.Exec(`ALTER TABLE subscriptions ADD COLUMN secret_hash TEXT NOT NULL DEFAULT ''`); err != nil {
+		return fmt.Errorf("add secret_hash column: %w", err)
+	}
+	return nil
+}
+
+func (ssr *SQLiteSubscriptionRepository) LoadAll(ctx context.Context) ([]WebPushSubscription, error) {
+	rows, err := ssr.db.QueryContext(ctx, `SELECT endpoint, p256dh, auth, owner, filter, secret_hash FROM subscriptions`)
+	if err != nil {
+		return nil, fmt.Errorf("query subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := make([]WebPushSubscription, 0)
+	for rows.Next() {
+		var subscription WebPushSubscription
+		var filter sql.NullString
+		err := rows.Scan(&subscription.Endpoint, &subscription.Keys.P256dh, &subscription.Keys.Auth, &subscription.Owner, &filter, &subscription.SecretHash)
+		if err != nil {
+			return nil, fmt.Errorf("scan subscription: %w", err)
+		}
+		if filter.Valid {
+			if err := json.Unmarshal([]byte(filter.String), &subscription.Filter); err != nil {
+				return nil, fmt.Errorf("decode filter of subscription %s: %w", subscription.Endpoint, err)
+			}
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query subscriptions: %w", err)
+	}
+
+	return subscriptions, nil
+}
+
+func (ssr *SQLiteSubscriptionRepository) Store(ctx context.Context, subscription WebPushSubscription) error {
+	var filter sql.NullString
+	if subscription.Filter != nil {
+		encoded, err := json.Marshal(subscription.Filter)
+		if err != nil {
+			return fmt.Errorf("encode filter: %w", err)
+		}
+		filter = sql.NullString{String: string(encoded), Valid: true}
+	}
+
+	_, err := ssr.db.ExecContext(ctx, `INSERT INTO subscriptions (endpoint, p256dh, auth, owner, filter, secret_hash) VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (endpoint) DO UPDATE SET p256dh = excluded.p256dh, auth = excluded.auth, owner = excluded.owner, filter = excluded.filter, secret_hash = excluded.secret_hash`,
+		subscription.Endpoint, subscription.Keys.P256dh, subscription.Keys.Auth, subscription.Owner, filter, subscription.SecretHash)
+	if err != nil {
+		return fmt.Errorf("store subscription: %w", err)
+	}
+
+	return nil
+}
+
+func (ssr *SQLiteSubscriptionRepository) Delete(ctx context.Context, subscription WebPushSubscription) error {
+	if _, err := ssr.db.ExecContext(ctx, `DELETE FROM subscriptions WHERE endpoint = ?`, subscription.Endpoint); err != nil {
+		return fmt.Errorf("delete subscription: %w", err)
+	}
+
+	return nil
+}
+
+func (ssr *SQLiteSubscriptionRepository) Close() error {
+	return ssr.db.Close()
+}
@@ -0,0 +1,241 @@
+package sender
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Kotaro7750/notifier/notification"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+)
+
+// testRepositorySubscriptions are two subscriptions, one with an owner and a filter.
+func testRepositorySubscriptions() []WebPushSubscription {
+	warning := notification.SeverityWarning
+	return []WebPushSubscription{
+		{
+			Subscription: webpush.Subscription{
+				Endpoint: "https://push.example.com/a",
+				Keys:     webpush.Keys{P256dh: "p256dh-a", Auth: "auth-a"},
+			},
+		},
+		{
+			Subscription: webpush.Subscription{
+				Endpoint: "https://push.example.com/b",
+				Keys:     webpush.Keys{P256dh: "p256dh-b", Auth: "auth-b"},
+			},
+			Owner: "alice",
+			Filter: &SubscriptionFilter{
+				Sources:     []string{"billing"},
+				Labels:      map[string][]string{"env": {"prod"}},
+				MinSeverity: &warning,
+			},
+			SecretHash: hashSubscriptionSecret("secret-b"),
+		},
+	}
+}
+
+// testSubscriptionRepository checks that repository stores, replaces and deletes subscriptions,
+// and that reopen returns a repository reading what was stored.
+func testSubscriptionRepository(t *testing.T, repository SubscriptionRepository, reopen func() SubscriptionRepository) {
+	t.Helper()
+
+	for _, subscription := range testRepositorySubscriptions() {
+		if err := repository.Store(context.Background(), subscription); err != nil {
+			t.Fatalf("Store returned error: %v", err)
+		}
+	}
+
+	replaced := testRepositorySubscriptions()[0]
+	replaced.Owner = "bob"
+	if err := repository.Store(context.Background(), replaced); err != nil {
+		t.Fatalf("Store returned error: %v", err)
+	}
+
+	subscriptions := mustLoadSubscriptions(t, reopen())
+	if len(subscriptions) != 2 {
+		t.Fatalf("len(subscriptions) = %d, want 2", len(subscriptions))
+	}
+	if got := subscriptions["https://push.example.com/a"]; got.Owner != "bob" || got.Keys.Auth != "auth-a" {
+		t.Fatalf("subscription a = %+v, want replaced owner bob", got)
+	}
+	got := subscriptions["https://push.example.com/b"]
+	if got.Owner != "alice" || got.Filter == nil || got.Filter.MinSeverity == nil || *got.Filter.MinSeverity != notification.SeverityWarning {
+		t.Fatalf("subscription b = %+v, want owner and filter kept", got)
+	}
+	if got.Filter.Labels["env"][0] != "prod" || got.Filter.Sources[0] != "billing" {
+		t.Fatalf("filter of subscription b = %+v, want sources and labels kept", got.Filter)
+	}
+
+	if err := repository.Delete(context.Background(), replaced); err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+	subscriptions = mustLoadSubscriptions(t, reopen())
+	if _, ok := subscriptions["https://push.example.com/a"]; ok || len(subscriptions) != 1 {
+		t.Fatalf("subscriptions = %v, want only b after Delete", subscriptions)
+	}
+}
+
+func mustLoadSubscriptions(t *testing.T, repository SubscriptionRepository) map[string]WebPushSubscription {
+	t.Helper()
+
+	subscriptions, err := repository.LoadAll(context.Background())
+	if err != nil {
+		t.Fatalf("LoadAll returned error: %v", err)
+	}
+	byEndpoint := make(map[string]WebPushSubscription, len(subscriptions))
+	for _, subscription := range subscriptions {
+		byEndpoint[subscription.Endpoint] = subscription
+	}
+	return byEndpoint
+}
+
+func TestFileSubscriptionRepository(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "subscriptions.json")
+
+	repository, err := NewFileSubscriptionRepository(path)
+	if err != nil {
+		t.Fatalf("NewFileSubscriptionRepository returned error: %v", err)
+	}
+
+	testSubscriptionRepository(t, repository, func() SubscriptionRepository {
+		reopened, err := NewFileSubscriptionRepository(path)
+		if err != nil {
+			t.Fatalf("NewFileSubscriptionRepository returned error: %v", err)
+		}
+		return reopened
+	})
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("files = %v, want only the subscription file", entries)
+	}
+}
+
+func TestFileSubscriptionRepositoryRejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewFileSubscriptionRepository(path); err == nil {
+		t.Fatal("NewFileSubscriptionRepository unexpectedly succeeded")
+	}
+}
+
+func TestSQLiteSubscriptionRepository(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.db")
+
+	repository, err := NewSQLiteSubscriptionRepository(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteSubscriptionRepository returned error: %v", err)
+	}
+	t.Cleanup(func() { repository.Close() })
+
+	testSubscriptionRepository(t, repository, func() SubscriptionRepository {
+		reopened, err := NewSQLiteSubscriptionRepository(path)
+		if err != nil {
+			t.Fatalf("NewSQLiteSubscriptionRepository returned error: %v", err)
+		}
+		t.Cleanup(func() { reopened.Close() })
+		return reopened
+	})
+}
+
+func TestSQLiteSubscriptionRepositoryAddsSecretHashColumn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.db")
+
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE subscriptions (
+	endpoint TEXT PRIMARY KEY,
+	p256dh TEXT NOT NULL,
+	auth TEXT NOT NULL,
+	owner TEXT NOT NULL DEFAULT '',
+	filter TEXT
+);
+INSERT INTO subscriptions (endpoint, p256dh, auth) VALUES ('https://push.example.com/a', 'p256dh-a', 'auth-a')`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository, err := NewSQLiteSubscriptionRepository(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteSubscriptionRepository returned error: %v", err)
+	}
+	t.Cleanup(func() { repository.Close() })
+
+	stored := mustLoadSubscriptions(t, repository)["https://push.example.com/a"]
+	if stored.Keys.Auth != "auth-a" || stored.SecretHash != "" {
+		t.Fatalf("subscription = %+v, want the legacy subscription without secret", stored)
+	}
+
+	subscription := testRepositorySubscriptions()[1]
+	if err := repository.Store(context.Background(), subscription); err != nil {
+		t.Fatalf("Store returned error: %v", err)
+	}
+	if got := mustLoadSubscriptions(t, repository)[subscription.Endpoint].SecretHash; got != subscription.SecretHash {
+		t.Fatalf("SecretHash = %q, want %q", got, subscription.SecretHash)
+	}
+}
+
+func TestMigrateSubscriptions(t *testing.T) {
+	dir := t.TempDir()
+	from, err := OpenSubscriptionRepository(context.Background(), SubscriptionRepositoryProperties{
+		RepositoryType: RepositoryTypeFile,
+		RepositoryPath: filepath.Join(dir, "subscriptions.json"),
+	})
+	if err != nil {
+		t.Fatalf("OpenSubscriptionRepository returned error: %v", err)
+	}
+	for _, subscription := range testRepositorySubscriptions() {
+		from.Store(context.Background(), subscription)
+	}
+
+	to, err := OpenSubscriptionRepository(context.Background(), SubscriptionRepositoryProperties{
+		RepositoryType: RepositoryTypeSQLite,
+		RepositoryPath: filepath.Join(dir, "subscriptions.db"),
+	})
+	if err != nil {
+		t.Fatalf("OpenSubscriptionRepository returned error: %v", err)
+	}
+	t.Cleanup(func() { to.(*SQLiteSubscriptionRepository).Close() })
+
+	copied, err := MigrateSubscriptions(context.Background(), from, to)
+	if err != nil {
+		t.Fatalf("MigrateSubscriptions returned error: %v", err)
+	}
+	if copied != 2 {
+		t.Fatalf("copied = %d, want 2", copied)
+	}
+	if got := mustLoadSubscriptions(t, to)["https://push.example.com/b"]; got.Owner != "alice" || got.Filter == nil {
+		t.Fatalf("migrated subscription b = %+v, want owner and filter", got)
+	}
+}
+
+func TestSubscriptionRepositoryPropertiesValidate(t *testing.T) {
+	tests := map[string]SubscriptionRepositoryProperties{
+		"missing type":        {},
+		"unknown type":        {RepositoryType: "Redis"},
+		"file without path":   {RepositoryType: RepositoryTypeFile},
+		"sqlite without path": {RepositoryType: RepositoryTypeSQLite},
+	}
+
+	for name, properties := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := properties.Validate(); err == nil {
+				t.Fatal("Validate unexpectedly succeeded")
+			}
+		})
+	}
+}
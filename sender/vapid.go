@@ -0,0 +1,359 @@
+package sender
+
+import (
+	"context"
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	VAPIDSourceInline    = "inline"
+	VAPIDSourceEnv       = "env"
+	VAPIDSourceFile      = "file"
+	VAPIDSourceGenerated = "generated"
+	VAPIDSourceDynamoDB  = "DynamoDB"
+
+	defaultVAPIDPublicKeyEnv  = "VAPID_PUBLIC_KEY"
+	defaultVAPIDPrivateKeyEnv = "VAPID_PRIVATE_KEY"
+	defaultVAPIDItemKey       = "vapid"
+	vapidKeysFileSuffix       = ".vapid.json"
+)
+
+// VAPIDKeys is a VAPID key pair encoded in unpadded base64url, the encoding browsers expect for
+// the applicationServerKey of a subscription. Key files hold it as JSON.
+type VAPIDKeys struct {
+	PublicKey  string `json:"publicKey"`
+	PrivateKey string `json:"privateKey"`
+}
+
+// GenerateVAPIDKeys returns a new VAPID key pair.
+func GenerateVAPIDKeys() (VAPIDKeys, error) {
+	privateKey, publicKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		return VAPIDKeys{}, fmt.Errorf("generate VAPID keys: %w", err)
+	}
+	return VAPIDKeys{PublicKey: publicKey, PrivateKey: privateKey}, nil
+}
+
+// Validate checks that the keys are a P-256 key pair.
+func (k VAPIDKeys) Validate() error {
+	privateBytes, err := decodeVAPIDKey(k.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("privateKey is not base64url: %w", err)
+	}
+	privateKey, err := ecdh.P256().NewPrivateKey(privateBytes)
+	if err != nil {
+		return fmt.Errorf("privateKey is invalid: %w", err)
+	}
+
+	publicBytes, err := decodeVAPIDKey(k.PublicKey)
+	if err != nil {
+		return fmt.Errorf("publicKey is not base64url: %w", err)
+	}
+	if _, err := ecdh.P256().NewPublicKey(publicBytes); err != nil {
+		return fmt.Errorf("publicKey is invalid: %w", err)
+	}
+	if string(privateKey.PublicKey().Bytes()) != string(publicBytes) {
+		return fmt.Errorf("publicKey does not match privateKey")
+	}
+
+	return nil
+}
+
+// decodeVAPIDKey accepts padded and unpadded base64url, like webpush-go.
+func decodeVAPIDKey(key string) ([]byte, error) {
+	if b, err := base64.URLEncoding.DecodeString(key); err == nil {
+		return b, nil
+	}
+	return base64.RawURLEncoding.DecodeString(key)
+}
+
+// WriteVAPIDKeysFile writes keys to a new key file readable by its owner only. It fails if the
+// file exists, so that keys in use are never overwritten.
+func WriteVAPIDKeysFile(path string, keys VAPIDKeys) error {
+	body, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(body, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readVAPIDKeysFile(path string) (VAPIDKeys, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return VAPIDKeys{}, err
+	}
+
+	var keys VAPIDKeys
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return VAPIDKeys{}, fmt.Errorf("decode VAPID key file %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// VAPIDKeySourceProperties selects where a VAPID key pair comes from.
+//
+//   - inline: publicKey and privateKey.
+//   - env: the environment variables publicKeyEnv and privateKeyEnv, VAPID_PUBLIC_KEY and
+//     VAPID_PRIVATE_KEY by default.
+//   - file: the key file at path, as written by `notifier webpush keygen`.
+//   - generated: the key file at path, generated on the first start.
+//   - DynamoDB: the item itemKey, the keyPrefix of dynamoDB followed by vapid by default, of
+//     table, the configTable of dynamoDB by default. The item is generated on the first start.
+type VAPIDKeySourceProperties struct {
+	Source        string `yaml:"source"`
+	PublicKey     string `yaml:"publicKey"`
+	PrivateKey    string `yaml:"privateKey"`
+	PublicKeyEnv  string `yaml:"publicKeyEnv"`
+	PrivateKeyEnv string `yaml:"privateKeyEnv"`
+	Path          string `yaml:"path"`
+	Table         string `yaml:"table"`
+	ItemKey       string `yaml:"itemKey"`
+}
+
+// VAPIDProperties configures the VAPID keys of the WebPush sender. Notifications are signed
+// with the current key. Subscriptions made with a key listed in previous keep receiving
+// notifications while browsers move to the current key: a push service rejecting a
+// notification as not matching its subscription key is retried with each previous key. The
+// subscription page moves a browser by subscribing it again once it is opened, after which a
+// previous key can be removed.
+type VAPIDProperties struct {
+	VAPIDKeySourceProperties `yaml:",inline"`
+	Previous                 []VAPIDKeySourceProperties `yaml:"previous"`
+}
+
+func (p VAPIDProperties) Validate() error {
+	if err := p.VAPIDKeySourceProperties.Validate(); err != nil {
+		return err
+	}
+
+	for i, previous := range p.Previous {
+		if previous.Source == VAPIDSourceGenerated {
+			return fmt.Errorf("previous[%d]: source %s is not supported for previous keys", i, VAPIDSourceGenerated)
+		}
+		if err := previous.Validate(); err != nil {
+			return fmt.Errorf("previous[%d]: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (p VAPIDKeySourceProperties) Validate() error {
+	unexpected := func(fields map[string]string) error {
+		for name, value := range fields {
+			if value != "" {
+				return fmt.Errorf("%s is not supported with source: %s", name, p.Source)
+			}
+		}
+		return nil
+	}
+
+	switch p.Source {
+	case VAPIDSourceInline:
+		if p.PublicKey == "" || p.PrivateKey == "" {
+			return fmt.Errorf("publicKey and privateKey are required with source: %s", p.Source)
+		}
+		if err := (VAPIDKeys{PublicKey: p.PublicKey, PrivateKey: p.PrivateKey}).Validate(); err != nil {
+			return err
+		}
+		return unexpected(map[string]string{"publicKeyEnv": p.PublicKeyEnv, "privateKeyEnv": p.PrivateKeyEnv, "path": p.Path, "table": p.Table, "itemKey": p.ItemKey})
+	case VAPIDSourceEnv:
+		return unexpected(map[string]string{"publicKey": p.PublicKey, "privateKey": p.PrivateKey, "path": p.Path, "table": p.Table, "itemKey": p.ItemKey})
+	case VAPIDSourceFile, VAPIDSourceGenerated:
+		if p.Path == "" {
+			return fmt.Errorf("path is required with source: %s", p.Source)
+		}
+		return unexpected(map[string]string{"publicKey": p.PublicKey, "privateKey": p.PrivateKey, "publicKeyEnv": p.PublicKeyEnv, "privateKeyEnv": p.PrivateKeyEnv, "table": p.Table, "itemKey": p.ItemKey})
+	case VAPIDSourceDynamoDB:
+		return unexpected(map[string]string{"publicKey": p.PublicKey, "privateKey": p.PrivateKey, "publicKeyEnv": p.PublicKeyEnv, "privateKeyEnv": p.PrivateKeyEnv, "path": p.Path})
+	case "":
+		return fmt.Errorf("source is required")
+	default:
+		return fmt.Errorf("source %s is not supported", p.Source)
+	}
+}
+
+// load reads the keys from the source. dynamoDB is only used by the DynamoDB source.
+func (p VAPIDKeySourceProperties) load(ctx context.Context, dynamoDB *dynamoDBConnection) (VAPIDKeys, error) {
+	var keys VAPIDKeys
+	var err error
+
+	switch p.Source {
+	case VAPIDSourceInline:
+		keys = VAPIDKeys{PublicKey: p.PublicKey, PrivateKey: p.PrivateKey}
+	case VAPIDSourceEnv:
+		keys, err = p.loadEnv()
+	case VAPIDSourceFile:
+		keys, err = readVAPIDKeysFile(p.Path)
+	case VAPIDSourceGenerated:
+		keys, err = p.loadGenerated()
+	case VAPIDSourceDynamoDB:
+		keys, err = p.loadDynamoDB(ctx, dynamoDB)
+	default:
+		err = fmt.Errorf("source %s is not supported", p.Source)
+	}
+	if err != nil {
+		return VAPIDKeys{}, fmt.Errorf("load VAPID keys from %s: %w", p.Source, err)
+	}
+
+	if err := keys.Validate(); err != nil {
+		return VAPIDKeys{}, fmt.Errorf("VAPID keys from %s are invalid: %w", p.Source, err)
+	}
+	return keys, nil
+}
+
+func (p VAPIDKeySourceProperties) loadEnv() (VAPIDKeys, error) {
+	publicKeyEnv := p.PublicKeyEnv
+	if publicKeyEnv == "" {
+		publicKeyEnv = defaultVAPIDPublicKeyEnv
+	}
+	privateKeyEnv := p.PrivateKeyEnv
+	if privateKeyEnv == "" {
+		privateKeyEnv = defaultVAPIDPrivateKeyEnv
+	}
+
+	keys := VAPIDKeys{PublicKey: os.Getenv(publicKeyEnv), PrivateKey: os.Getenv(privateKeyEnv)}
+	if keys.PublicKey == "" || keys.PrivateKey == "" {
+		return VAPIDKeys{}, fmt.Errorf("%s and %s are required", publicKeyEnv, privateKeyEnv)
+	}
+	return keys, nil
+}
+
+func (p VAPIDKeySourceProperties) loadGenerated() (VAPIDKeys, error) {
+	keys, err := readVAPIDKeysFile(p.Path)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return keys, err
+	}
+
+	if keys, err = GenerateVAPIDKeys(); err != nil {
+		return VAPIDKeys{}, err
+	}
+	if err := WriteVAPIDKeysFile(p.Path, keys); err != nil {
+		return VAPIDKeys{}, err
+	}
+	return keys, nil
+}
+
+// loadDynamoDB reads the keys stored as "<privateKey> <publicKey>" in the Value attribute of
+// the item, and generates the item when it does not exist.
+func (p VAPIDKeySourceProperties) loadDynamoDB(ctx context.Context, dynamoDB *dynamoDBConnection) (VAPIDKeys, error) {
+	table := p.Table
+	if table == "" {
+		table = dynamoDB.properties.ConfigTable
+	}
+	itemKey := p.ItemKey
+	if itemKey == "" {
+		itemKey = dynamoDB.properties.KeyPrefix + defaultVAPIDItemKey
+	}
+
+	client, err := dynamoDB.getClient()
+	if err != nil {
+		return VAPIDKeys{}, err
+	}
+	if err := dynamoDB.ensureTable(ctx, table, "Key"); err != nil {
+		return VAPIDKeys{}, err
+	}
+
+	keys, found, err := readDynamoDBVAPIDKeys(ctx, client, dynamoDB.properties.RequestTimeout, table, itemKey)
+	if err != nil || found {
+		return keys, err
+	}
+
+	if keys, err = GenerateVAPIDKeys(); err != nil {
+		return VAPIDKeys{}, err
+	}
+	// The condition keeps the keys written by another instance starting at the same time.
+	putCtx, cancel := context.WithTimeout(ctx, dynamoDB.properties.RequestTimeout)
+	defer cancel()
+	_, err = client.PutItem(putCtx, &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]types.AttributeValue{
+			"Key":   &types.AttributeValueMemberS{Value: itemKey},
+			"Value": &types.AttributeValueMemberS{Value: keys.PrivateKey + " " + keys.PublicKey},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#key)"),
+		ExpressionAttributeNames: map[string]string{
+			"#key": "Key",
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		// Another instance wrote the item first. The consistent read sees it, so it is read once.
+		keys, found, err := readDynamoDBVAPIDKeys(ctx, client, dynamoDB.properties.RequestTimeout, table, itemKey)
+		if err != nil {
+			return VAPIDKeys{}, err
+		}
+		if !found {
+			return VAPIDKeys{}, fmt.Errorf("%s was written by another instance, but it is not found", itemKey)
+		}
+		return keys, nil
+	}
+	if err != nil {
+		return VAPIDKeys{}, fmt.Errorf("PutItem for %s failed: %w", itemKey, err)
+	}
+	return keys, nil
+}
+
+// readDynamoDBVAPIDKeys reads the keys of the item with a consistent read, so that an item
+// written by another instance is seen. found is false when the item does not exist.
+func readDynamoDBVAPIDKeys(ctx context.Context, client *dynamodb.Client, timeout time.Duration, table string, itemKey string) (keys VAPIDKeys, found bool, err error) {
+	getCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	output, err := client.GetItem(getCtx, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"Key": &types.AttributeValueMemberS{Value: itemKey},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return VAPIDKeys{}, false, fmt.Errorf("GetItem for %s failed: %w", itemKey, err)
+	}
+	if output.Item == nil {
+		return VAPIDKeys{}, false, nil
+	}
+
+	item := struct {
+		Key   string `dynamodbav:"Key"`
+		Value string `dynamodbav:"Value"`
+	}{}
+	if err := attributevalue.UnmarshalMap(output.Item, &item); err != nil {
+		return VAPIDKeys{}, false, fmt.Errorf("unmarshal %s failed: %w", itemKey, err)
+	}
+
+	fields := strings.Fields(item.Value)
+	if len(fields) != 2 {
+		return VAPIDKeys{}, false, fmt.Errorf("value of %s should be a private key and a public key separated by a space", itemKey)
+	}
+	return VAPIDKeys{PrivateKey: fields[0], PublicKey: fields[1]}, true, nil
+}
@@ -0,0 +1,257 @@
+package sender
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/test_util"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+)
+
+func mustGenerateVAPIDKeys(t *testing.T) VAPIDKeys {
+	t.Helper()
+
+	keys, err := GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatalf("GenerateVAPIDKeys() error = %v", err)
+	}
+	return keys
+}
+
+// noDynamoDB is passed to sources that do not use DynamoDB.
+var noDynamoDB *dynamoDBConnection
+
+func TestVAPIDKeysValidateRejectsMismatchedPair(t *testing.T) {
+	keys := mustGenerateVAPIDKeys(t)
+	other := mustGenerateVAPIDKeys(t)
+
+	if err := keys.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if err := (VAPIDKeys{PublicKey: other.PublicKey, PrivateKey: keys.PrivateKey}).Validate(); err == nil {
+		t.Fatal("Validate() accepted a mismatched pair")
+	}
+	if err := (VAPIDKeys{PublicKey: "not a key", PrivateKey: keys.PrivateKey}).Validate(); err == nil {
+		t.Fatal("Validate() accepted an invalid public key")
+	}
+}
+
+func TestVAPIDGeneratedSourcePersistsKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vapid", "keys.json")
+	source := VAPIDKeySourceProperties{Source: VAPIDSourceGenerated, Path: path}
+
+	first, err := source.load(context.Background(), noDynamoDB)
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	second, err := source.load(context.Background(), noDynamoDB)
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+
+	if first != second {
+		t.Fatalf("keys changed between loads: %+v, %+v", first, second)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestVAPIDEnvSourceReadsConfiguredVariables(t *testing.T) {
+	keys := mustGenerateVAPIDKeys(t)
+	t.Setenv("TEST_VAPID_PUBLIC", keys.PublicKey)
+	t.Setenv("TEST_VAPID_PRIVATE", keys.PrivateKey)
+
+	source := VAPIDKeySourceProperties{Source: VAPIDSourceEnv, PublicKeyEnv: "TEST_VAPID_PUBLIC", PrivateKeyEnv: "TEST_VAPID_PRIVATE"}
+	loaded, err := source.load(context.Background(), noDynamoDB)
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if loaded != keys {
+		t.Fatalf("load() = %+v, want %+v", loaded, keys)
+	}
+}
+
+func TestVAPIDPropertiesValidate(t *testing.T) {
+	keys := mustGenerateVAPIDKeys(t)
+
+	tests := map[string]struct {
+		properties VAPIDProperties
+		wantErr    bool
+	}{
+		"inline": {
+			properties: VAPIDProperties{VAPIDKeySourceProperties: VAPIDKeySourceProperties{Source: VAPIDSourceInline, PublicKey: keys.PublicKey, PrivateKey: keys.PrivateKey}},
+		},
+		"missing source": {
+			properties: VAPIDProperties{},
+			wantErr:    true,
+		},
+		"file without path": {
+			properties: VAPIDProperties{VAPIDKeySourceProperties: VAPIDKeySourceProperties{Source: VAPIDSourceFile}},
+			wantErr:    true,
+		},
+		"path with env source": {
+			properties: VAPIDProperties{VAPIDKeySourceProperties: VAPIDKeySourceProperties{Source: VAPIDSourceEnv, Path: "/keys.json"}},
+			wantErr:    true,
+		},
+		"generated previous key": {
+			properties: VAPIDProperties{
+				VAPIDKeySourceProperties: VAPIDKeySourceProperties{Source: VAPIDSourceEnv},
+				Previous:                 []VAPIDKeySourceProperties{{Source: VAPIDSourceGenerated, Path: "/keys.json"}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.properties.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebPushSenderBuilderLoadsVAPIDKeysFromFile(t *testing.T) {
+	keys := mustGenerateVAPIDKeys(t)
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := WriteVAPIDKeysFile(path, keys); err != nil {
+		t.Fatalf("WriteVAPIDKeysFile() error = %v", err)
+	}
+
+	component, err := WebPushSenderBuilder("sender-1", test_util.MustPropertiesNode(t, `
+listenAddress: :8091
+repositoryType: InMemory
+vapid:
+  source: file
+  path: `+path+`
+`))
+	if err != nil {
+		t.Fatalf("WebPushSenderBuilder returned error: %v", err)
+	}
+
+	impl := component.(*Sender).impl.(*webPushSenderImpl)
+	if impl.vapidKeys != keys {
+		t.Fatalf("vapidKeys = %+v, want %+v", impl.vapidKeys, keys)
+	}
+	if impl.ephemeralVAPIDKeys {
+		t.Fatal("ephemeralVAPIDKeys = true, want false")
+	}
+}
+
+func TestWebPushSenderRetriesWithPreviousVAPIDKey(t *testing.T) {
+	current := mustGenerateVAPIDKeys(t)
+	previous := mustGenerateVAPIDKeys(t)
+
+	// The push service accepts notifications signed with the key of the subscription only.
+	signedWith := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		_, key, _ := strings.Cut(authorization, "k=")
+		signedWith = append(signedWith, key)
+		if key != previous.PublicKey {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	wpsi := &webPushSenderImpl{
+		vapidKeys:         current,
+		previousVAPIDKeys: []VAPIDKeys{previous},
+		defaultSubscriber: "tester@example.com",
+		httpClient:        server.Client(),
+	}
+
+	res, err := wpsi.sendToSubscription(context.Background(), pushMessage{data: []byte(`{}`)}, mustSubscription(t, server.URL))
+	if err != nil {
+		t.Fatalf("sendToSubscription() error = %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("StatusCode = %d, want %d", res.StatusCode, http.StatusCreated)
+	}
+	if len(signedWith) != 2 || signedWith[0] != current.PublicKey || signedWith[1] != previous.PublicKey {
+		t.Fatalf("signed with %v, want current then previous key", signedWith)
+	}
+}
+
+// mustSubscription returns a subscription with valid browser keys for endpoint.
+func mustSubscription(t *testing.T, endpoint string) webpush.Subscription {
+	t.Helper()
+
+	browserKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+
+	return webpush.Subscription{
+		Endpoint: endpoint,
+		Keys: webpush.Keys{
+			P256dh: base64.RawURLEncoding.EncodeToString(browserKey.PublicKey().Bytes()),
+			Auth:   base64.RawURLEncoding.EncodeToString(auth),
+		},
+	}
+}
+
+func TestVAPIDKeySourceReadsDynamoDBOnceAfterLosingRace(t *testing.T) {
+	var lock sync.Mutex
+	gets := make([]map[string]any, 0)
+	server := fakeDynamoDB(t, func(operation string, body map[string]any) (int, any) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch operation {
+		case "GetItem":
+			gets = append(gets, body)
+			return http.StatusOK, map[string]any{}
+		case "PutItem":
+			return http.StatusBadRequest, map[string]string{
+				"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+				"message": "The conditional request failed",
+			}
+		}
+		return http.StatusOK, map[string]any{}
+	})
+
+	properties := NewDynamoDBProperties()
+	properties.Endpoint = server.URL
+	properties.ConfigTable = "config"
+	properties.RequestTimeout = time.Second
+	source := VAPIDKeySourceProperties{Source: VAPIDSourceDynamoDB}
+
+	if _, err := source.load(context.Background(), newDynamoDBConnection(properties)); err == nil {
+		t.Fatal("load unexpectedly succeeded")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(gets) != 2 {
+		t.Fatalf("GetItem was called %d times, want 2", len(gets))
+	}
+	for _, get := range gets {
+		if get["ConsistentRead"] != true {
+			t.Fatalf("GetItem = %v, want a consistent read", get)
+		}
+	}
+}
@@ -1,32 +1,116 @@
 package sender
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Kotaro7750/notifier/abstraction"
 	"github.com/Kotaro7750/notifier/config"
 	"github.com/Kotaro7750/notifier/notification"
+	"github.com/Kotaro7750/notifier/tracing"
 
 	webpush "github.com/SherClockHolmes/webpush-go"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-	"github.com/rs/cors"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	defaultWebPushRetryBackoff = 1 * time.Second
+	// maxWebPushResponseBody bounds the part of a push service response read to reuse its
+	// connection and to report it.
+	maxWebPushResponseBody = 64 * 1024
+	// maxWebPushTopicLength is the longest Topic header push services accept.
+	maxWebPushTopicLength = 32
+	// maxWebPushTTL bounds ttlMapping, since push services such as FCM keep messages for at
+	// most four weeks.
+	maxWebPushTTL = 28 * 24 * time.Hour
+)
+
+// defaultWebPushUrgencyMapping maps severities onto Web Push urgencies when urgencyMapping is
+// not configured, so that only errors wake up devices on low battery.
+var defaultWebPushUrgencyMapping = map[string]string{
+	"debug":   string(webpush.UrgencyVeryLow),
+	"info":    string(webpush.UrgencyLow),
+	"warning": string(webpush.UrgencyNormal),
+	"error":   string(webpush.UrgencyHigh),
+}
+
+// defaultWebPushTTLMapping maps severities onto how long push services keep notifications for
+// offline devices when ttlMapping is not configured.
+var defaultWebPushTTLMapping = map[string]time.Duration{
+	"debug":   1 * time.Hour,
+	"warning": 12 * time.Hour,
+	"error":   24 * time.Hour,
+}
+
 type WebPushSenderProperties struct {
 	ListenAddress     string `yaml:"listenAddress"`
 	DefaultSubscriber string `yaml:"defaultSubscriber"`
-	RepositoryType    string `yaml:"repositoryType"`
+	// SubscriptionRepositoryProperties select the repository by repositoryType, one of
+	// InMemory, DynamoDB, File and SQLite, repositoryPath for File and SQLite, and dynamoDB for
+	// DynamoDB and the DynamoDB VAPID key source.
+	SubscriptionRepositoryProperties `yaml:",inline"`
+	// VAPID selects the VAPID keys. Without it, the keys of the DynamoDB repository are
+	// stored in DynamoDB, the keys of the File and SQLite repositories are generated into
+	// repositoryPath followed by .vapid.json, and the keys of the InMemory repository are
+	// generated on every start.
+	VAPID *VAPIDProperties `yaml:"vapid,omitempty"`
+	// MaxRetries is the number of retries of a subscription whose push service answers 429
+	// or 5xx. Retries wait for the Retry-After of the answer, at most maxRetryDelay.
+	MaxRetries    int           `yaml:"maxRetries"`
+	MaxRetryDelay time.Duration `yaml:"maxRetryDelay"`
+	// Concurrency is the number of subscriptions a notification is sent to at the same time,
+	// and RequestTimeout bounds each request to a push service.
+	Concurrency    int           `yaml:"concurrency"`
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+	// SubscriptionCacheTTL is how long subscriptions loaded from the repository are reused.
+	// The cache is also invalidated when this sender stores or deletes a subscription; the TTL
+	// bounds how long changes made by other instances sharing the repository go unnoticed. 0
+	// disables the cache.
+	SubscriptionCacheTTL time.Duration `yaml:"subscriptionCacheTTL"`
+	// UrgencyMapping and TTLMapping choose the Urgency and TTL of pushes by the severity of
+	// notifications, keyed by named severity like severityMapping of other senders.
+	UrgencyMapping map[string]string        `yaml:"urgencyMapping"`
+	TTLMapping     map[string]time.Duration `yaml:"ttlMapping"`
+	// TopicLabel is the label whose value becomes the Topic of pushes, so that a notification
+	// replaces the earlier one with the same value still waiting for an offline device.
+	TopicLabel string `yaml:"topicLabel"`
+	// SubscriptionPage serves a page subscribing browsers to this sender at / of
+	// listenAddress, with the service worker showing its notifications.
+	SubscriptionPage *SubscriptionPageProperties `yaml:"subscriptionPage,omitempty"`
+	// CORS allows pages of other origins to use the API.
+	CORS *CORSProperties `yaml:"cors,omitempty"`
+	// AdminToken is the bearer token allowing to list subscriptions and to change any of them,
+	// WEBPUSH_ADMIN_TOKEN by default. Subscriptions cannot be listed without it.
+	AdminToken string `yaml:"adminToken"`
+}
+
+func NewWebPushSenderProperties() WebPushSenderProperties {
+	return WebPushSenderProperties{
+		SubscriptionRepositoryProperties: NewSubscriptionRepositoryProperties(),
+		MaxRetries:                       3,
+		MaxRetryDelay:                    30 * time.Second,
+		Concurrency:                      8,
+		RequestTimeout:                   10 * time.Second,
+		SubscriptionCacheTTL:             1 * time.Minute,
+	}
 }
 
 func (p WebPushSenderProperties) Validate() error {
@@ -34,85 +118,143 @@ func (p WebPushSenderProperties) Validate() error {
 		return fmt.Errorf("listenAddress is required")
 	}
 
-	if p.RepositoryType == "" {
-		return fmt.Errorf("repositoryType is required")
+	if err := p.SubscriptionRepositoryProperties.Validate(); err != nil {
+		return err
+	}
+	// The DynamoDB VAPID key source uses dynamoDB with any repository.
+	if err := p.DynamoDB.Validate(); err != nil {
+		return fmt.Errorf("dynamoDB is invalid: %w", err)
+	}
+
+	if p.VAPID != nil {
+		if err := p.VAPID.Validate(); err != nil {
+			return fmt.Errorf("vapid is invalid: %w", err)
+		}
+	}
+
+	if p.CORS != nil {
+		if err := p.CORS.Validate(); err != nil {
+			return fmt.Errorf("cors is invalid: %w", err)
+		}
+	}
+
+	if p.MaxRetries < 0 {
+		return fmt.Errorf("maxRetries should be greater than or equal to 0")
+	}
+	if p.MaxRetryDelay < 0 {
+		return fmt.Errorf("maxRetryDelay should be greater than or equal to 0")
+	}
+	if p.Concurrency <= 0 {
+		return fmt.Errorf("concurrency should be greater than 0")
+	}
+	if p.RequestTimeout < 0 {
+		return fmt.Errorf("requestTimeout should be greater than or equal to 0")
+	}
+	if p.SubscriptionCacheTTL < 0 {
+		return fmt.Errorf("subscriptionCacheTTL should be greater than or equal to 0")
+	}
+	if p.UrgencyMapping != nil {
+		if _, err := parseWebPushUrgencyMapping(p.UrgencyMapping); err != nil {
+			return fmt.Errorf("urgencyMapping is invalid: %w", err)
+		}
+	}
+	if p.TTLMapping != nil {
+		if _, err := parseWebPushTTLMapping(p.TTLMapping); err != nil {
+			return fmt.Errorf("ttlMapping is invalid: %w", err)
+		}
 	}
 
 	return nil
 }
 
 func WebPushSenderBuilder(id string, properties yaml.Node) (abstraction.AbstractChannelComponent, error) {
-	var parsedProperties WebPushSenderProperties
+	parsedProperties := NewWebPushSenderProperties()
 	if err := config.DecodeProperties(properties, &parsedProperties); err != nil {
 		return nil, err
 	}
+	if parsedProperties.AdminToken == "" {
+		parsedProperties.AdminToken = os.Getenv(webPushAdminTokenEnvVar)
+	}
 
 	if err := parsedProperties.Validate(); err != nil {
 		return nil, err
 	}
 
-	var subscriptionRepository SubscriptionRepository
-	vapidPrivateKey, vapidPublicKey, _ := webpush.GenerateVAPIDKeys()
+	dynamoDB := newDynamoDBConnection(parsedProperties.DynamoDB)
 
-	switch parsedProperties.RepositoryType {
-	case "DynamoDB":
-		cfg, err := awsconfig.LoadDefaultConfig(context.Background(), awsconfig.WithRegion("ap-northeast-1"))
-		if err != nil {
-			return nil, fmt.Errorf("Load AWS config failed. err: %s", err)
-		}
-		dynamodbClient := dynamodb.NewFromConfig(cfg)
+	urgencyMappingTable := parsedProperties.UrgencyMapping
+	if urgencyMappingTable == nil {
+		urgencyMappingTable = defaultWebPushUrgencyMapping
+	}
+	urgencies, err := parseWebPushUrgencyMapping(urgencyMappingTable)
+	if err != nil {
+		return nil, err
+	}
+	ttlMappingTable := parsedProperties.TTLMapping
+	if ttlMappingTable == nil {
+		ttlMappingTable = defaultWebPushTTLMapping
+	}
+	ttls, err := parseWebPushTTLMapping(ttlMappingTable)
+	if err != nil {
+		return nil, err
+	}
 
-		subscriptionRepository = NewDynamoDBSubscriptionRepository(dynamodbClient)
+	var subscriptionPage *SubscriptionPageProperties
+	if parsedProperties.SubscriptionPage != nil {
+		page := parsedProperties.SubscriptionPage.withDefaults()
+		subscriptionPage = &page
+	}
 
-		output, err := dynamodbClient.GetItem(context.Background(), &dynamodb.GetItemInput{
-			TableName: aws.String("notifier-config"),
-			Key: map[string]types.AttributeValue{
-				"Key": &types.AttributeValueMemberS{
-					Value: "vapid",
-				},
-			},
-		})
+	vapidProperties := parsedProperties.VAPID
+	ephemeralVAPIDKeys := false
+	if vapidProperties == nil {
+		// Without explicit keys, the keys are kept next to the subscriptions, so that they
+		// survive a restart like the subscriptions made with them. The InMemory repository
+		// loses both on restart.
+		switch parsedProperties.RepositoryType {
+		case RepositoryTypeDynamoDB:
+			vapidProperties = &VAPIDProperties{VAPIDKeySourceProperties: VAPIDKeySourceProperties{Source: VAPIDSourceDynamoDB}}
+		case RepositoryTypeFile, RepositoryTypeSQLite:
+			vapidProperties = &VAPIDProperties{VAPIDKeySourceProperties: VAPIDKeySourceProperties{
+				Source: VAPIDSourceGenerated,
+				Path:   parsedProperties.RepositoryPath + vapidKeysFileSuffix,
+			}}
+		default:
+			ephemeralVAPIDKeys = true
+		}
+	}
 
+	var vapidKeys VAPIDKeys
+	previousVAPIDKeys := make([]VAPIDKeys, 0)
+	if ephemeralVAPIDKeys {
+		keys, err := GenerateVAPIDKeys()
 		if err != nil {
-			return nil, fmt.Errorf("GetItem for vapid failed. err: %s", err)
+			return nil, err
 		}
+		vapidKeys = keys
+	} else {
+		keys, err := vapidProperties.load(context.Background(), dynamoDB)
+		if err != nil {
+			return nil, err
+		}
+		vapidKeys = keys
 
-		if output.Item == nil {
-			_, err := dynamodbClient.PutItem(context.Background(), &dynamodb.PutItemInput{
-				TableName: aws.String("notifier-config"),
-				Item: map[string]types.AttributeValue{
-					"Key": &types.AttributeValueMemberS{
-						Value: "vapid",
-					},
-					"Value": &types.AttributeValueMemberS{
-						Value: fmt.Sprintf("%s %s", vapidPrivateKey, vapidPublicKey),
-					},
-				},
-			})
-			if err != nil {
-				return nil, fmt.Errorf("PutItem for vapid failed. err: %s", err)
-			}
-
-		} else {
-			vapid := struct {
-				Key   string `dynamodbav:"Key"`
-				Value string `dynamodbav:"Value"`
-			}{}
-
-			err = attributevalue.UnmarshalMap(output.Item, &vapid)
+		for i, previous := range vapidProperties.Previous {
+			keys, err := previous.load(context.Background(), dynamoDB)
 			if err != nil {
-				return nil, fmt.Errorf("Unmarshal for vapid failed. err: %s", err)
+				return nil, fmt.Errorf("previous[%d]: %w", i, err)
 			}
-
-			splitted := strings.Split(vapid.Value, " ")
-			vapidPrivateKey = splitted[0]
-			vapidPublicKey = splitted[1]
+			previousVAPIDKeys = append(previousVAPIDKeys, keys)
 		}
+	}
 
-	case "InMemory":
-		subscriptionRepository = NewInMemorySubscriptionRepository()
-	default:
-		return nil, fmt.Errorf("repositoryType is invalid. repositoryType: %s", parsedProperties.RepositoryType)
+	// The repository is opened last, so that it is not left open when building fails.
+	subscriptionRepository, err := parsedProperties.SubscriptionRepositoryProperties.open(context.Background(), dynamoDB)
+	if err != nil {
+		return nil, err
+	}
+	if parsedProperties.SubscriptionCacheTTL > 0 {
+		subscriptionRepository = newCachedSubscriptionRepository(subscriptionRepository, parsedProperties.SubscriptionCacheTTL)
 	}
 
 	return NewSender(&webPushSenderImpl{
@@ -121,15 +263,30 @@ func WebPushSenderBuilder(id string, properties yaml.Node) (abstraction.Abstract
 		listenAddress:          parsedProperties.ListenAddress,
 		defaultSubscriber:      parsedProperties.DefaultSubscriber,
 		subscriptionRepository: subscriptionRepository,
-		vapidPrivateKey:        vapidPrivateKey,
-		vapidPublicKey:         vapidPublicKey,
+		vapidKeys:              vapidKeys,
+		previousVAPIDKeys:      previousVAPIDKeys,
+		ephemeralVAPIDKeys:     ephemeralVAPIDKeys,
+		httpClient:             newWebPushHTTPClient(parsedProperties.Concurrency),
+		maxRetries:             parsedProperties.MaxRetries,
+		maxRetryDelay:          parsedProperties.MaxRetryDelay,
+		retryBackoff:           defaultWebPushRetryBackoff,
+		concurrency:            parsedProperties.Concurrency,
+		requestTimeout:         parsedProperties.RequestTimeout,
+		urgencies:              urgencies,
+		ttls:                   ttls,
+		topicLabel:             parsedProperties.TopicLabel,
+		subscriptionPage:       subscriptionPage,
+		cors:                   parsedProperties.CORS,
+		adminToken:             parsedProperties.AdminToken,
 	}), nil
 }
 
+// SubscriptionRepository stores subscriptions keyed by their endpoint. Requests stop when ctx
+// is done.
 type SubscriptionRepository interface {
-	LoadAll() ([]webpush.Subscription, error)
-	Store(subscription webpush.Subscription) error
-	Delete(subscription webpush.Subscription) error
+	LoadAll(ctx context.Context) ([]WebPushSubscription, error)
+	Store(ctx context.Context, subscription WebPushSubscription) error
+	Delete(ctx context.Context, subscription WebPushSubscription) error
 }
 
 type InMemorySubscriptionRepository struct {
@@ -142,11 +299,11 @@ func NewInMemorySubscriptionRepository() *InMemorySubscriptionRepository {
 	}
 }
 
-func (imsr *InMemorySubscriptionRepository) LoadAll() ([]webpush.Subscription, error) {
-	subscriptions := make([]webpush.Subscription, 0)
+func (imsr *InMemorySubscriptionRepository) LoadAll(ctx context.Context) ([]WebPushSubscription, error) {
+	subscriptions := make([]WebPushSubscription, 0)
 
 	imsr.subscriptionMap.Range(func(key, value interface{}) bool {
-		subscription := value.(webpush.Subscription)
+		subscription := value.(WebPushSubscription)
 
 		subscriptions = append(subscriptions, subscription)
 
@@ -157,13 +314,13 @@ func (imsr *InMemorySubscriptionRepository) LoadAll() ([]webpush.Subscription, e
 
 }
 
-func (imsr *InMemorySubscriptionRepository) Store(subscription webpush.Subscription) error {
+func (imsr *InMemorySubscriptionRepository) Store(ctx context.Context, subscription WebPushSubscription) error {
 	imsr.subscriptionMap.Store(subscription.Endpoint, subscription)
 
 	return nil
 }
 
-func (imsr *InMemorySubscriptionRepository) Delete(subscription webpush.Subscription) error {
+func (imsr *InMemorySubscriptionRepository) Delete(ctx context.Context, subscription WebPushSubscription) error {
 
 	_, ok := imsr.subscriptionMap.Load(subscription.Endpoint)
 	if ok {
@@ -173,33 +330,134 @@ func (imsr *InMemorySubscriptionRepository) Delete(subscription webpush.Subscrip
 	return nil
 }
 
+// cachedSubscriptionRepository reuses the subscriptions loaded by LoadAll until ttl elapses or
+// a subscription is stored or deleted through it.
+type cachedSubscriptionRepository struct {
+	repository SubscriptionRepository
+	ttl        time.Duration
+
+	lock          sync.Mutex
+	subscriptions []WebPushSubscription
+	loadedAt      time.Time
+	// generation is incremented by invalidate, so that a LoadAll that started before an
+	// invalidation does not cache its stale result.
+	generation int
+}
+
+func newCachedSubscriptionRepository(repository SubscriptionRepository, ttl time.Duration) *cachedSubscriptionRepository {
+	return &cachedSubscriptionRepository{
+		repository: repository,
+		ttl:        ttl,
+	}
+}
+
+func (csr *cachedSubscriptionRepository) LoadAll(ctx context.Context) ([]WebPushSubscription, error) {
+	csr.lock.Lock()
+	if csr.subscriptions != nil && time.Since(csr.loadedAt) < csr.ttl {
+		subscriptions := slices.Clone(csr.subscriptions)
+		csr.lock.Unlock()
+		return subscriptions, nil
+	}
+	generation := csr.generation
+	csr.lock.Unlock()
+
+	subscriptions, err := csr.repository.LoadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	csr.lock.Lock()
+	if generation == csr.generation {
+		csr.subscriptions = slices.Clone(subscriptions)
+		csr.loadedAt = time.Now()
+	}
+	csr.lock.Unlock()
+
+	return subscriptions, nil
+}
+
+func (csr *cachedSubscriptionRepository) Store(ctx context.Context, subscription WebPushSubscription) error {
+	defer csr.invalidate()
+	return csr.repository.Store(ctx, subscription)
+}
+
+func (csr *cachedSubscriptionRepository) Delete(ctx context.Context, subscription WebPushSubscription) error {
+	defer csr.invalidate()
+	return csr.repository.Delete(ctx, subscription)
+}
+
+func (csr *cachedSubscriptionRepository) invalidate() {
+	csr.lock.Lock()
+	defer csr.lock.Unlock()
+
+	csr.subscriptions = nil
+	csr.generation++
+}
+
+// Close closes the cached repository, if it holds resources.
+func (csr *cachedSubscriptionRepository) Close() error {
+	if closer, ok := csr.repository.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// dynamoDBKeyPrefixAttribute holds the keyPrefix of the sender owning a subscription item. Items
+// of senders without a prefix do not have it.
+const dynamoDBKeyPrefixAttribute = "KeyPrefix"
+
+// DynamoDBSubscriptionRepository stores subscriptions in the subscriptionsTable configured by
+// DynamoDBProperties. The keyPrefix is prepended to the Endpoint attribute of its items and
+// stored in their KeyPrefix attribute, and only items whose KeyPrefix equals it are loaded, so
+// that neither a sender without a prefix nor one whose prefix starts the same loads them.
 type DynamoDBSubscriptionRepository struct {
 	dynamodbClient *dynamodb.Client
+	table          string
+	keyPrefix      string
+	requestTimeout time.Duration
 }
 
-func NewDynamoDBSubscriptionRepository(dynamodbClient *dynamodb.Client) *DynamoDBSubscriptionRepository {
+func NewDynamoDBSubscriptionRepository(dynamodbClient *dynamodb.Client, properties DynamoDBProperties) *DynamoDBSubscriptionRepository {
 	return &DynamoDBSubscriptionRepository{
 		dynamodbClient: dynamodbClient,
+		table:          properties.SubscriptionsTable,
+		keyPrefix:      properties.KeyPrefix,
+		requestTimeout: properties.RequestTimeout,
 	}
 }
 
-func (ddbr *DynamoDBSubscriptionRepository) LoadAll() ([]webpush.Subscription, error) {
+func (ddbr *DynamoDBSubscriptionRepository) LoadAll(ctx context.Context) ([]WebPushSubscription, error) {
 	scanInput := &dynamodb.ScanInput{
-		TableName: aws.String("notifier-subscriptions"),
+		TableName: aws.String(ddbr.table),
+	}
+	scanInput.ExpressionAttributeNames = map[string]string{"#keyPrefix": dynamoDBKeyPrefixAttribute}
+	if ddbr.keyPrefix == "" {
+		scanInput.FilterExpression = aws.String("attribute_not_exists(#keyPrefix)")
+	} else {
+		scanInput.FilterExpression = aws.String("#keyPrefix = :keyPrefix")
+		scanInput.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":keyPrefix": &types.AttributeValueMemberS{Value: ddbr.keyPrefix},
+		}
 	}
 
-	subscriptions := make([]webpush.Subscription, 0)
+	subscriptions := make([]WebPushSubscription, 0)
 
 	for {
-		output, err := ddbr.dynamodbClient.Scan(context.Background(), scanInput)
+		scanCtx, cancel := context.WithTimeout(ctx, ddbr.requestTimeout)
+		output, err := ddbr.dynamodbClient.Scan(scanCtx, scanInput)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("Scan to DynamoDB failed. err: %s", err.Error())
+		}
 
 		for _, item := range output.Items {
-			subscription := webpush.Subscription{}
+			subscription := WebPushSubscription{}
 
 			err = attributevalue.UnmarshalMap(item, &subscription)
 			if err != nil {
 				return nil, fmt.Errorf("Unmarshaling subscription from DynamoDB AttributeValue failed. err: %s", err.Error())
 			}
+			subscription.Endpoint = strings.TrimPrefix(subscription.Endpoint, ddbr.keyPrefix)
 
 			subscriptions = append(subscriptions, subscription)
 		}
@@ -213,14 +471,20 @@ func (ddbr *DynamoDBSubscriptionRepository) LoadAll() ([]webpush.Subscription, e
 	return subscriptions, nil
 }
 
-func (ddbr *DynamoDBSubscriptionRepository) Store(subscription webpush.Subscription) error {
+func (ddbr *DynamoDBSubscriptionRepository) Store(ctx context.Context, subscription WebPushSubscription) error {
 	av, err := attributevalue.MarshalMap(subscription)
 	if err != nil {
 		return fmt.Errorf("Marshaling subscription to DynamoDB AttributeValue failed. err: %s", err.Error())
 	}
+	av["Endpoint"] = ddbr.key(subscription)
+	if ddbr.keyPrefix != "" {
+		av[dynamoDBKeyPrefixAttribute] = &types.AttributeValueMemberS{Value: ddbr.keyPrefix}
+	}
 
-	_, err = ddbr.dynamodbClient.PutItem(context.Background(), &dynamodb.PutItemInput{
-		TableName: aws.String("notifier-subscriptions"),
+	ctx, cancel := context.WithTimeout(ctx, ddbr.requestTimeout)
+	defer cancel()
+	_, err = ddbr.dynamodbClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(ddbr.table),
 		Item:      av,
 	})
 
@@ -231,13 +495,13 @@ func (ddbr *DynamoDBSubscriptionRepository) Store(subscription webpush.Subscript
 	return nil
 }
 
-func (ddbr *DynamoDBSubscriptionRepository) Delete(subscription webpush.Subscription) error {
-	_, err := ddbr.dynamodbClient.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
-		TableName: aws.String("notifier-subscriptions"),
+func (ddbr *DynamoDBSubscriptionRepository) Delete(ctx context.Context, subscription WebPushSubscription) error {
+	ctx, cancel := context.WithTimeout(ctx, ddbr.requestTimeout)
+	defer cancel()
+	_, err := ddbr.dynamodbClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(ddbr.table),
 		Key: map[string]types.AttributeValue{
-			"Endpoint": &types.AttributeValueMemberS{
-				Value: subscription.Endpoint,
-			},
+			"Endpoint": ddbr.key(subscription),
 		},
 	})
 
@@ -248,14 +512,44 @@ func (ddbr *DynamoDBSubscriptionRepository) Delete(subscription webpush.Subscrip
 	return nil
 }
 
+func (ddbr *DynamoDBSubscriptionRepository) key(subscription WebPushSubscription) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: ddbr.keyPrefix + subscription.Endpoint}
+}
+
 type webPushSenderImpl struct {
 	id                     string
 	logger                 *slog.Logger
 	listenAddress          string
 	defaultSubscriber      string
-	vapidPrivateKey        string
-	vapidPublicKey         string
+	vapidKeys              VAPIDKeys
+	previousVAPIDKeys      []VAPIDKeys
+	ephemeralVAPIDKeys     bool
 	subscriptionRepository SubscriptionRepository
+	httpClient             *http.Client
+	maxRetries             int
+	maxRetryDelay          time.Duration
+	// retryBackoff is the first delay before retrying an answer without Retry-After.
+	retryBackoff   time.Duration
+	concurrency    int
+	requestTimeout time.Duration
+	urgencies      severityMapping[webpush.Urgency]
+	ttls           severityMapping[time.Duration]
+	topicLabel     string
+	// subscriptionPage is nil when the page is not served.
+	subscriptionPage *SubscriptionPageProperties
+	// cors is nil when only pages of the same origin may use the API.
+	cors *CORSProperties
+	// adminToken is empty when there is no admin.
+	adminToken string
+	onDelivery DeliveryHandler
+}
+
+// newWebPushHTTPClient returns the client shared by every delivery of a sender. It keeps
+// enough idle connections per push service for concurrent deliveries.
+func newWebPushHTTPClient(concurrency int) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = concurrency
+	return &http.Client{Transport: tracing.NewTransport(transport)}
 }
 
 func (wpsi *webPushSenderImpl) GetId() string {
@@ -270,132 +564,64 @@ func (wpsi *webPushSenderImpl) SetLogger(logger *slog.Logger) {
 	wpsi.logger = logger
 }
 
+func (wpsi *webPushSenderImpl) SetDeliveryHandler(handler DeliveryHandler) {
+	wpsi.onDelivery = handler
+}
+
 func (wpsi *webPushSenderImpl) Start(inputCh <-chan notification.Notification, done <-chan struct{}) <-chan error {
 	retCh := make(chan error)
 
-	serveMux := http.NewServeMux()
-
-	serveMux.HandleFunc("GET /publickey", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/plain")
-		w.Write([]byte(wpsi.vapidPublicKey))
-	})
-
-	serveMux.HandleFunc("POST /subscriptions", func(w http.ResponseWriter, r *http.Request) {
-		var subscription webpush.Subscription
-		err := json.NewDecoder(r.Body).Decode(&subscription)
-		if err != nil {
-			wpsi.GetLogger().Error("Decoding posted subscription to JSON failed", "err", err)
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		err = wpsi.subscriptionRepository.Store(subscription)
-		if err != nil {
-			wpsi.GetLogger().Error("Store subscription to repository failed", "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		wpsi.GetLogger().Info("Receive subscription")
-		w.WriteHeader(http.StatusOK)
-	})
-
-	serveMux.HandleFunc("DELETE /subscriptions", func(w http.ResponseWriter, r *http.Request) {
-		var subscription webpush.Subscription
-		err := json.NewDecoder(r.Body).Decode(&subscription)
-		if err != nil {
-			wpsi.GetLogger().Error("Decoding passed subscription to JSON failed", "err", err)
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		err = wpsi.subscriptionRepository.Delete(subscription)
-		if err != nil {
-			wpsi.GetLogger().Error("Delete subscription from repository failed", "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		wpsi.GetLogger().Info("Delete subscription")
-
-		w.WriteHeader(http.StatusNoContent)
-		return
-	})
-
-	serveMux.HandleFunc("GET /subscriptions", func(w http.ResponseWriter, r *http.Request) {
-		subscriptions, err := wpsi.subscriptionRepository.LoadAll()
-		if err != nil {
-			wpsi.GetLogger().Error("LoadAll subscription from repository failed", "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		endpoints := make([]string, 0)
-
-		for _, subscription := range subscriptions {
-			endpoints = append(endpoints, subscription.Endpoint)
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(endpoints)
-	})
+	if wpsi.ephemeralVAPIDKeys {
+		wpsi.GetLogger().Warn("VAPID keys are generated for this run only, subscriptions will stop working after restart. Configure vapid to keep them")
+	}
 
 	s := &http.Server{
-		Addr: wpsi.listenAddress,
-		// TODO セキュリティ的によくないので環境変数経由で指定できるように設定する
-		Handler: cors.AllowAll().Handler(serveMux),
+		Addr:    wpsi.listenAddress,
+		Handler: wpsi.apiHandler(),
 	}
 
 	shutdownFunc := func() {
 		s.Shutdown(context.Background())
 	}
 
+	// stopCtx cancels requests and retries in progress when the sender stops, and ends the
+	// goroutines of this run when it fails, since done stays open until the sender restarts.
+	stopCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-done:
+		case <-stopCtx.Done():
+		}
+		cancel()
+	}()
+
 	errCh := make(chan error)
 	go func() {
-		defer close(errCh)
 		err := s.ListenAndServe()
-		errCh <- err
+		select {
+		case errCh <- err:
+		case <-stopCtx.Done():
+		}
 	}()
 
+	deliveryDone := make(chan struct{})
 	go func() {
+		defer close(deliveryDone)
 		for {
 			select {
 			case n, ok := <-inputCh:
 				if !ok {
 					wpsi.GetLogger().Info("inputCh closed")
-				} else {
-					subscriptions, err := wpsi.subscriptionRepository.LoadAll()
-
-					if err != nil {
-						wpsi.GetLogger().Error("LoadAll subscription from repository failed", "err", err)
-						errCh <- err
-						return
-					}
-
-					data, err := json.Marshal(n)
-					if err != nil {
-						wpsi.GetLogger().Error("Marshal notification failed", "err", err)
-						errCh <- err
-						return
-					}
-
-					for _, subscription := range subscriptions {
-						res, err := webpush.SendNotification(data, &subscription, &webpush.Options{
-							Subscriber:      wpsi.defaultSubscriber,
-							VAPIDPublicKey:  wpsi.vapidPublicKey,
-							VAPIDPrivateKey: wpsi.vapidPrivateKey,
-						})
-
-						wpsi.GetLogger().Info("Notify send to WebPush Endpoint from webPushSender", "response", res.Status)
-
-						if err != nil {
-							wpsi.GetLogger().Error("SendNotification failed", "err", err)
-							errCh <- err
-							break
-						}
+					return
+				}
+				if err := wpsi.deliver(stopCtx, n); err != nil {
+					select {
+					case errCh <- err:
+					case <-stopCtx.Done():
 					}
+					return
 				}
-			case <-done:
+			case <-stopCtx.Done():
 				return
 			}
 		}
@@ -403,16 +629,306 @@ func (wpsi *webPushSenderImpl) Start(inputCh <-chan notification.Notification, d
 
 	go func() {
 		defer close(retCh)
+		defer cancel()
 		select {
 		case err := <-errCh:
+			cancel()
 			shutdownFunc()
+			// The next run reads inputCh, so this one must have stopped reading it.
+			<-deliveryDone
 			retCh <- err
 			return
 		case <-done:
 			shutdownFunc()
+			<-deliveryDone
+			// The repository is kept open across restarts, and closed when the sender stops.
+			if closer, ok := wpsi.subscriptionRepository.(io.Closer); ok {
+				if err := closer.Close(); err != nil {
+					wpsi.GetLogger().Error("Closing subscription repository failed", "err", err)
+				}
+			}
 			return
 		}
 	}()
 
 	return retCh
 }
+
+// subscriptionOutcome is the result of sending a notification to one subscription.
+type subscriptionOutcome int
+
+const (
+	subscriptionDelivered subscriptionOutcome = iota
+	// subscriptionExpired is the outcome for subscriptions the push service no longer knows.
+	// They are deleted from the repository.
+	subscriptionExpired
+	subscriptionFailed
+)
+
+// deliver sends n to every subscription. The outcome of each subscription is handled on its
+// own, so that a failing subscription does not prevent delivery to the others. The returned
+// error stops the sender, so it is only returned for faults of the sender itself, such as a
+// notification that cannot be encoded. Delivery failures, including a repository that cannot
+// be read, are only reported.
+func (wpsi *webPushSenderImpl) deliver(ctx context.Context, n notification.Notification) error {
+	_, span := startSendSpan(n, wpsi.id)
+	ctx = trace.ContextWithSpan(ctx, span)
+
+	subscriptions, err := wpsi.subscriptionRepository.LoadAll(ctx)
+	if err != nil {
+		wpsi.GetLogger().Error("LoadAll subscription from repository failed", "err", err)
+		wpsi.report(n, span, err)
+		return nil
+	}
+
+	// The trace context is of no use to the browsers receiving the payload.
+	payload := n
+	payload.TraceContext = nil
+	data, err := json.Marshal(payload)
+	if err != nil {
+		wpsi.GetLogger().Error("Marshal notification failed", "err", err)
+		wpsi.report(n, span, err)
+		return err
+	}
+	message := wpsi.newPushMessage(n, data)
+
+	// Each subscription receives only the notifications its filter selects.
+	matched := make([]WebPushSubscription, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		if subscription.Filter.Matches(n) {
+			matched = append(matched, subscription)
+		}
+	}
+
+	// Up to concurrency workers send to the subscriptions, so that a slow push service only
+	// holds up its own subscriptions.
+	subscriptionCh := make(chan WebPushSubscription)
+	outcomeCh := make(chan subscriptionOutcome)
+	var wg sync.WaitGroup
+	for range min(wpsi.concurrency, len(matched)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for subscription := range subscriptionCh {
+				outcomeCh <- wpsi.sendWithRetry(ctx, message, subscription)
+			}
+		}()
+	}
+	go func() {
+		defer close(subscriptionCh)
+		for _, subscription := range matched {
+			subscriptionCh <- subscription
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(outcomeCh)
+	}()
+
+	delivered, expired, failed := 0, 0, 0
+	for outcome := range outcomeCh {
+		switch outcome {
+		case subscriptionDelivered:
+			delivered++
+		case subscriptionExpired:
+			expired++
+		case subscriptionFailed:
+			failed++
+		}
+	}
+	wpsi.GetLogger().Info("Notify send to WebPush Endpoints from webPushSender",
+		"title", n.Title, "subscriptions", len(subscriptions), "filtered", len(subscriptions)-len(matched), "delivered", delivered, "expired", expired, "failed", failed)
+
+	// A notification that reached some subscriptions, or that no subscription selected, is
+	// delivered.
+	var deliveryErr error
+	if failed > 0 && delivered == 0 {
+		deliveryErr = fmt.Errorf("sending to %d subscriptions failed", failed)
+	}
+	wpsi.report(n, span, deliveryErr)
+	return nil
+}
+
+// pushMessage is what is sent to every subscription for one notification.
+type pushMessage struct {
+	data    []byte
+	ttl     time.Duration
+	urgency webpush.Urgency
+	// topic is empty for notifications without the topic label.
+	topic string
+}
+
+// newPushMessage chooses the TTL and urgency of n by its severity, and derives its topic from
+// the topic label.
+func (wpsi *webPushSenderImpl) newPushMessage(n notification.Notification, data []byte) pushMessage {
+	message := pushMessage{
+		data:    data,
+		ttl:     wpsi.ttls.lookup(n.Severity),
+		urgency: wpsi.urgencies.lookup(n.Severity),
+	}
+	if value, ok := n.Labels[wpsi.topicLabel]; ok && wpsi.topicLabel != "" && value != "" {
+		message.topic = webPushTopic(value)
+	}
+	return message
+}
+
+// webPushTopic derives the Topic header from value. Topics are at most 32 characters of the
+// URL-safe base64 alphabet, so value is hashed rather than used as is.
+func webPushTopic(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(sum[:])[:maxWebPushTopicLength]
+}
+
+func (wpsi *webPushSenderImpl) report(n notification.Notification, span trace.Span, err error) {
+	tracing.End(span, err)
+	if wpsi.onDelivery != nil {
+		wpsi.onDelivery(n, err)
+	}
+}
+
+// sendWithRetry sends message to subscription, retrying while the push service answers 429 or
+// 5xx, after the delay of its Retry-After header or an exponential backoff.
+func (wpsi *webPushSenderImpl) sendWithRetry(ctx context.Context, message pushMessage, subscription WebPushSubscription) subscriptionOutcome {
+	logger := wpsi.GetLogger().With("endpoint", subscription.Endpoint, "owner", subscription.Owner)
+	backoff := wpsi.retryBackoff
+
+	for attempt := 0; ; attempt++ {
+		res, err := wpsi.sendToSubscription(ctx, message, subscription.Subscription)
+		if err != nil {
+			logger.Error("SendNotification failed", "err", err)
+			return subscriptionFailed
+		}
+		res.Body.Close()
+
+		switch {
+		case res.StatusCode >= 200 && res.StatusCode < 300:
+			return subscriptionDelivered
+
+		case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+			logger.Info("Subscription expired, deleting it", "response", res.Status)
+			if err := wpsi.subscriptionRepository.Delete(ctx, subscription); err != nil {
+				logger.Error("Delete expired subscription from repository failed", "err", err)
+			}
+			return subscriptionExpired
+
+		case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+			if attempt >= wpsi.maxRetries {
+				logger.Error("SendNotification failed after retries", "response", res.Status, "attempts", attempt+1)
+				return subscriptionFailed
+			}
+
+			delay, ok := retryAfter(res.Header.Get("Retry-After"), time.Now())
+			if !ok {
+				delay = backoff
+				backoff *= 2
+			}
+			delay = min(delay, wpsi.maxRetryDelay)
+			logger.Warn("SendNotification will be retried", "response", res.Status, "delay", delay)
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return subscriptionFailed
+			}
+
+		default:
+			logger.Error("SendNotification rejected", "response", res.Status)
+			return subscriptionFailed
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header, either a number of seconds or an HTTP date.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// sendToSubscription signs with the current VAPID key. Push services answer 401 or 403 when
+// the key does not match the one the subscription was made with, in which case the previous
+// keys are tried in order.
+func (wpsi *webPushSenderImpl) sendToSubscription(ctx context.Context, message pushMessage, subscription webpush.Subscription) (*http.Response, error) {
+	keys := append([]VAPIDKeys{wpsi.vapidKeys}, wpsi.previousVAPIDKeys...)
+
+	var res *http.Response
+	var err error
+	for i, key := range keys {
+		res, err = wpsi.sendWithKey(ctx, message, subscription, key)
+		if err != nil || i == len(keys)-1 {
+			break
+		}
+		if res.StatusCode != http.StatusUnauthorized && res.StatusCode != http.StatusForbidden {
+			break
+		}
+		res.Body.Close()
+	}
+	return res, err
+}
+
+// sendWithKey sends one request, bounded by requestTimeout. The body of the response is read,
+// up to maxWebPushResponseBody, before returning, since the request context ends with this
+// call, so that the connection can be reused.
+func (wpsi *webPushSenderImpl) sendWithKey(ctx context.Context, message pushMessage, subscription webpush.Subscription, key VAPIDKeys) (*http.Response, error) {
+	if wpsi.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wpsi.requestTimeout)
+		defer cancel()
+	}
+
+	// webpush-go pads the message in place, appending to the spare capacity of data, so each
+	// request is given its own copy since subscriptions are sent to concurrently.
+	res, err := webpush.SendNotificationWithContext(ctx, bytes.Clone(message.data), &subscription, &webpush.Options{
+		HTTPClient:      wpsi.httpClient,
+		Subscriber:      wpsi.defaultSubscriber,
+		VAPIDPublicKey:  key.PublicKey,
+		VAPIDPrivateKey: key.PrivateKey,
+		TTL:             int(message.ttl / time.Second),
+		Urgency:         message.urgency,
+		Topic:           message.topic,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxWebPushResponseBody))
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return res, nil
+}
+
+// parseWebPushUrgencyMapping builds a severity mapping whose values are Web Push urgencies.
+func parseWebPushUrgencyMapping(table map[string]string) (severityMapping[webpush.Urgency], error) {
+	urgencies := make(map[string]webpush.Urgency, len(table))
+	for key, value := range table {
+		urgency := webpush.Urgency(value)
+		switch urgency {
+		case webpush.UrgencyVeryLow, webpush.UrgencyLow, webpush.UrgencyNormal, webpush.UrgencyHigh:
+		default:
+			return nil, fmt.Errorf("urgency %q is invalid, it should be very-low, low, normal or high", value)
+		}
+		urgencies[key] = urgency
+	}
+
+	return parseSeverityMapping(urgencies)
+}
+
+// parseWebPushTTLMapping builds a severity mapping whose values are TTLs of pushes.
+func parseWebPushTTLMapping(table map[string]time.Duration) (severityMapping[time.Duration], error) {
+	for key, ttl := range table {
+		if ttl < 0 || ttl > maxWebPushTTL {
+			return nil, fmt.Errorf("ttl of %s should be between 0 and %s", key, maxWebPushTTL)
+		}
+	}
+
+	return parseSeverityMapping(table)
+}
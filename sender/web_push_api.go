@@ -0,0 +1,365 @@
+package sender
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Kotaro7750/notifier/notification"
+
+	"github.com/rs/cors"
+)
+
+const webPushAdminTokenEnvVar = "WEBPUSH_ADMIN_TOKEN"
+
+// CORSProperties allows pages of other origins to use the API of the WebPush sender. Without
+// it, only pages served by the sender, such as the subscription page, can use the API.
+type CORSProperties struct {
+	// AllowedOrigins are origins such as https://example.com, or * for every origin.
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	// AllowedMethods are GET, POST and DELETE by default.
+	AllowedMethods []string `yaml:"allowedMethods"`
+	// AllowedHeaders are Content-Type and Authorization by default.
+	AllowedHeaders []string `yaml:"allowedHeaders"`
+	// MaxAge is how long browsers may cache the answer to a preflight request.
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+func (p CORSProperties) Validate() error {
+	if len(p.AllowedOrigins) == 0 {
+		return fmt.Errorf("allowedOrigins must contain at least one origin")
+	}
+	for _, origin := range p.AllowedOrigins {
+		if origin == "" {
+			return fmt.Errorf("allowedOrigins must not contain an empty origin")
+		}
+	}
+
+	for _, method := range p.AllowedMethods {
+		if method == "" || strings.ToUpper(method) != method {
+			return fmt.Errorf("allowedMethods should be upper case methods: %q", method)
+		}
+	}
+
+	if p.MaxAge < 0 {
+		return fmt.Errorf("maxAge should be greater than or equal to 0")
+	}
+
+	return nil
+}
+
+func (p CORSProperties) handler(h http.Handler) http.Handler {
+	methods := p.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodPost, http.MethodDelete}
+	}
+	headers := p.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"Content-Type", "Authorization"}
+	}
+
+	return cors.New(cors.Options{
+		AllowedOrigins: p.AllowedOrigins,
+		AllowedMethods: methods,
+		AllowedHeaders: headers,
+		MaxAge:         int(p.MaxAge / time.Second),
+	}).Handler(h)
+}
+
+// subscriptionResponse is the answer to a stored subscription. Secret authenticates later
+// changes of the subscription as a bearer token. It is only known to the browser that stored
+// the subscription, since the repository keeps its hash.
+type subscriptionResponse struct {
+	Secret string `json:"secret,omitempty"`
+}
+
+// apiHandler serves the public key, the subscription API and the subscription page.
+//
+// Storing a new subscription answers its secret. Replacing, deleting or sending a test
+// notification to a subscription that has a secret requires it, or the admin token, as a
+// bearer token. Replacing a subscription stored without a secret requires the admin token,
+// and answers the secret given to it. Listing subscriptions requires the admin token, and is
+// disabled without one.
+func (wpsi *webPushSenderImpl) apiHandler() http.Handler {
+	serveMux := http.NewServeMux()
+
+	serveMux.HandleFunc("GET /publickey", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(wpsi.vapidKeys.PublicKey))
+	})
+
+	serveMux.HandleFunc("POST /subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		var subscription WebPushSubscription
+		err := json.NewDecoder(r.Body).Decode(&subscription)
+		if err != nil {
+			wpsi.GetLogger().Error("Decoding posted subscription to JSON failed", "err", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := subscription.Validate(); err != nil {
+			wpsi.GetLogger().Error("Posted subscription is invalid", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		stored, err := wpsi.findSubscription(r.Context(), subscription.Endpoint)
+		if err != nil {
+			wpsi.GetLogger().Error("LoadAll subscription from repository failed", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var response subscriptionResponse
+		if stored != nil && stored.SecretHash != "" {
+			if !wpsi.authorizeSubscription(w, r, *stored) {
+				return
+			}
+			subscription.SecretHash = stored.SecretHash
+			if !wpsi.isAdmin(r) {
+				response.Secret = bearerToken(r)
+			}
+		} else {
+			// Knowing the endpoint of a subscription stored without a secret does not prove
+			// owning it, so only the admin may give it a secret.
+			if stored != nil && !wpsi.isAdmin(r) {
+				wpsi.GetLogger().Warn("Refused to set secret of legacy subscription without admin token", "owner", stored.Owner)
+				denyAccess(w, r)
+				return
+			}
+			secret, err := newSubscriptionSecret()
+			if err != nil {
+				wpsi.GetLogger().Error("Generating subscription secret failed", "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			subscription.SecretHash = hashSubscriptionSecret(secret)
+			response.Secret = secret
+		}
+
+		err = wpsi.subscriptionRepository.Store(r.Context(), subscription)
+		if err != nil {
+			wpsi.GetLogger().Error("Store subscription to repository failed", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		wpsi.GetLogger().Info("Receive subscription", "owner", subscription.Owner)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+
+	serveMux.HandleFunc("DELETE /subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		var subscription WebPushSubscription
+		err := json.NewDecoder(r.Body).Decode(&subscription)
+		if err != nil {
+			wpsi.GetLogger().Error("Decoding passed subscription to JSON failed", "err", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		stored, err := wpsi.findSubscription(r.Context(), subscription.Endpoint)
+		if err != nil {
+			wpsi.GetLogger().Error("LoadAll subscription from repository failed", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if stored == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if !wpsi.authorizeSubscription(w, r, *stored) {
+			return
+		}
+
+		err = wpsi.subscriptionRepository.Delete(r.Context(), *stored)
+		if err != nil {
+			wpsi.GetLogger().Error("Delete subscription from repository failed", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		wpsi.GetLogger().Info("Delete subscription", "owner", stored.Owner)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	serveMux.HandleFunc("POST /subscriptions/test", func(w http.ResponseWriter, r *http.Request) {
+		var subscription WebPushSubscription
+		err := json.NewDecoder(r.Body).Decode(&subscription)
+		if err != nil {
+			wpsi.GetLogger().Error("Decoding passed subscription to JSON failed", "err", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		stored, err := wpsi.findSubscription(r.Context(), subscription.Endpoint)
+		if err != nil {
+			wpsi.GetLogger().Error("LoadAll subscription from repository failed", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if stored == nil {
+			http.Error(w, "subscription is not stored", http.StatusNotFound)
+			return
+		}
+		if !wpsi.authorizeSubscription(w, r, *stored) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(wpsi.sendTestNotification(r.Context(), *stored))
+	})
+
+	serveMux.HandleFunc("GET /subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		if wpsi.adminToken == "" {
+			http.Error(w, "listing subscriptions requires adminToken to be configured", http.StatusForbidden)
+			return
+		}
+		if !wpsi.isAdmin(r) {
+			denyAccess(w, r)
+			return
+		}
+
+		subscriptions, err := wpsi.subscriptionRepository.LoadAll(r.Context())
+		if err != nil {
+			wpsi.GetLogger().Error("LoadAll subscription from repository failed", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		endpoints := make([]string, 0)
+
+		for _, subscription := range subscriptions {
+			endpoints = append(endpoints, subscription.Endpoint)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(endpoints)
+	})
+
+	if wpsi.subscriptionPage != nil {
+		registerSubscriptionPage(serveMux, *wpsi.subscriptionPage)
+	}
+
+	if wpsi.cors != nil {
+		return wpsi.cors.handler(serveMux)
+	}
+	return serveMux
+}
+
+// testNotificationResult is the answer of the push service to a test notification. Error is
+// set instead when the push service could not be reached.
+type testNotificationResult struct {
+	StatusCode int    `json:"status_code,omitempty"`
+	Body       string `json:"body,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// sendTestNotification sends a synthetic notification to subscription alone, without retrying
+// nor deleting expired subscriptions, so that the answer of the push service is reported as it
+// is.
+func (wpsi *webPushSenderImpl) sendTestNotification(ctx context.Context, subscription WebPushSubscription) testNotificationResult {
+	n := notification.Notification{
+		Id:                 notification.NewId(),
+		Title:              "Test notification",
+		Severity:           notification.SeverityInfo,
+		Message:            "This subscription receives notifications.",
+		NotificationSource: "notifier",
+		Labels:             map[string]string{},
+	}
+	data, err := json.Marshal(n)
+	if err != nil {
+		return testNotificationResult{Error: err.Error()}
+	}
+
+	logger := wpsi.GetLogger().With("endpoint", subscription.Endpoint, "owner", subscription.Owner)
+	res, err := wpsi.sendToSubscription(ctx, wpsi.newPushMessage(n, data), subscription.Subscription)
+	if err != nil {
+		logger.Error("Sending test notification failed", "err", err)
+		return testNotificationResult{Error: err.Error()}
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+
+	logger.Info("Send test notification", "response", res.Status)
+	return testNotificationResult{StatusCode: res.StatusCode, Body: string(body)}
+}
+
+// findSubscription returns the stored subscription with endpoint, or nil.
+func (wpsi *webPushSenderImpl) findSubscription(ctx context.Context, endpoint string) (*WebPushSubscription, error) {
+	subscriptions, err := wpsi.subscriptionRepository.LoadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, subscription := range subscriptions {
+		if subscription.Endpoint == endpoint {
+			return &subscription, nil
+		}
+	}
+	return nil, nil
+}
+
+// authorizeSubscription reports whether r may change stored, answering the request when it
+// may not. Subscriptions stored before secrets were introduced have no secret and may be
+// deleted or tested by anyone knowing their endpoint.
+func (wpsi *webPushSenderImpl) authorizeSubscription(w http.ResponseWriter, r *http.Request, stored WebPushSubscription) bool {
+	if stored.SecretHash == "" || wpsi.isAdmin(r) {
+		return true
+	}
+	if token := bearerToken(r); token != "" && secretMatches(token, stored.SecretHash) {
+		return true
+	}
+	denyAccess(w, r)
+	return false
+}
+
+func (wpsi *webPushSenderImpl) isAdmin(r *http.Request) bool {
+	token := bearerToken(r)
+	return wpsi.adminToken != "" && token != "" && secretMatches(token, hashSubscriptionSecret(wpsi.adminToken))
+}
+
+// denyAccess answers 401 to requests without a bearer token and 403 to the others.
+func denyAccess(w http.ResponseWriter, r *http.Request) {
+	if bearerToken(r) == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "bearer token is required", http.StatusUnauthorized)
+		return
+	}
+	http.Error(w, "bearer token is not allowed", http.StatusForbidden)
+}
+
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func newSubscriptionSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashSubscriptionSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// secretMatches compares the hashes in constant time, so that answers do not tell how much of
+// a guessed token is right.
+func secretMatches(token string, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSubscriptionSecret(token)), []byte(hash)) == 1
+}
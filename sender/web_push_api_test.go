@@ -0,0 +1,272 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kotaro7750/notifier/test_util"
+)
+
+// apiRequest sends a request with an optional JSON body and bearer token to handler.
+func apiRequest(t *testing.T, handler http.Handler, method, path, body, token string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func mustSubscriptionSecret(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /subscriptions = %d %s, want 200", w.Code, w.Body)
+	}
+	var response subscriptionResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.Secret == "" {
+		t.Fatal("POST /subscriptions did not answer a secret")
+	}
+	return response.Secret
+}
+
+func TestWebPushAPIRequiresSubscriptionSecret(t *testing.T) {
+	wpsi, repository := newTestWebPushSender(t, nil)
+	wpsi.adminToken = "admin"
+	handler := wpsi.apiHandler()
+	body := `{"endpoint":"https://push.example.com/a","keys":{"p256dh":"p256dh","auth":"auth"},"secret_hash":"chosen"}`
+
+	secret := mustSubscriptionSecret(t, apiRequest(t, handler, http.MethodPost, "/subscriptions", body, ""))
+	stored := mustLoadSubscriptions(t, repository)["https://push.example.com/a"]
+	if stored.SecretHash != hashSubscriptionSecret(secret) {
+		t.Fatalf("SecretHash = %q, want the hash of the answered secret", stored.SecretHash)
+	}
+
+	if w := apiRequest(t, handler, http.MethodPost, "/subscriptions", body, ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Fatalf("POST without secret = %d, want 401 with WWW-Authenticate", w.Code)
+	}
+	if w := apiRequest(t, handler, http.MethodPost, "/subscriptions", body, "wrong"); w.Code != http.StatusForbidden {
+		t.Fatalf("POST with wrong secret = %d, want 403", w.Code)
+	}
+	if got := mustSubscriptionSecret(t, apiRequest(t, handler, http.MethodPost, "/subscriptions", body, secret)); got != secret {
+		t.Fatalf("secret = %q, want the secret to be kept", got)
+	}
+	if w := apiRequest(t, handler, http.MethodDelete, "/subscriptions", body, "wrong"); w.Code != http.StatusForbidden {
+		t.Fatalf("DELETE with wrong secret = %d, want 403", w.Code)
+	}
+	if w := apiRequest(t, handler, http.MethodDelete, "/subscriptions", body, secret); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE with secret = %d, want 204", w.Code)
+	}
+	if subscriptions := mustLoadSubscriptions(t, repository); len(subscriptions) != 0 {
+		t.Fatalf("subscriptions = %+v, want none", subscriptions)
+	}
+
+	mustSubscriptionSecret(t, apiRequest(t, handler, http.MethodPost, "/subscriptions", body, ""))
+	if w := apiRequest(t, handler, http.MethodDelete, "/subscriptions", body, "admin"); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE with admin token = %d, want 204", w.Code)
+	}
+}
+
+func TestWebPushAPIAllowsLegacySubscriptionsWithoutSecret(t *testing.T) {
+	wpsi, repository := newTestWebPushSender(t, nil)
+	repository.Store(context.Background(), WebPushSubscription{Subscription: mustSubscription(t, "https://push.example.com/a")})
+	handler := wpsi.apiHandler()
+	body := `{"endpoint":"https://push.example.com/a"}`
+
+	if w := apiRequest(t, handler, http.MethodDelete, "/subscriptions", body, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE of legacy subscription = %d, want 204", w.Code)
+	}
+	if subscriptions := mustLoadSubscriptions(t, repository); len(subscriptions) != 0 {
+		t.Fatalf("subscriptions = %+v, want none", subscriptions)
+	}
+}
+
+func TestWebPushAPILetsOnlyAdminSetSecretOfLegacySubscription(t *testing.T) {
+	wpsi, repository := newTestWebPushSender(t, nil)
+	repository.Store(context.Background(), WebPushSubscription{Subscription: mustSubscription(t, "https://push.example.com/a"), Owner: "alice"})
+	wpsi.adminToken = "admin"
+	handler := wpsi.apiHandler()
+	body := `{"endpoint":"https://push.example.com/a","keys":{"p256dh":"p256dh","auth":"auth"},"owner":"mallory"}`
+
+	if w := apiRequest(t, handler, http.MethodPost, "/subscriptions", body, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("POST of legacy subscription without token = %d, want 401", w.Code)
+	}
+	if w := apiRequest(t, handler, http.MethodPost, "/subscriptions", body, "guess"); w.Code != http.StatusForbidden {
+		t.Fatalf("POST of legacy subscription with wrong token = %d, want 403", w.Code)
+	}
+	if stored := mustLoadSubscriptions(t, repository)["https://push.example.com/a"]; stored.SecretHash != "" || stored.Owner != "alice" {
+		t.Fatalf("subscription = %+v, want it unchanged", stored)
+	}
+
+	secret := mustSubscriptionSecret(t, apiRequest(t, handler, http.MethodPost, "/subscriptions", body, "admin"))
+	if w := apiRequest(t, handler, http.MethodDelete, "/subscriptions", body, secret); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE with the secret given by the admin = %d, want 204", w.Code)
+	}
+}
+
+func TestWebPushAPIListsSubscriptionsOnlyToAdmin(t *testing.T) {
+	wpsi, _ := newTestWebPushSender(t, nil, "/a")
+	if w := apiRequest(t, wpsi.apiHandler(), http.MethodGet, "/subscriptions", "", "anything"); w.Code != http.StatusForbidden {
+		t.Fatalf("GET without adminToken configured = %d, want 403", w.Code)
+	}
+
+	wpsi.adminToken = "admin"
+	handler := wpsi.apiHandler()
+	if w := apiRequest(t, handler, http.MethodGet, "/subscriptions", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("GET without token = %d, want 401", w.Code)
+	}
+	if w := apiRequest(t, handler, http.MethodGet, "/subscriptions", "", "wrong"); w.Code != http.StatusForbidden {
+		t.Fatalf("GET with wrong token = %d, want 403", w.Code)
+	}
+	w := apiRequest(t, handler, http.MethodGet, "/subscriptions", "", "admin")
+	var endpoints []string
+	if err := json.NewDecoder(w.Body).Decode(&endpoints); err != nil || w.Code != http.StatusOK || len(endpoints) != 1 {
+		t.Fatalf("GET with admin token = %d %v, want one endpoint", w.Code, endpoints)
+	}
+}
+
+func TestWebPushAPIAppliesCORS(t *testing.T) {
+	preflight := func(handler http.Handler, origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodOptions, "/subscriptions", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		r.Header.Set("Access-Control-Request-Headers", "authorization,content-type")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	wpsi, _ := newTestWebPushSender(t, nil)
+	if got := preflight(wpsi.apiHandler(), "https://app.example.com").Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("Access-Control-Allow-Origin without cors = %q, want none", got)
+	}
+
+	wpsi.cors = &CORSProperties{AllowedOrigins: []string{"https://app.example.com"}, MaxAge: time.Hour}
+	handler := wpsi.apiHandler()
+	w := preflight(handler, "https://app.example.com")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Fatalf("Access-Control-Allow-Origin = %q, want the allowed origin", got)
+	}
+	if got := w.Header().Get("Access-Control-Max-Age"); got != "3600" {
+		t.Fatalf("Access-Control-Max-Age = %q, want 3600", got)
+	}
+	if got := preflight(handler, "https://evil.example.com").Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("Access-Control-Allow-Origin of other origin = %q, want none", got)
+	}
+}
+
+func TestWebPushSenderBuilderUsesAPIProperties(t *testing.T) {
+	t.Setenv(webPushAdminTokenEnvVar, "from-env")
+	properties := test_util.MustPropertiesNode(t, `
+listenAddress: :8091
+repositoryType: InMemory
+cors:
+  allowedOrigins: [https://app.example.com]
+`)
+
+	component, err := WebPushSenderBuilder("sender-1", properties)
+	if err != nil {
+		t.Fatalf("WebPushSenderBuilder returned error: %v", err)
+	}
+
+	impl := component.(*Sender).impl.(*webPushSenderImpl)
+	if impl.adminToken != "from-env" {
+		t.Fatalf("adminToken = %q, want the token of %s", impl.adminToken, webPushAdminTokenEnvVar)
+	}
+	if impl.cors == nil || impl.cors.AllowedOrigins[0] != "https://app.example.com" {
+		t.Fatalf("cors = %+v, want the configured origins", impl.cors)
+	}
+}
+
+func TestCORSPropertiesValidate(t *testing.T) {
+	tests := map[string]CORSProperties{
+		"missing origins": {},
+		"empty origin":    {AllowedOrigins: []string{""}},
+		"lower method":    {AllowedOrigins: []string{"*"}, AllowedMethods: []string{"get"}},
+		"negative maxAge": {AllowedOrigins: []string{"*"}, MaxAge: -time.Second},
+	}
+
+	for name, properties := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := properties.Validate(); err == nil {
+				t.Fatal("Validate unexpectedly succeeded")
+			}
+		})
+	}
+}
+
+func TestWebPushAPISendsTestNotificationToOneSubscription(t *testing.T) {
+	var lock sync.Mutex
+	requests := make(map[string]int)
+	wpsi, repository := newTestWebPushSender(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests[r.URL.Path]++
+		lock.Unlock()
+		http.Error(w, "subscription expired", http.StatusGone)
+	}, "/a", "/b")
+	handler := wpsi.apiHandler()
+
+	var subscription WebPushSubscription
+	for _, s := range mustLoadSubscriptions(t, repository) {
+		if strings.HasSuffix(s.Endpoint, "/a") {
+			subscription = s
+		}
+	}
+	subscription.SecretHash = hashSubscriptionSecret("secret")
+	repository.Store(context.Background(), subscription)
+	body := `{"endpoint":"` + subscription.Endpoint + `"}`
+
+	if w := apiRequest(t, handler, http.MethodPost, "/subscriptions/test", body, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("POST without secret = %d, want 401", w.Code)
+	}
+	if w := apiRequest(t, handler, http.MethodPost, "/subscriptions/test", `{"endpoint":"https://push.example.com/unknown"}`, "secret"); w.Code != http.StatusNotFound {
+		t.Fatalf("POST of unknown subscription = %d, want 404", w.Code)
+	}
+
+	w := apiRequest(t, handler, http.MethodPost, "/subscriptions/test", body, "secret")
+	var result testNotificationResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("POST with secret = %d, err %v, want 200", w.Code, err)
+	}
+	if result.StatusCode != http.StatusGone || strings.TrimSpace(result.Body) != "subscription expired" {
+		t.Fatalf("result = %+v, want the answer of the push service", result)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if requests["/a"] != 1 || requests["/b"] != 0 {
+		t.Fatalf("requests = %v, want one request to the tested subscription alone", requests)
+	}
+	if len(mustLoadSubscriptions(t, repository)) != 2 {
+		t.Fatal("tested subscription was deleted")
+	}
+}
+
+func TestWebPushAPIReportsUnreachablePushService(t *testing.T) {
+	wpsi, repository := newTestWebPushSender(t, nil)
+	repository.Store(context.Background(), WebPushSubscription{Subscription: mustSubscription(t, "http://127.0.0.1:1/a")})
+
+	w := apiRequest(t, wpsi.apiHandler(), http.MethodPost, "/subscriptions/test", `{"endpoint":"http://127.0.0.1:1/a"}`, "")
+	var result testNotificationResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("POST = %d, err %v, want 200", w.Code, err)
+	}
+	if result.Error == "" || result.StatusCode != 0 {
+		t.Fatalf("result = %+v, want the error of the request", result)
+	}
+}
@@ -0,0 +1,70 @@
+package sender
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+)
+
+const (
+	defaultSubscriptionPageTitle    = "Notifier"
+	defaultSubscriptionPageURLLabel = "url"
+)
+
+//go:embed webpush_page
+var subscriptionPageFiles embed.FS
+
+var subscriptionPageTemplate = template.Must(template.ParseFS(subscriptionPageFiles, "webpush_page/index.html"))
+
+// subscriptionPageStatic holds the files of the page served as they are.
+var subscriptionPageStatic = func() fs.FS {
+	static, err := fs.Sub(subscriptionPageFiles, "webpush_page")
+	if err != nil {
+		panic(err)
+	}
+	return static
+}()
+
+// SubscriptionPageProperties configures the subscription page served by the WebPush sender.
+// The page subscribes the browser with an optional owner and filter, and unsubscribes it. Its
+// service worker shows the title, message, severity and labels of notifications.
+type SubscriptionPageProperties struct {
+	// Title is the title of the page, Notifier by default.
+	Title string `yaml:"title"`
+	// URLLabel is the label holding the URL opened when a notification is clicked, url by
+	// default. Only http and https URLs are opened.
+	URLLabel string `yaml:"urlLabel"`
+}
+
+// withDefaults returns p with the defaults of its empty fields.
+func (p SubscriptionPageProperties) withDefaults() SubscriptionPageProperties {
+	if p.Title == "" {
+		p.Title = defaultSubscriptionPageTitle
+	}
+	if p.URLLabel == "" {
+		p.URLLabel = defaultSubscriptionPageURLLabel
+	}
+	return p
+}
+
+// registerSubscriptionPage serves the page at / of serveMux, next to the subscription API it
+// uses, and its service worker, script and icons.
+func registerSubscriptionPage(serveMux *http.ServeMux, p SubscriptionPageProperties) {
+	fileServer := http.FileServerFS(subscriptionPageStatic)
+
+	serveMux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := subscriptionPageTemplate.Execute(w, p); err != nil {
+			http.Error(w, fmt.Sprintf("rendering subscription page failed: %s", err), http.StatusInternalServerError)
+		}
+	})
+	serveMux.HandleFunc("GET /sw.js", func(w http.ResponseWriter, r *http.Request) {
+		// Browsers check for a new service worker on navigation, which caching would defeat.
+		w.Header().Set("Cache-Control", "no-cache")
+		fileServer.ServeHTTP(w, r)
+	})
+	serveMux.Handle("GET /app.js", fileServer)
+	serveMux.Handle("GET /icons/", fileServer)
+}
@@ -0,0 +1,83 @@
+package sender
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Kotaro7750/notifier/test_util"
+)
+
+func TestSubscriptionPageServesPageAndServiceWorker(t *testing.T) {
+	serveMux := http.NewServeMux()
+	registerSubscriptionPage(serveMux, SubscriptionPageProperties{Title: "On-call <alerts>", URLLabel: "runbook"})
+	server := httptest.NewServer(serveMux)
+	defer server.Close()
+
+	get := func(path string) (*http.Response, string) {
+		t.Helper()
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s returned error: %v", path, err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s = %s, want 200", path, res.Status)
+		}
+		return res, string(body)
+	}
+
+	_, page := get("/")
+	if !strings.Contains(page, "<title>On-call &lt;alerts&gt;</title>") {
+		t.Fatalf("page does not contain the escaped title:\n%s", page)
+	}
+	if !strings.Contains(page, `data-url-label="runbook"`) {
+		t.Fatalf("page does not contain the URL label:\n%s", page)
+	}
+
+	res, _ := get("/sw.js")
+	if got := res.Header.Get("Cache-Control"); got != "no-cache" {
+		t.Fatalf("Cache-Control of sw.js = %q, want no-cache", got)
+	}
+	if got := res.Header.Get("Content-Type"); !strings.Contains(got, "javascript") {
+		t.Fatalf("Content-Type of sw.js = %q, want JavaScript", got)
+	}
+
+	get("/app.js")
+	for _, severity := range []string{"debug", "info", "warning", "error", "critical"} {
+		get("/icons/" + severity + ".svg")
+	}
+}
+
+func TestSubscriptionPageEmbedsEveryFile(t *testing.T) {
+	for _, name := range []string{"index.html", "app.js", "sw.js"} {
+		if _, err := fs.Stat(subscriptionPageStatic, name); err != nil {
+			t.Fatalf("%s is not embedded: %v", name, err)
+		}
+	}
+}
+
+func TestWebPushSenderBuilderAppliesSubscriptionPageDefaults(t *testing.T) {
+	properties := test_util.MustPropertiesNode(t, `
+listenAddress: :8091
+repositoryType: InMemory
+subscriptionPage: {}
+`)
+
+	component, err := WebPushSenderBuilder("sender-1", properties)
+	if err != nil {
+		t.Fatalf("WebPushSenderBuilder returned error: %v", err)
+	}
+
+	impl := component.(*Sender).impl.(*webPushSenderImpl)
+	if impl.subscriptionPage == nil {
+		t.Fatal("subscriptionPage = nil, want the page served")
+	}
+	if impl.subscriptionPage.Title != "Notifier" || impl.subscriptionPage.URLLabel != "url" {
+		t.Fatalf("subscriptionPage = %+v, want defaults", *impl.subscriptionPage)
+	}
+}
@@ -0,0 +1,79 @@
+package sender
+
+import (
+	"fmt"
+
+	"github.com/Kotaro7750/notifier/notification"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+)
+
+// WebPushSubscription is a browser subscription stored by SubscriptionRepository, together
+// with the name of its owner and the filter choosing the notifications it receives. Storing a
+// subscription again with the same endpoint replaces its owner and filter.
+type WebPushSubscription struct {
+	webpush.Subscription
+	Owner string `json:"owner,omitempty" dynamodbav:",omitempty"`
+	// Filter selects the notifications sent to the subscription. A subscription without
+	// Filter receives every notification.
+	Filter *SubscriptionFilter `json:"filter,omitempty" dynamodbav:",omitempty"`
+	// SecretHash is the SHA-256 hash of the secret authenticating changes of the subscription.
+	// It is set by the sender, never by the browser.
+	SecretHash string `json:"secret_hash,omitempty" dynamodbav:",omitempty"`
+}
+
+func (s WebPushSubscription) Validate() error {
+	if s.Endpoint == "" {
+		return fmt.Errorf("endpoint is required")
+	}
+
+	if s.Filter != nil {
+		if err := s.Filter.Validate(); err != nil {
+			return fmt.Errorf("filter is invalid: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// SubscriptionFilter selects notifications. Empty fields select every notification.
+type SubscriptionFilter struct {
+	// Sources selects notifications from one of these notification_source values.
+	Sources []string `json:"sources,omitempty" dynamodbav:",omitempty"`
+	// Labels selects notifications having every label with one of the given values.
+	Labels map[string][]string `json:"labels,omitempty" dynamodbav:",omitempty"`
+	// MinSeverity selects notifications at least this severe.
+	MinSeverity *notification.Severity `json:"min_severity,omitempty" dynamodbav:",omitempty"`
+}
+
+func (f SubscriptionFilter) Validate() error {
+	for _, source := range f.Sources {
+		if source == "" {
+			return fmt.Errorf("sources must not contain an empty source")
+		}
+	}
+
+	for key, values := range f.Labels {
+		if key == "" {
+			return fmt.Errorf("labels must not contain an empty key")
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("labels.%s must contain at least one value", key)
+		}
+	}
+
+	return nil
+}
+
+// Matches reports whether n is selected by f. A nil filter selects every notification.
+func (f *SubscriptionFilter) Matches(n notification.Notification) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.MinSeverity != nil && n.Severity < *f.MinSeverity {
+		return false
+	}
+
+	return MatchCondition{NotificationSource: f.Sources, Labels: f.Labels}.IsMatched(n)
+}
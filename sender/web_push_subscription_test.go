@@ -0,0 +1,133 @@
+package sender
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Kotaro7750/notifier/notification"
+)
+
+func TestWebPushSubscriptionDecodesOwnerAndFilter(t *testing.T) {
+	t.Parallel()
+
+	var subscription WebPushSubscription
+	err := json.Unmarshal([]byte(`{
+		"endpoint": "https://push.example.com/a",
+		"keys": {"auth": "auth", "p256dh": "p256dh"},
+		"owner": "alice",
+		"filter": {"sources": ["billing"], "labels": {"env": ["prod"]}, "min_severity": "warning"}
+	}`), &subscription)
+	if err != nil {
+		t.Fatalf("Unmarshal returned error: %v", err)
+	}
+
+	if subscription.Endpoint != "https://push.example.com/a" || subscription.Keys.Auth != "auth" {
+		t.Fatalf("subscription = %+v, want the endpoint and keys of the body", subscription.Subscription)
+	}
+	if subscription.Owner != "alice" {
+		t.Fatalf("owner = %q, want %q", subscription.Owner, "alice")
+	}
+	if subscription.Filter == nil || subscription.Filter.MinSeverity == nil || *subscription.Filter.MinSeverity != notification.SeverityWarning {
+		t.Fatalf("filter = %+v, want min_severity warning", subscription.Filter)
+	}
+	if err := subscription.Validate(); err != nil {
+		t.Fatalf("Validate returned error: %v", err)
+	}
+}
+
+func TestWebPushSubscriptionValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]WebPushSubscription{
+		"missing endpoint": {},
+		"empty label values": {
+			Subscription: mustSubscription(t, "https://push.example.com/a"),
+			Filter:       &SubscriptionFilter{Labels: map[string][]string{"env": {}}},
+		},
+		"empty source": {
+			Subscription: mustSubscription(t, "https://push.example.com/a"),
+			Filter:       &SubscriptionFilter{Sources: []string{""}},
+		},
+	}
+
+	for name, subscription := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := subscription.Validate(); err == nil {
+				t.Fatal("Validate unexpectedly succeeded")
+			}
+		})
+	}
+}
+
+func TestSubscriptionFilterMatches(t *testing.T) {
+	t.Parallel()
+
+	warning := notification.SeverityWarning
+	filter := &SubscriptionFilter{
+		Sources:     []string{"billing", "payments"},
+		Labels:      map[string][]string{"env": {"prod", "staging"}},
+		MinSeverity: &warning,
+	}
+
+	tests := []struct {
+		name         string
+		filter       *SubscriptionFilter
+		notification notification.Notification
+		want         bool
+	}{
+		{
+			name:         "nil filter matches every notification",
+			filter:       nil,
+			notification: notification.Notification{NotificationSource: "ops", Severity: notification.SeverityDebug},
+			want:         true,
+		},
+		{
+			name:   "every condition holds",
+			filter: filter,
+			notification: notification.Notification{
+				NotificationSource: "billing",
+				Labels:             map[string]string{"env": "prod"},
+				Severity:           notification.SeverityError,
+			},
+			want: true,
+		},
+		{
+			name:   "source mismatch",
+			filter: filter,
+			notification: notification.Notification{
+				NotificationSource: "ops",
+				Labels:             map[string]string{"env": "prod"},
+				Severity:           notification.SeverityError,
+			},
+			want: false,
+		},
+		{
+			name:   "label mismatch",
+			filter: filter,
+			notification: notification.Notification{
+				NotificationSource: "billing",
+				Labels:             map[string]string{"env": "dev"},
+				Severity:           notification.SeverityError,
+			},
+			want: false,
+		},
+		{
+			name:   "below min severity",
+			filter: filter,
+			notification: notification.Notification{
+				NotificationSource: "billing",
+				Labels:             map[string]string{"env": "prod"},
+				Severity:           notification.SeverityInfo,
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Matches(tt.notification); got != tt.want {
+				t.Fatalf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
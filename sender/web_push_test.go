@@ -1,9 +1,24 @@
 package sender
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/Kotaro7750/notifier/notification"
 	"github.com/Kotaro7750/notifier/test_util"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
 )
 
 func TestWebPushSenderBuilderUsesTypedProperties(t *testing.T) {
@@ -25,8 +40,12 @@ defaultSubscriber: tester@example.com
 	if impl.defaultSubscriber != "tester@example.com" {
 		t.Fatalf("defaultSubscriber = %q, want %q", impl.defaultSubscriber, "tester@example.com")
 	}
-	if _, ok := impl.subscriptionRepository.(*InMemorySubscriptionRepository); !ok {
-		t.Fatalf("subscriptionRepository = %T, want *InMemorySubscriptionRepository", impl.subscriptionRepository)
+	cached, ok := impl.subscriptionRepository.(*cachedSubscriptionRepository)
+	if !ok {
+		t.Fatalf("subscriptionRepository = %T, want *cachedSubscriptionRepository", impl.subscriptionRepository)
+	}
+	if _, ok := cached.repository.(*InMemorySubscriptionRepository); !ok {
+		t.Fatalf("cached repository = %T, want *InMemorySubscriptionRepository", cached.repository)
 	}
 }
 
@@ -39,3 +58,509 @@ listenAddress: :8091
 		t.Fatal("WebPushSenderBuilder unexpectedly succeeded")
 	}
 }
+
+// newTestWebPushSender returns a sender whose subscriptions point at the given paths of a push
+// service answering with handle.
+func newTestWebPushSender(t *testing.T, handle http.HandlerFunc, paths ...string) (*webPushSenderImpl, *InMemorySubscriptionRepository) {
+	t.Helper()
+
+	server := httptest.NewServer(handle)
+	t.Cleanup(server.Close)
+
+	urgencies, err := parseWebPushUrgencyMapping(defaultWebPushUrgencyMapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttls, err := parseWebPushTTLMapping(defaultWebPushTTLMapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository := NewInMemorySubscriptionRepository()
+	for _, path := range paths {
+		repository.Store(context.Background(), WebPushSubscription{Subscription: mustSubscription(t, server.URL+path)})
+	}
+
+	return &webPushSenderImpl{
+		id:                     "sender-1",
+		logger:                 slog.New(slog.NewTextHandler(io.Discard, nil)),
+		subscriptionRepository: repository,
+		vapidKeys:              mustGenerateVAPIDKeys(t),
+		httpClient:             server.Client(),
+		maxRetries:             2,
+		maxRetryDelay:          time.Second,
+		retryBackoff:           time.Millisecond,
+		concurrency:            4,
+		requestTimeout:         time.Second,
+		urgencies:              urgencies,
+		ttls:                   ttls,
+	}, repository
+}
+
+func TestWebPushSenderHandlesEachSubscriptionOutcome(t *testing.T) {
+	var lock sync.Mutex
+	attempts := make(map[string]int)
+	wpsi, repository := newTestWebPushSender(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		attempts[r.URL.Path]++
+		attempt := attempts[r.URL.Path]
+		lock.Unlock()
+
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/flaky":
+			if attempt == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case "/throttled":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/rejected":
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}, "/ok", "/gone", "/flaky", "/throttled", "/rejected")
+
+	var deliveryErr error
+	reported := false
+	wpsi.onDelivery = func(n notification.Notification, err error) {
+		reported = true
+		deliveryErr = err
+	}
+
+	if err := wpsi.deliver(context.Background(), notification.Notification{Title: "disk full"}); err != nil {
+		t.Fatalf("deliver() error = %v", err)
+	}
+
+	if !reported || deliveryErr != nil {
+		t.Fatalf("reported = %v, delivery error = %v, want delivered", reported, deliveryErr)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if attempts["/flaky"] != 2 {
+		t.Fatalf("attempts to /flaky = %d, want 2", attempts["/flaky"])
+	}
+	if attempts["/throttled"] != 3 {
+		t.Fatalf("attempts to /throttled = %d, want 3", attempts["/throttled"])
+	}
+	if attempts["/rejected"] != 1 {
+		t.Fatalf("attempts to /rejected = %d, want 1", attempts["/rejected"])
+	}
+
+	subscriptions, _ := repository.LoadAll(context.Background())
+	if len(subscriptions) != 4 {
+		t.Fatalf("len(subscriptions) = %d, want 4", len(subscriptions))
+	}
+	for _, subscription := range subscriptions {
+		if strings.HasSuffix(subscription.Endpoint, "/gone") {
+			t.Fatal("expired subscription was not deleted")
+		}
+	}
+}
+
+func TestWebPushSenderReportsFailureWhenNoSubscriptionIsDelivered(t *testing.T) {
+	wpsi, _ := newTestWebPushSender(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}, "/a", "/b")
+
+	var deliveryErr error
+	wpsi.onDelivery = func(n notification.Notification, err error) {
+		deliveryErr = err
+	}
+
+	if err := wpsi.deliver(context.Background(), notification.Notification{Title: "disk full"}); err != nil {
+		t.Fatalf("deliver() error = %v", err)
+	}
+	if deliveryErr == nil {
+		t.Fatal("delivery error = nil, want failure")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		"seconds":   {value: "120", want: 2 * time.Minute, wantOk: true},
+		"http date": {value: "Mon, 01 Jan 2024 00:00:30 GMT", want: 30 * time.Second, wantOk: true},
+		"past date": {value: "Sun, 31 Dec 2023 23:00:00 GMT", want: 0, wantOk: true},
+		"empty":     {value: "", wantOk: false},
+		"invalid":   {value: "soon", wantOk: false},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, ok := retryAfter(tt.value, now)
+			if ok != tt.wantOk || got != tt.want {
+				t.Fatalf("retryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestWebPushSenderSendsToSubscriptionsConcurrently(t *testing.T) {
+	// Every request waits until all of them have arrived, which only happens when they are
+	// sent concurrently.
+	const subscriptions = 4
+	var arrived sync.WaitGroup
+	arrived.Add(subscriptions)
+	wpsi, _ := newTestWebPushSender(t, func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		arrived.Wait()
+		w.WriteHeader(http.StatusCreated)
+	}, "/a", "/b", "/c", "/d")
+
+	var deliveryErr error
+	wpsi.onDelivery = func(n notification.Notification, err error) {
+		deliveryErr = err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wpsi.deliver(context.Background(), notification.Notification{Title: "disk full"})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deliver() did not send concurrently")
+	}
+	if deliveryErr != nil {
+		t.Fatalf("delivery error = %v", deliveryErr)
+	}
+}
+
+func TestWebPushSenderTimesOutSlowPushService(t *testing.T) {
+	release := make(chan struct{})
+	wpsi, _ := newTestWebPushSender(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.WriteHeader(http.StatusCreated)
+	}, "/slow", "/fast")
+	defer close(release)
+	wpsi.requestTimeout = 50 * time.Millisecond
+
+	var deliveryErr error
+	wpsi.onDelivery = func(n notification.Notification, err error) {
+		deliveryErr = err
+	}
+
+	start := time.Now()
+	if err := wpsi.deliver(context.Background(), notification.Notification{Title: "disk full"}); err != nil {
+		t.Fatalf("deliver() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("deliver() took %v, want the slow request to time out", elapsed)
+	}
+	if deliveryErr != nil {
+		t.Fatalf("delivery error = %v, want delivered to the fast subscription", deliveryErr)
+	}
+}
+
+type failingSubscriptionRepository struct {
+	*InMemorySubscriptionRepository
+}
+
+func (fsr failingSubscriptionRepository) LoadAll(ctx context.Context) ([]WebPushSubscription, error) {
+	return nil, errors.New("repository unavailable")
+}
+
+type closingSubscriptionRepository struct {
+	*InMemorySubscriptionRepository
+	closed bool
+}
+
+func (csr *closingSubscriptionRepository) Close() error {
+	csr.closed = true
+	return nil
+}
+
+func TestWebPushSenderClosesRepositoryWhenStopped(t *testing.T) {
+	wpsi, repository := newTestWebPushSender(t, nil)
+	closing := &closingSubscriptionRepository{InMemorySubscriptionRepository: repository}
+	wpsi.subscriptionRepository = newCachedSubscriptionRepository(closing, time.Minute)
+	wpsi.listenAddress = "127.0.0.1:0"
+
+	done := make(chan struct{})
+	retCh := wpsi.Start(make(chan notification.Notification), done)
+	close(done)
+	for range retCh {
+	}
+
+	if !closing.closed {
+		t.Fatal("subscription repository was not closed")
+	}
+}
+
+func TestWebPushSenderStopsDeliveringWhenServerFails(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	wpsi, _ := newTestWebPushSender(t, nil)
+	wpsi.listenAddress = listener.Addr().String()
+
+	inputCh := make(chan notification.Notification)
+	done := make(chan struct{})
+	defer close(done)
+	if err := <-wpsi.Start(inputCh, done); err == nil {
+		t.Fatal("Start() did not report the listen failure")
+	}
+
+	// done stays open until a restart, so a notification must be left to the next run.
+	select {
+	case inputCh <- notification.Notification{Title: "disk full"}:
+		t.Fatal("failed run is still reading notifications")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebPushSenderReportsRepositoryFailureWithoutStopping(t *testing.T) {
+	wpsi, repository := newTestWebPushSender(t, nil)
+	wpsi.subscriptionRepository = failingSubscriptionRepository{InMemorySubscriptionRepository: repository}
+
+	var deliveryErr error
+	wpsi.onDelivery = func(n notification.Notification, err error) {
+		deliveryErr = err
+	}
+
+	if err := wpsi.deliver(context.Background(), notification.Notification{Title: "disk full"}); err != nil {
+		t.Fatalf("deliver() error = %v, want the sender to keep running", err)
+	}
+	if deliveryErr == nil {
+		t.Fatal("repository failure was not reported")
+	}
+}
+
+type countingSubscriptionRepository struct {
+	*InMemorySubscriptionRepository
+	loads int
+}
+
+func (csr *countingSubscriptionRepository) LoadAll(ctx context.Context) ([]WebPushSubscription, error) {
+	csr.loads++
+	return csr.InMemorySubscriptionRepository.LoadAll(ctx)
+}
+
+func TestCachedSubscriptionRepositoryInvalidatesOnChange(t *testing.T) {
+	counting := &countingSubscriptionRepository{InMemorySubscriptionRepository: NewInMemorySubscriptionRepository()}
+	cached := newCachedSubscriptionRepository(counting, time.Hour)
+
+	cached.Store(context.Background(), WebPushSubscription{Subscription: webpush.Subscription{Endpoint: "https://push.example.com/a"}})
+	for range 3 {
+		subscriptions, err := cached.LoadAll(context.Background())
+		if err != nil {
+			t.Fatalf("LoadAll() error = %v", err)
+		}
+		if len(subscriptions) != 1 {
+			t.Fatalf("len(subscriptions) = %d, want 1", len(subscriptions))
+		}
+	}
+	if counting.loads != 1 {
+		t.Fatalf("loads = %d, want 1", counting.loads)
+	}
+
+	cached.Delete(context.Background(), WebPushSubscription{Subscription: webpush.Subscription{Endpoint: "https://push.example.com/a"}})
+	subscriptions, _ := cached.LoadAll(context.Background())
+	if len(subscriptions) != 0 || counting.loads != 2 {
+		t.Fatalf("after Delete: len(subscriptions) = %d, loads = %d, want 0, 2", len(subscriptions), counting.loads)
+	}
+}
+
+func TestWebPushSenderSendsOnlyToMatchingSubscriptions(t *testing.T) {
+	var lock sync.Mutex
+	received := make(map[string]int)
+	wpsi, repository := newTestWebPushSender(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		received[r.URL.Path]++
+		lock.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}, "/everything")
+
+	warning := notification.SeverityWarning
+	endpoint := strings.TrimSuffix(mustLoadEndpoint(t, repository), "/everything")
+	repository.Store(context.Background(), WebPushSubscription{
+		Subscription: mustSubscription(t, endpoint+"/billing"),
+		Owner:        "alice",
+		Filter:       &SubscriptionFilter{Sources: []string{"billing"}},
+	})
+	repository.Store(context.Background(), WebPushSubscription{
+		Subscription: mustSubscription(t, endpoint+"/severe"),
+		Owner:        "bob",
+		Filter:       &SubscriptionFilter{MinSeverity: &warning},
+	})
+
+	if err := wpsi.deliver(context.Background(), notification.Notification{NotificationSource: "billing", Severity: notification.SeverityInfo}); err != nil {
+		t.Fatalf("deliver() error = %v", err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if received["/everything"] != 1 || received["/billing"] != 1 || received["/severe"] != 0 {
+		t.Fatalf("received = %v, want /everything and /billing only", received)
+	}
+}
+
+// mustLoadEndpoint returns the endpoint of the only subscription of repository.
+func mustLoadEndpoint(t *testing.T, repository SubscriptionRepository) string {
+	t.Helper()
+
+	subscriptions, err := repository.LoadAll(context.Background())
+	if err != nil || len(subscriptions) != 1 {
+		t.Fatalf("LoadAll() = %v, %v, want one subscription", subscriptions, err)
+	}
+	return subscriptions[0].Endpoint
+}
+
+func TestWebPushSenderSetsHeadersFromNotification(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	wpsi, _ := newTestWebPushSender(t, func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		w.WriteHeader(http.StatusCreated)
+	}, "/a")
+	wpsi.topicLabel = "alertname"
+
+	n := notification.Notification{
+		Severity: notification.SeverityCritical,
+		Labels:   map[string]string{"alertname": "DiskFull"},
+	}
+	if err := wpsi.deliver(context.Background(), n); err != nil {
+		t.Fatalf("deliver() error = %v", err)
+	}
+
+	header := <-headers
+	if got := header.Get("Urgency"); got != "high" {
+		t.Fatalf("Urgency = %q, want %q", got, "high")
+	}
+	if got := header.Get("TTL"); got != "86400" {
+		t.Fatalf("TTL = %q, want %q", got, "86400")
+	}
+	if got := header.Get("Topic"); got != webPushTopic("DiskFull") || len(got) != maxWebPushTopicLength {
+		t.Fatalf("Topic = %q, want %q", got, webPushTopic("DiskFull"))
+	}
+}
+
+func TestWebPushSenderOmitsTopicWithoutTopicLabel(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	wpsi, _ := newTestWebPushSender(t, func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		w.WriteHeader(http.StatusCreated)
+	}, "/a")
+	wpsi.topicLabel = "alertname"
+
+	if err := wpsi.deliver(context.Background(), notification.Notification{Severity: notification.SeverityDebug}); err != nil {
+		t.Fatalf("deliver() error = %v", err)
+	}
+
+	header := <-headers
+	if got := header.Get("Urgency"); got != "very-low" {
+		t.Fatalf("Urgency = %q, want %q", got, "very-low")
+	}
+	if got := header.Get("Topic"); got != "" {
+		t.Fatalf("Topic = %q, want none", got)
+	}
+}
+
+func TestWebPushSenderBuilderUsesConfiguredPushOptions(t *testing.T) {
+	properties := test_util.MustPropertiesNode(t, `
+listenAddress: :8091
+repositoryType: InMemory
+urgencyMapping:
+  debug: low
+  critical: high
+ttlMapping:
+  debug: 30m
+  error: 48h
+topicLabel: alertname
+`)
+
+	component, err := WebPushSenderBuilder("sender-1", properties)
+	if err != nil {
+		t.Fatalf("WebPushSenderBuilder returned error: %v", err)
+	}
+
+	impl := component.(*Sender).impl.(*webPushSenderImpl)
+	if got := impl.urgencies.lookup(notification.SeverityError); got != webpush.UrgencyLow {
+		t.Fatalf("urgency of error = %q, want %q", got, webpush.UrgencyLow)
+	}
+	if got := impl.ttls.lookup(notification.SeverityCritical); got != 48*time.Hour {
+		t.Fatalf("ttl of critical = %v, want %v", got, 48*time.Hour)
+	}
+	if impl.topicLabel != "alertname" {
+		t.Fatalf("topicLabel = %q, want %q", impl.topicLabel, "alertname")
+	}
+}
+
+func TestWebPushSenderPropertiesRejectsInvalidPushOptions(t *testing.T) {
+	tests := map[string]string{
+		"unknown urgency": `
+urgencyMapping:
+  error: urgent
+`,
+		"negative ttl": `
+ttlMapping:
+  error: -1h
+`,
+		"ttl over four weeks": `
+ttlMapping:
+  error: 700h
+`,
+	}
+
+	for name, extra := range tests {
+		t.Run(name, func(t *testing.T) {
+			properties := test_util.MustPropertiesNode(t, `
+listenAddress: :8091
+repositoryType: InMemory
+`+extra)
+			if _, err := WebPushSenderBuilder("sender-1", properties); err == nil {
+				t.Fatal("WebPushSenderBuilder unexpectedly succeeded")
+			}
+		})
+	}
+}
+
+func TestWebPushSenderBuilderUsesFileRepository(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+	properties := test_util.MustPropertiesNode(t, `
+listenAddress: :8091
+repositoryType: File
+repositoryPath: `+path+`
+subscriptionCacheTTL: 0s
+`)
+
+	component, err := WebPushSenderBuilder("sender-1", properties)
+	if err != nil {
+		t.Fatalf("WebPushSenderBuilder returned error: %v", err)
+	}
+
+	impl := component.(*Sender).impl.(*webPushSenderImpl)
+	if _, ok := impl.subscriptionRepository.(*FileSubscriptionRepository); !ok {
+		t.Fatalf("subscriptionRepository = %T, want *FileSubscriptionRepository", impl.subscriptionRepository)
+	}
+	if impl.ephemeralVAPIDKeys {
+		t.Fatal("ephemeralVAPIDKeys = true, want keys kept next to the repository")
+	}
+	if _, err := os.Stat(path + vapidKeysFileSuffix); err != nil {
+		t.Fatalf("VAPID key file was not written: %v", err)
+	}
+
+	component, err = WebPushSenderBuilder("sender-1", properties)
+	if err != nil {
+		t.Fatalf("WebPushSenderBuilder returned error on restart: %v", err)
+	}
+	if got := component.(*Sender).impl.(*webPushSenderImpl).vapidKeys; got != impl.vapidKeys {
+		t.Fatalf("vapidKeys after restart = %+v, want %+v", got, impl.vapidKeys)
+	}
+}
@@ -0,0 +1,239 @@
+// Subscribes this browser to the webPush sender serving the page, and stores the subscription
+// with its owner and filter through POST /subscriptions. The secret answered by the sender is
+// kept in localStorage to authenticate later changes of the subscription, and the owner and
+// filter to subscribe again when the VAPID key of the sender changes.
+"use strict";
+
+const form = document.getElementById("subscription");
+const subscribeButton = document.getElementById("subscribe");
+const unsubscribeButton = document.getElementById("unsubscribe");
+const testButton = document.getElementById("test");
+const status = document.getElementById("status");
+const urlLabel = document.body.dataset.urlLabel;
+const secretKey = "notifier-subscription-secret";
+const settingsKey = "notifier-subscription-settings";
+
+function setStatus(text) {
+  status.textContent = text;
+}
+
+function showSubscribed(subscribed) {
+  subscribeButton.textContent = subscribed ? "Save" : "Subscribe";
+  unsubscribeButton.hidden = !subscribed;
+  testButton.hidden = !subscribed;
+}
+
+function splitList(value) {
+  return value.split(",").map((item) => item.trim()).filter((item) => item !== "");
+}
+
+// filter builds the filter of the subscription from the form, or undefined to receive every
+// notification.
+function filter() {
+  const result = {};
+
+  const sources = splitList(document.getElementById("sources").value);
+  if (sources.length > 0) {
+    result.sources = sources;
+  }
+
+  for (const pair of splitList(document.getElementById("labels").value)) {
+    const [key, ...rest] = pair.split("=");
+    const value = rest.join("=").trim();
+    if (key.trim() === "" || value === "") {
+      throw new Error(`Label "${pair}" should be key=value`);
+    }
+    result.labels = result.labels || {};
+    (result.labels[key.trim()] = result.labels[key.trim()] || []).push(value);
+  }
+
+  const minSeverity = document.getElementById("minSeverity").value;
+  if (minSeverity !== "") {
+    result.min_severity = minSeverity;
+  }
+
+  return Object.keys(result).length > 0 ? result : undefined;
+}
+
+async function send(method, body, path = "subscriptions") {
+  const headers = { "Content-Type": "application/json" };
+  const secret = localStorage.getItem(secretKey);
+  if (secret !== null) {
+    headers.Authorization = `Bearer ${secret}`;
+  }
+  const response = await fetch(path, {
+    method: method,
+    headers: headers,
+    body: JSON.stringify(body),
+  });
+  if (!response.ok) {
+    throw new Error(`${method} ${path} failed: ${response.status} ${(await response.text()).trim()}`);
+  }
+  return response;
+}
+
+async function registration() {
+  return navigator.serviceWorker.register(`sw.js?urlLabel=${encodeURIComponent(urlLabel)}`);
+}
+
+async function publicKey() {
+  const response = await fetch("publickey");
+  if (!response.ok) {
+    throw new Error(`GET publickey failed: ${response.status}`);
+  }
+  return (await response.text()).trim();
+}
+
+// base64url encodes a key as the sender serves it.
+function base64url(buffer) {
+  return btoa(String.fromCharCode(...new Uint8Array(buffer)))
+    .replace(/\+/g, "-")
+    .replace(/\//g, "_")
+    .replace(/=+$/, "");
+}
+
+// madeWithPreviousKey reports whether subscription was made with another key than the current
+// key of the sender.
+function madeWithPreviousKey(subscription, key) {
+  const serverKey = subscription.options.applicationServerKey;
+  return serverKey !== null && base64url(serverKey) !== key;
+}
+
+// currentSubscription returns the subscription of this browser made with key. A subscription
+// made with a previous key is removed and replaced, so that the previous key can be retired.
+async function currentSubscription(reg, key) {
+  let subscription = await reg.pushManager.getSubscription();
+  if (subscription !== null && madeWithPreviousKey(subscription, key)) {
+    try {
+      await send("DELETE", subscription.toJSON());
+    } catch (err) {
+      // The sender deletes it anyway once the push service reports it as expired.
+      console.warn(err);
+    }
+    await subscription.unsubscribe();
+    localStorage.removeItem(secretKey);
+    subscription = null;
+  }
+  if (subscription === null) {
+    subscription = await reg.pushManager.subscribe({
+      userVisibleOnly: true,
+      applicationServerKey: key,
+    });
+  }
+  return subscription;
+}
+
+// store posts subscription with the owner and filter of settings.
+async function store(subscription, settings) {
+  const body = subscription.toJSON();
+  if (settings.owner) {
+    body.owner = settings.owner;
+  }
+  body.filter = settings.filter;
+  const response = await send("POST", body);
+  const { secret } = await response.json();
+  if (secret) {
+    localStorage.setItem(secretKey, secret);
+  }
+  localStorage.setItem(settingsKey, JSON.stringify(settings));
+}
+
+async function subscribe() {
+  const reg = await registration();
+  const subscription = await currentSubscription(reg, await publicKey());
+  const owner = document.getElementById("owner").value.trim();
+  await store(subscription, { owner: owner !== "" ? owner : undefined, filter: filter() });
+}
+
+// moveToCurrentKey subscribes again with the current key of the sender when the subscription
+// of this browser was made with a previous one, keeping its owner and filter.
+async function moveToCurrentKey(reg) {
+  const subscription = await reg.pushManager.getSubscription();
+  const key = await publicKey();
+  if (subscription === null || !madeWithPreviousKey(subscription, key)) {
+    return false;
+  }
+  const settings = JSON.parse(localStorage.getItem(settingsKey) || "{}");
+  await store(await currentSubscription(reg, key), settings);
+  return true;
+}
+
+async function unsubscribe() {
+  const reg = await registration();
+  const subscription = await reg.pushManager.getSubscription();
+  if (subscription === null) {
+    return;
+  }
+  await send("DELETE", subscription.toJSON());
+  await subscription.unsubscribe();
+  localStorage.removeItem(secretKey);
+  localStorage.removeItem(settingsKey);
+}
+
+form.addEventListener("submit", async (event) => {
+  event.preventDefault();
+  try {
+    if (await Notification.requestPermission() !== "granted") {
+      setStatus("Notifications are not allowed for this page.");
+      return;
+    }
+    await subscribe();
+    showSubscribed(true);
+    setStatus("Subscribed.");
+  } catch (err) {
+    setStatus(err.message);
+  }
+});
+
+// test sends a test notification to this browser alone, and describes the answer of the push
+// service.
+async function test() {
+  const reg = await registration();
+  const subscription = await reg.pushManager.getSubscription();
+  if (subscription === null) {
+    throw new Error("This browser is not subscribed.");
+  }
+  const result = await (await send("POST", subscription.toJSON(), "subscriptions/test")).json();
+  if (result.error) {
+    return `The push service could not be reached: ${result.error}`;
+  }
+  if (result.status_code >= 200 && result.status_code < 300) {
+    return "Test notification sent.";
+  }
+  return `The push service answered ${result.status_code}: ${(result.body || "").trim()}`;
+}
+
+testButton.addEventListener("click", async () => {
+  try {
+    setStatus(await test());
+  } catch (err) {
+    setStatus(err.message);
+  }
+});
+
+unsubscribeButton.addEventListener("click", async () => {
+  try {
+    await unsubscribe();
+    showSubscribed(false);
+    setStatus("Unsubscribed.");
+  } catch (err) {
+    setStatus(err.message);
+  }
+});
+
+(async () => {
+  if (!("serviceWorker" in navigator) || !("PushManager" in window)) {
+    document.getElementById("unsupported").hidden = false;
+    form.hidden = true;
+    return;
+  }
+  const reg = await registration();
+  showSubscribed((await reg.pushManager.getSubscription()) !== null);
+  try {
+    if (await moveToCurrentKey(reg)) {
+      setStatus("Subscribed again with the current key of the sender.");
+    }
+  } catch (err) {
+    setStatus(err.message);
+  }
+})();
@@ -0,0 +1,82 @@
+// Service worker displaying the notifications pushed by the webPush sender. The payload is a
+// notification as JSON. The label named by the urlLabel query parameter holds the URL opened
+// when the notification is clicked.
+"use strict";
+
+const urlLabel = new URL(self.location.href).searchParams.get("urlLabel") || "url";
+// severities are the named severities from the most severe, with their values on the integer
+// scale of the payload.
+const severities = [
+  ["critical", 12],
+  ["error", 8],
+  ["warning", 4],
+  ["info", 0],
+  ["debug", -4],
+];
+
+// severityName returns the closest named severity at or below severity, which is an integer
+// in payloads, or a name possibly followed by an offset such as "error+2".
+function severityName(severity) {
+  if (typeof severity === "number") {
+    const named = severities.find(([, value]) => severity >= value);
+    return named ? named[0] : "debug";
+  }
+  const name = String(severity || "info").split(/[+-]/)[0].toLowerCase();
+  return severities.some(([named]) => named === name) ? name : "info";
+}
+
+// safeURL returns url resolved against this origin when it is http or https.
+function safeURL(url) {
+  try {
+    const resolved = new URL(url, self.location.origin);
+    return resolved.protocol === "http:" || resolved.protocol === "https:" ? resolved.href : null;
+  } catch {
+    return null;
+  }
+}
+
+self.addEventListener("push", (event) => {
+  let n = {};
+  try {
+    n = event.data ? event.data.json() : {};
+  } catch {
+    n = { message: event.data.text() };
+  }
+
+  const labels = n.labels || {};
+  const lines = [];
+  if (n.message) {
+    lines.push(n.message);
+  }
+  for (const [key, value] of Object.entries(labels)) {
+    if (key !== urlLabel) {
+      lines.push(`${key}: ${value}`);
+    }
+  }
+
+  const severity = severityName(n.severity);
+  event.waitUntil(self.registration.showNotification(n.title || "Notification", {
+    body: lines.join("\n"),
+    icon: `icons/${severity}.svg`,
+    badge: `icons/${severity}.svg`,
+    tag: n.id || undefined,
+    requireInteraction: severity === "error" || severity === "critical",
+    data: { url: safeURL(labels[urlLabel]) },
+  }));
+});
+
+self.addEventListener("notificationclick", (event) => {
+  event.notification.close();
+  const url = event.notification.data && event.notification.data.url;
+  if (url) {
+    event.waitUntil(self.clients.openWindow(url));
+    return;
+  }
+
+  event.waitUntil(self.clients.matchAll({ type: "window" }).then((windows) => {
+    if (windows.length > 0) {
+      return windows[0].focus();
+    }
+    return self.clients.openWindow(self.registration.scope);
+  }));
+});
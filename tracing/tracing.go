@@ -0,0 +1,169 @@
+// Package tracing instruments the path of a notification from its receiver to its senders with
+// OpenTelemetry spans. The W3C trace context travels with the notification in its TraceContext,
+// so that spans created by different components belong to the same trace. Until Setup is
+// called, spans are not recorded but trace context is still propagated.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/Kotaro7750/notifier/config"
+	"github.com/Kotaro7750/notifier/notification"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentationName = "github.com/Kotaro7750/notifier"
+	defaultServiceName  = "notifier"
+)
+
+// propagator is used regardless of the global propagator, so that trace context is always
+// propagated.
+var propagator = propagation.TraceContext{}
+
+// Setup installs the tracer provider exporting spans as configured. The returned function
+// flushes and stops the exporter.
+func Setup(ctx context.Context, tracingConfig config.TracingConfig) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	switch tracingConfig.Exporter {
+	case config.TracingExporterStdout:
+		stdoutExporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, err
+		}
+		exporter = stdoutExporter
+	case config.TracingExporterOTLP:
+		options := make([]otlptracehttp.Option, 0)
+		if tracingConfig.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(tracingConfig.Endpoint))
+		}
+		if tracingConfig.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		if len(tracingConfig.Headers) > 0 {
+			options = append(options, otlptracehttp.WithHeaders(tracingConfig.Headers))
+		}
+		otlpExporter, err := otlptracehttp.New(ctx, options...)
+		if err != nil {
+			return nil, err
+		}
+		exporter = otlpExporter
+	default:
+		return nil, fmt.Errorf("exporter %s is not supported", tracingConfig.Exporter)
+	}
+
+	serviceName := tracingConfig.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	sampleRatio := 1.0
+	if tracingConfig.SampleRatio != nil {
+		sampleRatio = *tracingConfig.SampleRatio
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span whose parent is the trace context of n.
+func Start(n notification.Notification, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return StartWithContext(Extract(context.Background(), n), name, opts...)
+}
+
+// StartWithContext starts a span that is a child of the span in ctx.
+func StartWithContext(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject stores the trace context of ctx in n. It replaces TraceContext rather than modifying
+// it, since notifications broadcast to several senders share the map.
+func Inject(ctx context.Context, n *notification.Notification) {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return
+	}
+	n.TraceContext = carrier
+}
+
+// Extract returns ctx with the trace context stored in n.
+func Extract(ctx context.Context, n notification.Notification) context.Context {
+	if len(n.TraceContext) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(n.TraceContext))
+}
+
+// ExtractHTTP returns the context of r with the trace context of its headers.
+func ExtractHTTP(r *http.Request) context.Context {
+	return propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+}
+
+// NewTransport wraps base so that every outgoing request is traced by a client span and carries
+// the trace context of its request context in its headers. base defaults to
+// http.DefaultTransport.
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, span := StartWithContext(r.Context(), "HTTP "+r.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("server.address", r.URL.Host),
+		),
+	)
+
+	r = r.Clone(ctx)
+	propagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+	res, err := t.base.RoundTrip(r)
+	if err != nil {
+		End(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+	if res.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, res.Status)
+	}
+	span.End()
+	return res, nil
+}
@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Kotaro7750/notifier/notification"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func useRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+	return recorder
+}
+
+func TestInjectExtractRoundTrip(t *testing.T) {
+	useRecorder(t)
+
+	ctx, span := StartWithContext(context.Background(), "parent")
+	defer span.End()
+
+	var n notification.Notification
+	Inject(ctx, &n)
+	if n.TraceContext["traceparent"] == "" {
+		t.Fatalf("TraceContext = %v, want traceparent", n.TraceContext)
+	}
+
+	extracted := trace.SpanContextFromContext(Extract(context.Background(), n))
+	if extracted.TraceID() != span.SpanContext().TraceID() || extracted.SpanID() != span.SpanContext().SpanID() {
+		t.Fatalf("extracted span context = %v, want %v", extracted, span.SpanContext())
+	}
+}
+
+func TestStartContinuesTraceOfNotification(t *testing.T) {
+	recorder := useRecorder(t)
+
+	ctx, parent := StartWithContext(context.Background(), "notifier.receive")
+	var n notification.Notification
+	Inject(ctx, &n)
+	parent.End()
+
+	_, child := Start(n, "notifier.send")
+	child.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("len(spans) = %d, want 2", len(spans))
+	}
+	if spans[1].Parent().SpanID() != spans[0].SpanContext().SpanID() {
+		t.Fatalf("parent of %s = %v, want %v", spans[1].Name(), spans[1].Parent().SpanID(), spans[0].SpanContext().SpanID())
+	}
+}
+
+func TestInjectDoesNotModifySharedTraceContext(t *testing.T) {
+	useRecorder(t)
+
+	shared := map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	n := notification.Notification{TraceContext: shared}
+
+	ctx, span := Start(n, "notifier.route")
+	defer span.End()
+	Inject(ctx, &n)
+
+	if shared["traceparent"] != "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01" {
+		t.Fatalf("shared TraceContext was modified: %v", shared)
+	}
+	if n.TraceContext["traceparent"] == shared["traceparent"] {
+		t.Fatal("TraceContext was not replaced")
+	}
+}
+
+func TestTransportInjectsTraceContext(t *testing.T) {
+	recorder := useRecorder(t)
+
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	ctx, parent := StartWithContext(context.Background(), "notifier.send")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := (&http.Client{Transport: NewTransport(nil)}).Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	res.Body.Close()
+	parent.End()
+
+	traceparent := <-received
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("len(spans) = %d, want 2", len(spans))
+	}
+	client := spans[0]
+	if client.SpanKind() != trace.SpanKindClient || client.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("client span = %s (kind %v), want child of notifier.send", client.Name(), client.SpanKind())
+	}
+	want := "00-" + client.SpanContext().TraceID().String() + "-" + client.SpanContext().SpanID().String() + "-01"
+	if traceparent != want {
+		t.Fatalf("traceparent = %q, want %q", traceparent, want)
+	}
+}